@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+)
+
+// DefaultSlabSize is the number of nodes per slab of NewTreeWithArena when
+// no positive size is given.
+const DefaultSlabSize = 1024
+
+// slabs holds released slabs of every tree for reuse by other arenas.
+var slabs sync.Pool
+
+// nodeArena hands out nodes sequentially from slabs of []Node.
+type nodeArena struct {
+	slabSize int
+	slabs    [][]Node // all slabs in use; the last one is being filled
+	next     int      // index of the next free node in the last slab
+}
+
+// NewTreeWithArena returns an empty Tree with comparator `IntComparator`
+// whose nodes are carved out of slabs of `slabSize` nodes (DefaultSlabSize
+// if not positive) instead of being allocated one by one. It suits trees
+// that are built, queried and then dropped as a whole, such as per-request
+// indexes: the garbage collector sees a few large objects, and Release
+// hands the slabs to the next arena tree.
+//
+// Lifetime rules:
+//   - Delete only unlinks a node; its slot is not reused until Release.
+//   - A slab stays alive as long as any of its nodes is reachable, so
+//     holding one *Node keeps its whole slab in memory.
+//   - After Release no *Node, Iterator or other view obtained from the
+//     tree may be used, since their memory is recycled.
+func NewTreeWithArena(slabSize int) *Tree {
+	if slabSize <= 0 {
+		slabSize = DefaultSlabSize
+	}
+	t := NewTree()
+	t.arena = &nodeArena{slabSize: slabSize}
+	return t
+}
+
+// alloc returns a zeroed node from the current slab, starting a new slab
+// when it is full.
+func (a *nodeArena) alloc() *Node {
+	if len(a.slabs) == 0 || a.next == len(a.slabs[len(a.slabs)-1]) {
+		slab, _ := slabs.Get().([]Node)
+		if len(slab) == 0 {
+			slab = make([]Node, a.slabSize)
+		}
+		a.slabs = append(a.slabs, slab)
+		a.next = 0
+	}
+	n := &a.slabs[len(a.slabs)-1][a.next]
+	a.next++
+	return n
+}
+
+// Release empties an arena tree and returns its slabs for reuse by arena
+// trees. The tree can be filled again afterwards, but everything obtained
+// from it before is invalid; see NewTreeWithArena. Release is a noop for
+// trees not created by NewTreeWithArena.
+func (t *Tree) Release() {
+	if t == nil || t.arena == nil || t.mutable("Release") != nil {
+		return
+	}
+	for _, slab := range t.arena.slabs {
+		// don't let pooled slabs keep keys, payloads or other nodes alive
+		for i := range slab {
+			slab[i] = Node{}
+		}
+		slabs.Put(slab)
+	}
+	t.arena.slabs, t.arena.next = nil, 0
+	t.Root = nil
+	t.mods++
+	t.forgetChanges()
+	t.negative = nil
+	t.size, t.sizeKnown = 0, true
+	t.history = nil
+}
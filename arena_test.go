@@ -0,0 +1,76 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestArenaTree(t *testing.T) {
+	tree := NewTreeWithArena(16)
+	for round := 0; round < 3; round++ {
+		for k := 0; k < 100; k++ {
+			tree.Put(k, k*round)
+		}
+		for k := 0; k < 100; k += 2 {
+			tree.Delete(k)
+		}
+		checkRedBlack(t, tree)
+		if tree.Size() != 50 {
+			t.Fatalf("round %d: Size() = %d, want 50", round, tree.Size())
+		}
+		for k := 1; k < 100; k += 2 {
+			if found, v := tree.Get(k); !found || v != k*round {
+				t.Fatalf("round %d: Get(%d) = %v, %v", round, k, found, v)
+			}
+		}
+		// 100 nodes from slabs of 16; deleted nodes are not reused
+		if got := len(tree.arena.slabs); got != 7 {
+			t.Errorf("round %d: %d slabs in use, want 7", round, got)
+		}
+
+		tree.Release()
+		if !tree.IsEmpty() || tree.Size() != 0 || len(tree.arena.slabs) != 0 {
+			t.Fatalf("round %d: Release left %d keys in %d slabs", round, tree.Size(), len(tree.arena.slabs))
+		}
+	}
+}
+
+func TestReleaseWithoutArena(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	tree.Release()
+	if tree.Size() != 3 {
+		t.Errorf("Release emptied a tree without arena: Size() = %d", tree.Size())
+	}
+}
+
+// benchmarkBuildQueryDrop builds a tree of 10k keys, looks each up once
+// and drops the tree, the workload arena trees are meant for. Besides the
+// allocations it reports the GC pause time per op.
+func benchmarkBuildQueryDrop(b *testing.B, newTree func() *Tree) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := newTree()
+		for k := 0; k < 10000; k++ {
+			tree.Put(k, nil)
+		}
+		for k := 0; k < 10000; k++ {
+			tree.Get(k)
+		}
+		tree.Release()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+}
+
+func BenchmarkBuildQueryDrop(b *testing.B) {
+	benchmarkBuildQueryDrop(b, NewTree)
+}
+
+func BenchmarkBuildQueryDropArena(b *testing.B) {
+	benchmarkBuildQueryDrop(b, func() *Tree { return NewTreeWithArena(0) })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// Sizing of the negative filter, see Options.NegativeFilter: 10 bits and 7
+// hash functions per key give about 1% false positives.
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	bloomMinKeys    = 1024
+)
+
+// bloomFilter is a Bloom filter over key hashes: it answers "maybe
+// present" or "definitely absent".
+type bloomFilter struct {
+	bits     []uint64
+	capacity int // keys it was sized for
+	added    int // keys added, including ones deleted since
+	removed  int // deletions since it was built
+}
+
+func newBloomFilter(capacity int) *bloomFilter {
+	if capacity < bloomMinKeys {
+		capacity = bloomMinKeys
+	}
+	return &bloomFilter{bits: make([]uint64, (capacity*bloomBitsPerKey+63)/64), capacity: capacity}
+}
+
+// positions calls fn with every bit index of hash h, derived by double
+// hashing from its two halves.
+func (f *bloomFilter) positions(h uint64, fn func(bit uint64)) {
+	n := uint64(len(f.bits)) * 64
+	h1, h2 := h, h>>32|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		fn((h1 + i*h2) % n)
+	}
+}
+
+func (f *bloomFilter) add(h uint64) {
+	f.positions(h, func(bit uint64) { f.bits[bit/64] |= 1 << (bit % 64) })
+	f.added++
+}
+
+func (f *bloomFilter) mayContain(h uint64) bool {
+	found := true
+	f.positions(h, func(bit uint64) {
+		found = found && f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+	return found
+}
+
+// stale reports whether the filter should be rebuilt: it outgrew its size,
+// or so many keys were deleted that its false positives pile up.
+func (f *bloomFilter) stale() bool {
+	return f.added > f.capacity || f.removed > f.capacity/2
+}
+
+// keyHash hashes a key for the negative filter. Keys the comparator deems
+// equal must hash equal, which the default, based on the %#v formatting
+// of the key with fast paths for ints and strings, only guarantees for
+// keys that are equal as Go values, plus time.Time instants.
+func (t *Tree) keyHash(key interface{}) uint64 {
+	if t.opts.KeyHash != nil {
+		return t.opts.KeyHash(key)
+	}
+	switch k := key.(type) {
+	case int:
+		return mix64(uint64(k))
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		return h.Sum64()
+	case time.Time:
+		return mix64(uint64(k.UnixNano()))
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%#v", key)
+	return h.Sum64()
+}
+
+// mix64 is the finalizer of SplitMix64, spreading the bits of x.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// filterAdd records `key` in the negative filter before it is stored,
+// building or rebuilding the filter first if needed.
+func (t *Tree) filterAdd(key interface{}) {
+	if !t.opts.NegativeFilter {
+		return
+	}
+	if t.negative == nil || t.negative.stale() {
+		t.rebuildFilter()
+	}
+	t.negative.add(t.keyHash(key))
+}
+
+// filterRemoved notes a deletion. The key stays in the filter, which only
+// costs false positives, until enough deletions call for a rebuild.
+func (t *Tree) filterRemoved() {
+	if t.negative != nil {
+		t.negative.removed++
+	}
+}
+
+// rebuildFilter sizes a fresh filter for twice the current keys and adds
+// all of them.
+func (t *Tree) rebuildFilter() {
+	t.negative = newBloomFilter(2 * int(t.Size()))
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			t.negative.add(t.keyHash(n.Key))
+		}
+	})
+	t.tracef(LevelDetail, "Rebuilt negative filter for %d keys\n", t.negative.added)
+}
+
+// definitelyAbsent reports whether the negative filter rules `key` out.
+// Without a filter, e.g. before the first write, it never does.
+func (t *Tree) definitelyAbsent(key interface{}) bool {
+	return t.negative != nil && !t.negative.mayContain(t.keyHash(key))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestNegativeFilterNoFalseNegatives(t *testing.T) {
+	tree := NewTreeWithOptions(IntComparator, Options{NegativeFilter: true})
+	rnd := rand.New(rand.NewSource(648))
+	present := map[int]bool{}
+	for i := 0; i < 200000; i++ {
+		k := rnd.Intn(20000)
+		if rnd.Intn(3) == 0 {
+			tree.Delete(k)
+			delete(present, k)
+		} else {
+			tree.Put(k, k)
+			present[k] = true
+		}
+		if i%1000 == 0 {
+			for k := range present {
+				if !tree.Has(k) {
+					t.Fatalf("after %d operations: Has(%d) = false for a present key", i+1, k)
+				}
+			}
+		}
+	}
+	for k := 0; k < 20000; k++ {
+		if found, _ := tree.Get(k); found != present[k] {
+			t.Fatalf("Get(%d) found %v, want %v", k, found, present[k])
+		}
+	}
+}
+
+func TestNegativeFilterSkipsDescents(t *testing.T) {
+	var count int64
+	tree := NewTreeWithOptions(CountingComparator(IntComparator, &count), Options{NegativeFilter: true})
+	for k := 0; k < 10000; k++ {
+		tree.Put(k*2, nil)
+	}
+	count = 0
+	misses := 0
+	for k := 1; k < 20000; k += 2 {
+		if tree.Has(k) {
+			t.Fatalf("Has(%d) for an absent key", k)
+		}
+		misses++
+	}
+	// at about 1% false positives, few misses should descend at all
+	if limit := int64(misses) / 10 * int64(tree.Height()); count > limit {
+		t.Errorf("%d misses made %d comparisons, want at most %d", misses, count, limit)
+	}
+}
+
+func TestNegativeFilterKeyHash(t *testing.T) {
+	foldCase := func(o1, o2 interface{}) int {
+		return StringComparator(strings.ToLower(o1.(string)), strings.ToLower(o2.(string)))
+	}
+	tree := NewTreeWithOptions(foldCase, Options{
+		NegativeFilter: true,
+		KeyHash: func(key interface{}) uint64 {
+			h := fnv.New64a()
+			h.Write([]byte(strings.ToLower(key.(string))))
+			return h.Sum64()
+		},
+	})
+	for _, k := range []string{"Apple", "Banana", "Cherry"} {
+		tree.Put(k, nil)
+	}
+	for _, k := range []string{"apple", "BANANA", "cHeRrY"} {
+		if !tree.Has(k) {
+			t.Errorf("Has(%q) = false with a case-folding KeyHash", k)
+		}
+	}
+}
+
+func benchmarkMisses(b *testing.B, filter bool) {
+	tree := NewTreeWithOptions(IntComparator, Options{NegativeFilter: filter})
+	for _, k := range rand.New(rand.NewSource(1)).Perm(100000) {
+		tree.Put(k*2, nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Get(i%100000*2 + 1)
+	}
+}
+
+func BenchmarkGetMiss(b *testing.B) {
+	benchmarkMisses(b, false)
+}
+
+func BenchmarkGetMissNegativeFilter(b *testing.B) {
+	benchmarkMisses(b, true)
+}
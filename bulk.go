@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Entry is a single key/payload mapping of a Tree.
+type Entry struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+var ErrorEntriesUnsorted = errors.New("Entries are not in strictly ascending key order")
+
+// FromSorted builds a balanced Tree from entries that are already in strictly
+// ascending order according to `c`. The resulting tree has minimal height and
+// satisfies the red-black properties without running any fixup.
+// A nil `c` means IntComparator, as for NewTreeWith; keys it can't compare
+// fail with ErrorComparatorFailed.
+func FromSorted(entries []Entry, c Comparator) (*Tree, error) {
+	t := NewTreeWith(c)
+	for i := range entries {
+		if err := mustBeValidKey(entries[i].Key); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if i == 0 {
+			continue
+		}
+		if order, err := t.tryCompare(entries[i-1].Key, entries[i].Key); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		} else if order >= 0 {
+			return nil, fmt.Errorf("entry %d (%#v): %w", i, entries[i].Key, ErrorEntriesUnsorted)
+		}
+	}
+
+	t.Root = buildBalanced(entries, nil, 0, redDepth(len(entries)))
+	t.size = uint64(len(entries))
+	return t, nil
+}
+
+// redDepth returns the depth of the deepest level of a balanced tree
+// holding n nodes. Coloring exactly that level red (unless it is the root)
+// keeps every root-to-leaf path at the same black height.
+func redDepth(n int) int {
+	depth := -1
+	for n > 0 {
+		depth++
+		n >>= 1
+	}
+	return depth
+}
+
+func buildBalanced(entries []Entry, parent *Node, depth, red int) *Node {
+	if len(entries) == 0 {
+		return nil
+	}
+	mid := len(entries) / 2
+	n := &Node{Key: entries[mid].Key, payload: entries[mid].Value, color: BLACK, parent: parent}
+	if depth == red && depth > 0 {
+		n.color = RED
+	}
+	n.Left = buildBalanced(entries[:mid], n, depth+1, red)
+	n.Right = buildBalanced(entries[mid+1:], n, depth+1, red)
+	return n
+}
+
+// Items returns the entries of the tree in ascending key order. The slice
+// is a copy: changing it doesn't affect the tree.
+func (t *Tree) Items() []Entry {
+	if t.IsEmpty() {
+		return []Entry{}
+	}
+	entries := make([]Entry, 0, t.Size())
+	t.rangeWalk(nil, nil, func(n *Node) bool {
+		entries = append(entries, Entry{Key: n.Key, Value: n.payload})
+		return true
+	})
+	return entries
+}
+
+// FilterInto returns a new, balanced tree holding the entries of `t` for
+// which pred returns true, built by FromSorted from fresh nodes, so the two
+// trees share nothing but payloads. It keeps the comparator, codec, key
+// normalizer, options and weights of `t`, which is left untouched. See
+// MapValues for rewriting payloads in place.
+func (t *Tree) FilterInto(pred func(key, payload interface{}) bool) *Tree {
+	kept := []Entry{}
+	if !t.IsEmpty() {
+		t.rangeWalk(nil, nil, func(n *Node) bool {
+			if pred(n.Key, n.payload) {
+				kept = append(kept, Entry{Key: n.Key, Value: n.payload})
+			}
+			return true
+		})
+	}
+	f, err := FromSorted(kept, t.Comparator())
+	if err != nil {
+		// only a corrupted source tree yields keys out of order
+		t.tracef(LevelError, "Filter: %s\n", err.Error())
+		f = NewTreeWith(t.Comparator())
+	}
+	if t != nil {
+		f.cmpName, f.codec, f.KeyNormalizer, f.opts = t.cmpName, t.codec, t.KeyNormalizer, t.opts
+		f.EnableWeights(t.weightOf)
+	}
+	return f
+}
+
+// BulkPut saves a batch of entries like a sequence of Put calls, where a
+// later entry of the batch wins over an earlier one with the same key, but
+// instead of rebalancing after every key it sorts the batch, merges it with
+// the contents of the tree and rebuilds a balanced tree in O(n + m log m),
+// installing the new root only at the end. Each entry is journaled and
+// recorded like a Put; if a journal write fails, the entries from there on
+// are rolled back. An invalid key fails the whole batch before anything
+// changes. On a size-capped tree, where Put may evict, it falls back to
+// PutAll.
+// Nodes previously obtained from the tree are detached by the rebuild.
+func (t *Tree) BulkPut(entries []Entry) error {
+	if err := t.mutable("BulkPut"); err != nil {
+		return err
+	}
+	if t.opts.MaxSize > 0 {
+		return t.PutAll(entries)
+	}
+	defer t.beginOp("bulkput")()
+
+	batch := make([]Entry, len(entries))
+	for i, e := range entries {
+		batch[i] = Entry{Key: t.normalize(e.Key), Value: e.Value}
+		if err := mustBeValidKey(batch[i].Key); err != nil {
+			t.tracef(LevelError, "BulkPut was prematurely aborted: %s\n", err.Error())
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	unique := sortUnique(batch, t.compare)
+	inverses := make([]*undoRecord, len(unique))
+	for i, e := range unique {
+		inverses[i] = t.inverseOf(e.Key)
+	}
+
+	t.version++
+	merged := make([]Entry, 0, len(unique))
+	var expires []time.Time // parallel to merged; nil while nothing expires
+	versions := make([]uint64, 0, len(unique))
+	keep := func(e Entry, at time.Time, version uint64) {
+		if !at.IsZero() && expires == nil {
+			expires = make([]time.Time, len(merged), cap(merged))
+		}
+		merged = append(merged, e)
+		if expires != nil {
+			expires = append(expires, at)
+		}
+		versions = append(versions, version)
+	}
+	next := 0
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step != stepIn {
+			return
+		}
+		for next < len(unique) && t.compare(unique[next].Key, n.Key) < 0 {
+			keep(unique[next], time.Time{}, t.version)
+			next++
+		}
+		if next < len(unique) && t.compare(unique[next].Key, n.Key) == 0 {
+			// overwritten like by Put, which also clears the expiry
+			keep(unique[next], time.Time{}, t.version)
+			next++
+			return
+		}
+		keep(Entry{Key: n.Key, Value: n.payload}, n.expires, n.version)
+	})
+	for ; next < len(unique); next++ {
+		keep(unique[next], time.Time{}, t.version)
+	}
+
+	root := buildBalanced(merged, nil, 0, redDepth(len(merged)))
+	i := 0
+	traverse(root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			if expires != nil {
+				n.expires = expires[i]
+			}
+			n.version = versions[i]
+			i++
+		}
+	})
+	for _, e := range unique {
+		t.unbury(e.Key)
+	}
+	t.tracef(LevelOp, "BulkPut: merged %d entries into %d nodes\n", len(unique), len(merged))
+	t.Root, t.shared = root, false
+	t.mods++
+	if t.opts.NegativeFilter {
+		t.rebuildFilter()
+	}
+	t.size, t.sizeKnown = uint64(len(merged)), true
+	t.reweighAll()
+
+	for i, e := range unique {
+		if err := t.commit(journalPut, e.Key, e.Value, inverses[i]); err != nil {
+			// the entries after i were not journaled either
+			for j := len(unique) - 1; j > i; j-- {
+				t.rollback(inverses[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact rebuilds the tree into the minimal height of ceil(log2(n+1))
+// levels in O(n), keeping its entries, comparator and options. Use Height
+// to decide whether it is worth it, e.g. after heavily skewed churn.
+// Unlike BulkPut it relinks the existing nodes, so nodes previously
+// obtained from the tree stay valid (unless it shares them after
+// PutPersistent, in which case it copies them first).
+func (t *Tree) Compact() {
+	if t.IsEmpty() || t.mutable("Compact") != nil {
+		return
+	}
+	t.own()
+	nodes := []*Node{}
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			nodes = append(nodes, n)
+		}
+	})
+	before := t.Height()
+	t.Root = linkBalanced(nodes, nil, 0, redDepth(len(nodes)))
+	t.mods++
+	t.size, t.sizeKnown = uint64(len(nodes)), true
+	t.reweighAll()
+	t.tracef(LevelOp, "Compact: height %d -> %d\n", before, t.Height())
+}
+
+// linkBalanced is buildBalanced for existing nodes, which are relinked and
+// recolored in place.
+func linkBalanced(nodes []*Node, parent *Node, depth, red int) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	mid := len(nodes) / 2
+	n := nodes[mid]
+	n.parent, n.color = parent, BLACK
+	if depth == red && depth > 0 {
+		n.color = RED
+	}
+	n.Left = linkBalanced(nodes[:mid], n, depth+1, red)
+	n.Right = linkBalanced(nodes[mid+1:], n, depth+1, red)
+	return n
+}
+
+// Builder accumulates entries for a large initial load and builds a
+// balanced tree from them in one go, which is much faster than a loop of
+// Put:
+//
+//	t, err := NewBuilder(StringComparator).Add("b", 2).Add("a", 1).Build()
+//
+// Keys may be added in any order; of equal keys the last one added wins,
+// as with Put. The first invalid key is remembered and returned by Build.
+type Builder struct {
+	cmp     Comparator
+	entries []Entry
+	err     error
+}
+
+// NewBuilder returns an empty Builder for a tree ordered by `c`; a nil
+// comparator means IntComparator.
+func NewBuilder(c Comparator) *Builder {
+	if c == nil {
+		c = IntComparator
+	}
+	return &Builder{cmp: c}
+}
+
+// Add records the mapping (key, value) and returns the builder. Once a key
+// was rejected, further calls are ignored.
+func (b *Builder) Add(key, value interface{}) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if err := mustBeValidKey(key); err != nil {
+		b.err = fmt.Errorf("entry %d: %w", len(b.entries), err)
+		return b
+	}
+	b.entries = append(b.entries, Entry{Key: key, Value: value})
+	return b
+}
+
+// Build sorts and deduplicates the entries added so far and returns the
+// balanced tree built from them by FromSorted. The builder is reset, so it
+// can be reused for another tree.
+func (b *Builder) Build() (*Tree, error) {
+	entries, err := b.entries, b.err
+	b.entries, b.err = nil, nil
+	if err != nil {
+		return nil, err
+	}
+	return FromSorted(sortUnique(entries, b.cmp), b.cmp)
+}
+
+// sortUnique returns the entries sorted by key, keeping only the last one
+// of equal keys. `entries` is left as it is.
+func sortUnique(entries []Entry, cmp Comparator) []Entry {
+	// an unstable sort with ties broken by position beats a stable one
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int {
+		if c := cmp(entries[i].Key, entries[j].Key); c != 0 {
+			return c
+		}
+		return i - j
+	})
+	sorted := make([]Entry, 0, len(entries))
+	for n, i := range order {
+		if n+1 < len(order) && cmp(entries[i].Key, entries[order[n+1]].Key) == 0 {
+			continue
+		}
+		sorted = append(sorted, entries[i])
+	}
+	return sorted
+}
+
+// ToSortedSlice returns the entries in ascending key order, like Items. It
+// is the interop path to code expecting sorted slices; FromEntries goes the
+// other way.
+func (t *Tree) ToSortedSlice() []Entry {
+	return t.Items()
+}
+
+// FromEntries builds a balanced Tree from entries in any order. Of equal
+// keys the last one wins, as with Put. `entries` is not modified.
+func FromEntries(entries []Entry, c Comparator) (*Tree, error) {
+	b := NewBuilder(c)
+	for _, e := range entries {
+		b.Add(e.Key, e.Value)
+	}
+	return b.Build()
+}
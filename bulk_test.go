@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFromSorted(t *testing.T) {
+	for n := 0; n <= 64; n++ {
+		entries := make([]Entry, n)
+		for i := range entries {
+			entries[i] = Entry{Key: i, Value: i * i}
+		}
+		tree, err := FromSorted(entries, IntComparator)
+		if err != nil {
+			t.Fatalf("n=%d: %s", n, err)
+		}
+		checkRedBlack(t, tree)
+		if got := tree.Size(); got != uint64(n) {
+			t.Fatalf("n=%d: Size() = %d", n, got)
+		}
+		for i := 0; i < n; i++ {
+			if found, v := tree.Get(i); !found || v != i*i {
+				t.Fatalf("n=%d: Get(%d) = %v, %v", n, i, found, v)
+			}
+		}
+	}
+}
+
+func TestFromSortedRejectsUnsorted(t *testing.T) {
+	entries := []Entry{{Key: 1}, {Key: 3}, {Key: 3}}
+	if _, err := FromSorted(entries, IntComparator); !errors.Is(err, ErrorEntriesUnsorted) {
+		t.Fatalf("got %v, want ErrorEntriesUnsorted", err)
+	}
+	mixed := []Entry{{Key: 1}, {Key: "two"}}
+	if _, err := FromSorted(mixed, IntComparator); !errors.Is(err, ErrorComparatorFailed) {
+		t.Fatalf("mixed key types: got %v, want ErrorComparatorFailed", err)
+	}
+}
+
+func TestFromSortedNilComparator(t *testing.T) {
+	tree, err := FromSorted([]Entry{{Key: 1}, {Key: 2}, {Key: 3}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	if err := tree.Put(0, nil); err != nil || !reflect.DeepEqual(keysOf(tree), []interface{}{0, 1, 2, 3}) {
+		t.Errorf("Put(0) = %v, keys %v", err, keysOf(tree))
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 50; k++ {
+		tree.Put(k, k)
+	}
+	shape := shapeOf(tree)
+	tree.MapValues(func(key, payload interface{}) interface{} {
+		return payload.(int) * 2
+	})
+	for k := 1; k <= 50; k++ {
+		if _, v := tree.Get(k); v != k*2 {
+			t.Fatalf("Get(%d) = %v, want %d", k, v, k*2)
+		}
+	}
+	if shapeOf(tree) != shape {
+		t.Error("MapValues changed the shape of the tree")
+	}
+	checkRedBlack(t, tree)
+}
+
+func TestFilterInto(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 100; k++ {
+		tree.Put(k, k*10)
+	}
+	odd := tree.FilterInto(func(key, payload interface{}) bool {
+		return key.(int)%2 == 1
+	})
+	checkRedBlack(t, odd)
+	keys := keysOf(odd)
+	if len(keys) != 50 {
+		t.Fatalf("FilterInto kept %d keys, want 50", len(keys))
+	}
+	for i, k := range keys {
+		if k != 2*i+1 {
+			t.Fatalf("key %d = %v, want %d", i, k, 2*i+1)
+		}
+		if _, v := odd.Get(k); v != k.(int)*10 {
+			t.Fatalf("Get(%v) = %v, want %d", k, v, k.(int)*10)
+		}
+	}
+	if tree.Size() != 100 {
+		t.Errorf("FilterInto changed the source tree to %d keys", tree.Size())
+	}
+	checkRedBlack(t, tree)
+	source := map[*Node]bool{}
+	traverse(tree.Root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			source[n] = true
+		}
+	})
+	traverse(odd.Root, func(step walkStep, n *Node) {
+		if step == stepIn && source[n] {
+			t.Fatalf("FilterInto shares the node of key %v with the source", n.Key)
+		}
+	})
+}
+
+func TestBulkPutMatchesPut(t *testing.T) {
+	rng := rand.New(rand.NewSource(636))
+	for round := 0; round < 50; round++ {
+		bulk, loop := NewTree(), NewTree()
+		for i := 0; i < rng.Intn(200); i++ {
+			k := rng.Intn(300)
+			bulk.Put(k, "old")
+			loop.Put(k, "old")
+		}
+		// a batch overlapping the tree and repeating keys of its own
+		batch := make([]Entry, rng.Intn(300))
+		for i := range batch {
+			batch[i] = Entry{Key: rng.Intn(300), Value: i}
+		}
+		if err := bulk.BulkPut(batch); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range batch {
+			loop.Put(e.Key, e.Value)
+		}
+		checkRedBlack(t, bulk)
+		if got, want := bulk.Items(), loop.Items(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("round %d: BulkPut gave %v, Put gave %v", round, got, want)
+		}
+		if bulk.Size() != loop.Size() {
+			t.Fatalf("round %d: Size() = %d, want %d", round, bulk.Size(), loop.Size())
+		}
+	}
+}
+
+func TestBulkPutInvalidKeyChangesNothing(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	before := tree.Items()
+	if err := tree.BulkPut([]Entry{{Key: 4}, {Key: nil}, {Key: 5}}); !errors.Is(err, ErrorKeyIsNil) {
+		t.Fatalf("BulkPut = %v, want ErrorKeyIsNil", err)
+	}
+	if got := tree.Items(); !reflect.DeepEqual(got, before) {
+		t.Errorf("failed BulkPut changed the tree to %v", got)
+	}
+}
+
+func TestBulkPutRollsBackUnjournaled(t *testing.T) {
+	tree := newIntTree(t, 1, 3)
+	w := &failingWriter{budget: 2}
+	tree.Journal = w
+	// 0 and 1 are journaled, 2 and 3 are not
+	err := tree.BulkPut([]Entry{{Key: 3, Value: "c"}, {Key: 0, Value: "z"}, {Key: 2, Value: "b"}, {Key: 1, Value: "a"}})
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("BulkPut = %v, want errWriteFailed", err)
+	}
+	checkRedBlack(t, tree)
+	want := []Entry{{Key: 0, Value: "z"}, {Key: 1, Value: "a"}, {Key: 3, Value: 3}}
+	if got := tree.Items(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after the failed BulkPut: %v, want %v", got, want)
+	}
+}
+
+func benchmarkBulkPut(b *testing.B, n int, bulk bool) {
+	keys := rand.New(rand.NewSource(1)).Perm(n)
+	entries := make([]Entry, n)
+	for i, k := range keys {
+		entries[i] = Entry{Key: k}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := NewTree()
+		if bulk {
+			tree.BulkPut(entries)
+			continue
+		}
+		for _, e := range entries {
+			tree.Put(e.Key, e.Value)
+		}
+	}
+}
+
+func BenchmarkBulkPut10k(b *testing.B)  { benchmarkBulkPut(b, 10000, true) }
+func BenchmarkBulkPut100k(b *testing.B) { benchmarkBulkPut(b, 100000, true) }
+func BenchmarkBulkPut1M(b *testing.B)   { benchmarkBulkPut(b, 1000000, true) }
+func BenchmarkPutLoop10k(b *testing.B)  { benchmarkBulkPut(b, 10000, false) }
+func BenchmarkPutLoop100k(b *testing.B) { benchmarkBulkPut(b, 100000, false) }
+func BenchmarkPutLoop1M(b *testing.B)   { benchmarkBulkPut(b, 1000000, false) }
+
+func TestCompact(t *testing.T) {
+	empty := NewTree()
+	empty.Compact()
+	if !empty.IsEmpty() || empty.Height() != 0 {
+		t.Errorf("compacted empty tree: Height() = %d", empty.Height())
+	}
+	var zero Tree
+	zero.Compact()
+
+	for _, n := range []int{1, 2, 3, 7, 8, 100, 1000} {
+		// ascending inserts followed by deletes of the lower half skew it
+		tree := NewTree()
+		for k := 0; k < 2*n; k++ {
+			tree.Put(k, k*10)
+		}
+		for k := 0; k < n; k++ {
+			tree.Delete(k)
+		}
+		before := tree.Items()
+		_, kept := tree.getNode(n)
+
+		tree.Compact()
+		checkRedBlack(t, tree)
+		if got, want := tree.Height(), bits.Len(uint(n)); got != want {
+			t.Errorf("n=%d: Height() = %d after Compact, want %d", n, got, want)
+		}
+		if got := tree.Items(); !reflect.DeepEqual(got, before) {
+			t.Fatalf("n=%d: Compact changed the entries", n)
+		}
+		if _, node := tree.getNode(n); node != kept {
+			t.Errorf("n=%d: Compact replaced the node of %d", n, n)
+		}
+		if tree.Size() != uint64(n) {
+			t.Errorf("n=%d: Size() = %d", n, tree.Size())
+		}
+	}
+}
+
+func TestItems(t *testing.T) {
+	if items := NewTree().Items(); items == nil || len(items) != 0 {
+		t.Errorf("Items of an empty tree = %#v, want an empty slice", items)
+	}
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTreeWithOptions(IntComparator, Options{Now: clock.Now})
+	for _, k := range rand.New(rand.NewSource(1)).Perm(100) {
+		tree.Put(k, k)
+	}
+	tree.PutTTL(100, 100, time.Second)
+	clock.now = clock.now.Add(time.Minute)
+
+	items := tree.Items()
+	if len(items) != 100 {
+		t.Fatalf("Items returned %d entries, want 100 without the expired one", len(items))
+	}
+	for i, e := range items {
+		if e.Key != i || e.Value != i {
+			t.Fatalf("Items()[%d] = %v", i, e)
+		}
+	}
+	items[0].Value = "changed"
+	items = append(items[:1], items[2:]...)
+	if _, v := tree.Get(0); v != 0 {
+		t.Errorf("changing the slice changed the tree: Get(0) = %v", v)
+	}
+	if !tree.Has(1) || len(tree.Items()) != 100 {
+		t.Error("removing from the slice changed the tree")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder(nil)
+	keys := rand.New(rand.NewSource(644)).Perm(1000)
+	for _, k := range keys {
+		b.Add(k, "first")
+	}
+	for _, k := range keys {
+		b.Add(k, k) // duplicates: the last one added wins
+	}
+	tree, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	if tree.Size() != 1000 {
+		t.Fatalf("Size() = %d, want 1000", tree.Size())
+	}
+	for i, e := range tree.Items() {
+		if e.Key != i || e.Value != i {
+			t.Fatalf("entry %d = %v", i, e)
+		}
+	}
+
+	// the builder is reset by Build
+	if tree, err := b.Add(1, nil).Build(); err != nil || tree.Size() != 1 {
+		t.Fatalf("reused builder built %v, %v", tree.Items(), err)
+	}
+
+	_, err = b.Add(1, nil).Add(nil, nil).Add(2, nil).Build()
+	if !errors.Is(err, ErrorKeyIsNil) {
+		t.Errorf("Build after adding a nil key = %v, want ErrorKeyIsNil", err)
+	}
+	if tree, err := b.Build(); err != nil || !tree.IsEmpty() {
+		t.Errorf("Build after a failed Build = %v, %v, want an empty tree", tree, err)
+	}
+}
+
+func BenchmarkBuilder(b *testing.B) {
+	keys := rand.New(rand.NewSource(1)).Perm(100000)
+	b.Run("Builder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			builder := NewBuilder(IntComparator)
+			for _, k := range keys {
+				builder.Add(k, nil)
+			}
+			if _, err := builder.Build(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree := NewTree()
+			for _, k := range keys {
+				tree.Put(k, nil)
+			}
+		}
+	})
+}
+
+func TestSortedSliceRoundTrip(t *testing.T) {
+	entries := []Entry{{"pear", 1}, {"apple", 2}, {"fig", 3}, {"apple", 4}, {"kiwi", 5}}
+	given := append([]Entry(nil), entries...)
+	tree, err := FromEntries(entries, StringComparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, given) {
+		t.Errorf("FromEntries reordered its argument to %v", entries)
+	}
+	checkRedBlack(t, tree)
+
+	// what sort.Slice makes of the input, last duplicate kept
+	want := []Entry{{"apple", 4}, {"fig", 3}, {"kiwi", 5}, {"pear", 1}}
+	got := tree.ToSortedSlice()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ToSortedSlice() = %v, want %v", got, want)
+	}
+	if !sort.SliceIsSorted(got, func(i, j int) bool { return got[i].Key.(string) < got[j].Key.(string) }) {
+		t.Error("ToSortedSlice is not sorted as sort.Slice would")
+	}
+	again, err := FromEntries(got, StringComparator)
+	if err != nil || !reflect.DeepEqual(again.ToSortedSlice(), want) {
+		t.Errorf("second round trip = %v, %v", again.ToSortedSlice(), err)
+	}
+
+	if _, err := FromEntries([]Entry{{1, nil}, {nil, nil}}, nil); !errors.Is(err, ErrorKeyIsNil) {
+		t.Errorf("FromEntries with a nil key = %v, want ErrorKeyIsNil", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// CompareAndSwap replaces the payload of `key` with `new` only if the
+// current payload equals `old` according to `eq`, and reports whether it
+// did. A nil `eq` means Options.PayloadEquals, or else reflect.DeepEqual.
+// A missing (or expired) key is never swapped. The replacement is a Put,
+// so it is journaled, recorded and handed to Options.Writer as usual.
+// Callers racing on a key through a ConcurrentTree retry on false after
+// reading the payload again, so no update is lost.
+func (t *Tree) CompareAndSwap(key, old, new interface{}, eq func(a, b interface{}) bool) (bool, error) {
+	key = t.normalize(key)
+	if ok, err := t.holds("CompareAndSwap", key, old, eq); !ok || err != nil {
+		return false, err
+	}
+	if err := t.Put(key, new); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete deletes `key` only if its payload equals `old`
+// according to `eq`, and reports whether it did; see CompareAndSwap.
+func (t *Tree) CompareAndDelete(key, old interface{}, eq func(a, b interface{}) bool) (bool, error) {
+	key = t.normalize(key)
+	if ok, err := t.holds("CompareAndDelete", key, old, eq); !ok || err != nil {
+		return false, err
+	}
+	if err := t.Delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// holds reports whether the normalized `key` is present with a payload
+// equal to `old`, failing if the tree can't be changed or the key is
+// invalid.
+func (t *Tree) holds(op string, key, old interface{}, eq func(a, b interface{}) bool) (bool, error) {
+	if err := t.mutable(op); err != nil {
+		return false, err
+	}
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "%s was prematurely aborted: %s\n", op, err.Error())
+		return false, err
+	}
+	found, node := t.getNode(key)
+	if !found || t.expired(node) {
+		return false, nil
+	}
+	if eq == nil {
+		eq = t.payloadEquals()
+	}
+	return eq(node.payload, old), nil
+}
+
+// CompareAndSwap is Tree.CompareAndSwap under the write lock. With
+// Options.Writer set, it also waits for writes of the same key in flight.
+func (ct *ConcurrentTree) CompareAndSwap(key, old, new interface{}, eq func(a, b interface{}) bool) (bool, error) {
+	defer ct.lockForWrite(key)()
+	return ct.tree.CompareAndSwap(key, old, new, eq)
+}
+
+// CompareAndDelete is Tree.CompareAndDelete under the write lock, like
+// CompareAndSwap.
+func (ct *ConcurrentTree) CompareAndDelete(key, old interface{}, eq func(a, b interface{}) bool) (bool, error) {
+	defer ct.lockForWrite(key)()
+	return ct.tree.CompareAndDelete(key, old, eq)
+}
+
+// lockForWrite takes the write lock for a mutation that calls the writer
+// while holding it. The write slot of `key` is taken first, as by Put, so
+// that the writer still sees one write per key at a time.
+func (ct *ConcurrentTree) lockForWrite(key interface{}) func() {
+	ct.lock.RLock()
+	writes := ct.tree.opts.Writer != nil
+	key = ct.tree.normalize(key)
+	ct.lock.RUnlock()
+	unlock := func() {}
+	if writes && mustBeValidKey(key) == nil {
+		unlock = ct.lockKey(key)
+	}
+	ct.lock.Lock()
+	return func() {
+		ct.lock.Unlock()
+		unlock()
+	}
+}
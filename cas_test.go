@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	tree := NewTree()
+	tree.Put(1, []int{1})
+	if ok, err := tree.CompareAndSwap(1, []int{2}, []int{3}, nil); ok || err != nil {
+		t.Errorf("CompareAndSwap with a stale value = %v, %v", ok, err)
+	}
+	// slices compare by reflect.DeepEqual by default
+	if ok, err := tree.CompareAndSwap(1, []int{1}, []int{3}, nil); !ok || err != nil {
+		t.Fatalf("CompareAndSwap with the current value = %v, %v", ok, err)
+	}
+	if _, v := tree.Get(1); len(v.([]int)) != 1 || v.([]int)[0] != 3 {
+		t.Errorf("payload after the swap = %v", v)
+	}
+	if ok, _ := tree.CompareAndSwap(2, nil, 1, nil); ok || tree.Has(2) {
+		t.Error("CompareAndSwap of a missing key swapped")
+	}
+
+	always := func(a, b interface{}) bool { return true }
+	if ok, _ := tree.CompareAndSwap(1, "anything", "new", always); !ok {
+		t.Error("CompareAndSwap ignored its eq func")
+	}
+	if ok, _ := tree.CompareAndDelete(1, "other", nil); ok || !tree.Has(1) {
+		t.Error("CompareAndDelete with a stale value deleted")
+	}
+	if ok, _ := tree.CompareAndDelete(1, "new", nil); !ok || tree.Has(1) {
+		t.Error("CompareAndDelete with the current value kept the key")
+	}
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree = NewTreeWithOptions(IntComparator, Options{Now: clock.Now})
+	tree.PutTTL(1, "v", time.Second)
+	clock.now = clock.now.Add(time.Minute)
+	if ok, _ := tree.CompareAndSwap(1, "v", "w", nil); ok {
+		t.Error("CompareAndSwap matched an expired entry")
+	}
+
+	tree.Freeze()
+	if _, err := tree.CompareAndSwap(1, "v", "w", nil); !errors.Is(err, ErrorTreeIsFrozen) {
+		t.Errorf("CompareAndSwap on a frozen tree = %v, want ErrorTreeIsFrozen", err)
+	}
+}
+
+func TestCompareAndSwapNoLostUpdates(t *testing.T) {
+	store := newMapStore()
+	tree := NewConcurrentTreeWithOptions(IntComparator, Options{Writer: store.write})
+	tree.Put(0, 0)
+	const goroutines, increments = 8, 200
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					_, v := tree.Get(0)
+					ok, err := tree.CompareAndSwap(0, v, v.(int)+1, nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if _, v := tree.Get(0); v != goroutines*increments {
+		t.Errorf("counter = %v after %d increments", v, goroutines*increments)
+	}
+	if store.data[0] != goroutines*increments {
+		t.Errorf("the store holds %v", store.data[0])
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorResyncRequired = errors.New("Changes since that version are no longer known")
+	ErrorUnknownOp      = errors.New("Unknown change operation")
+)
+
+// Change is an entry saved or deleted after a given version of a tree;
+// see ChangesSince.
+type Change struct {
+	Op      Op
+	Key     interface{}
+	Value   interface{} // nil for OpDelete
+	Version uint64      // version of the tree the change produced
+}
+
+// grave records when a key was deleted, for ChangesSince.
+type grave struct {
+	key     interface{}
+	version uint64
+}
+
+// Version returns the version of the tree, which grows by one with every
+// entry saved or deleted (or by one for a whole BulkPut). A new tree is
+// at version 0.
+func (t *Tree) Version() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.version
+}
+
+// ChangesSince returns, in ascending key order, the current entries saved
+// after version `v` and the keys deleted after it, together with the
+// current version to ask from next time. A follower that holds a copy of
+// the tree at version `v` (such as a Clone) converges to it by applying
+// the changes with ApplyChanges. Only the latest change of every key is
+// reported, so the changes can be applied in any order. Finding them
+// takes a walk of the whole tree.
+//
+// Deletes are remembered for Options.TombstoneHorizon versions. Asking
+// for changes since an older version, since a version before a wholesale
+// replacement of the entries (UnmarshalJSON, Release) or since a version
+// the tree never had fails with ErrorResyncRequired: the follower has to
+// start over from a fresh copy.
+func (t *Tree) ChangesSince(v uint64) ([]Change, uint64, error) {
+	changes := []Change{}
+	if t == nil {
+		return changes, 0, nil
+	}
+	if v < t.floor || v > t.version {
+		t.tracef(LevelError, "ChangesSince(%d): changes are only known from version %d to %d\n", v, t.floor, t.version)
+		return nil, t.version, fmt.Errorf("%w: asked since %d, known since %d", ErrorResyncRequired, v, t.floor)
+	}
+	var deleted []grave
+	if t.graves != nil {
+		traverse(t.graves.Root, func(step walkStep, n *Node) {
+			if step == stepIn && n.payload.(uint64) > v {
+				deleted = append(deleted, grave{n.Key, n.payload.(uint64)})
+			}
+		})
+	}
+	next := 0
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step != stepIn || n.version <= v || t.expired(n) {
+			return
+		}
+		for ; next < len(deleted) && t.compare(deleted[next].key, n.Key) < 0; next++ {
+			changes = append(changes, Change{Op: OpDelete, Key: deleted[next].key, Version: deleted[next].version})
+		}
+		changes = append(changes, Change{Op: OpPut, Key: n.Key, Value: n.payload, Version: n.version})
+	})
+	for ; next < len(deleted); next++ {
+		changes = append(changes, Change{Op: OpDelete, Key: deleted[next].key, Version: deleted[next].version})
+	}
+	return changes, t.version, nil
+}
+
+// ApplyChanges applies changes obtained from ChangesSince of another tree
+// with Put and Delete. It stops at the first change that fails.
+func (t *Tree) ApplyChanges(changes []Change) error {
+	for i, c := range changes {
+		var err error
+		switch c.Op {
+		case OpPut:
+			err = t.Put(c.Key, c.Value)
+		case OpDelete:
+			err = t.Delete(c.Key)
+		default:
+			err = fmt.Errorf("%w: %v", ErrorUnknownOp, c.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("change %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// stamp marks `n` as saved by the next version.
+func (t *Tree) stamp(n *Node) {
+	t.version++
+	n.version = t.version
+	t.unbury(n.Key)
+}
+
+// unbury forgets that `key` was deleted, now that it is back.
+func (t *Tree) unbury(key interface{}) {
+	if t.graves != nil && t.graves.Root != nil {
+		t.graves.remove(key)
+	}
+}
+
+// bury records that the next version deletes `key`. Graves older than
+// Options.TombstoneHorizon versions are dropped, which moves the floor of
+// ChangesSince past them.
+func (t *Tree) bury(key interface{}) {
+	t.version++
+	horizon := t.opts.TombstoneHorizon
+	if horizon == 0 {
+		t.floor = t.version
+		return
+	}
+	if t.graves == nil {
+		t.graves = NewTreeWith(t.Comparator())
+	}
+	t.graves.put(key, t.version)
+	t.buried = append(t.buried, grave{key, t.version})
+	for len(t.buried) > 0 && t.buried[0].version+horizon <= t.version {
+		old := t.buried[0]
+		t.buried = t.buried[1:]
+		// the key may have been deleted again or saved since
+		if found, n := t.graves.getNode(old.key); found && n.payload.(uint64) == old.version {
+			t.graves.remove(old.key)
+			t.floor = old.version
+		}
+	}
+}
+
+// forgetChanges is called when all entries are replaced at once:
+// ChangesSince can only answer from the new version on.
+func (t *Tree) forgetChanges() {
+	t.version++
+	t.floor = t.version
+	t.graves, t.buried = nil, nil
+}
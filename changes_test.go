@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestChangesSinceConverges(t *testing.T) {
+	leader := NewTreeWithOptions(IntComparator, Options{TombstoneHorizon: 1 << 20})
+	rnd := rand.New(rand.NewSource(651))
+	mutate := func(n int) {
+		for i := 0; i < n; i++ {
+			k := rnd.Intn(300)
+			switch rnd.Intn(4) {
+			case 0:
+				leader.Delete(k)
+			case 1:
+				leader.SetValue(k, -i)
+			default:
+				leader.Put(k, i)
+			}
+		}
+	}
+	mutate(1000)
+	follower := leader.Clone()
+	since := leader.Version()
+	for round := 0; round < 20; round++ {
+		mutate(rnd.Intn(200))
+		changes, version, err := leader.ChangesSince(since)
+		if err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+		seen := map[interface{}]bool{}
+		for _, c := range changes {
+			if seen[c.Key] || c.Version <= since || c.Version > version {
+				t.Fatalf("round %d: change %v repeats its key or is out of (%d, %d]", round, c, since, version)
+			}
+			seen[c.Key] = true
+		}
+		if err := follower.ApplyChanges(changes); err != nil {
+			t.Fatal(err)
+		}
+		if !follower.Equal(leader) {
+			t.Fatalf("round %d: follower differs by %v", round, follower.Diff(leader))
+		}
+		since = version
+	}
+	if changes, _, _ := leader.ChangesSince(leader.Version()); len(changes) != 0 {
+		t.Errorf("changes since the current version: %v", changes)
+	}
+}
+
+func TestChangesSinceTombstoneHorizon(t *testing.T) {
+	tree := NewTreeWithOptions(IntComparator, Options{TombstoneHorizon: 5})
+	for k := 0; k < 10; k++ {
+		tree.Put(k, k)
+	}
+	start := tree.Version()
+	tree.Delete(3)
+	changes, _, err := tree.ChangesSince(start)
+	if err != nil || len(changes) != 1 || changes[0].Op != OpDelete || changes[0].Key != 3 {
+		t.Fatalf("ChangesSince after a Delete = %v, %v", changes, err)
+	}
+	for k := 10; k < 20; k++ {
+		tree.Put(k, k)
+	}
+	tree.Delete(4) // pushes the grave of 3 past the horizon
+	if _, _, err := tree.ChangesSince(start); !errors.Is(err, ErrorResyncRequired) {
+		t.Errorf("ChangesSince past the horizon = %v, want ErrorResyncRequired", err)
+	}
+	if _, _, err := tree.ChangesSince(tree.Version() + 1); !errors.Is(err, ErrorResyncRequired) {
+		t.Errorf("ChangesSince a future version = %v, want ErrorResyncRequired", err)
+	}
+
+	// without a horizon every Delete makes older versions unanswerable
+	tree = newIntTree(t, 1, 2, 3)
+	before := tree.Version()
+	tree.Delete(2)
+	if _, _, err := tree.ChangesSince(before); !errors.Is(err, ErrorResyncRequired) {
+		t.Errorf("ChangesSince before a Delete without horizon = %v", err)
+	}
+	if _, _, err := tree.ChangesSince(tree.Version()); err != nil {
+		t.Errorf("ChangesSince the current version = %v", err)
+	}
+}
+
+func TestChangesSinceWholesaleReplacement(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	data, err := tree.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := tree.Version()
+	if err := tree.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.ChangesSince(before); !errors.Is(err, ErrorResyncRequired) {
+		t.Errorf("ChangesSince before UnmarshalJSON = %v, want ErrorResyncRequired", err)
+	}
+
+	clone := tree.Clone()
+	if clone.Version() != tree.Version() {
+		t.Errorf("clone at version %d, original at %d", clone.Version(), tree.Version())
+	}
+	if _, _, err := clone.ChangesSince(0); !errors.Is(err, ErrorResyncRequired) {
+		t.Errorf("clone answers from before its creation: %v", err)
+	}
+}
+
+func TestApplyChangesUnknownOp(t *testing.T) {
+	tree := NewTree()
+	err := tree.ApplyChanges([]Change{{Op: OpPut, Key: 1}, {Op: Op(9), Key: 2}})
+	if !errors.Is(err, ErrorUnknownOp) || !tree.Has(1) {
+		t.Errorf("ApplyChanges with an unknown op = %v, Has(1) = %v", err, tree.Has(1))
+	}
+}
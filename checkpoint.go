@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrorCheckpointCorrupted = errors.New("Checkpoint is corrupted")
+
+// Format version written by Checkpoint.
+const checkpointFormat = 1
+
+// A checkpoint is a gob stream of a checkpointHeader followed by
+// Header.Count checkpointEntry values in ascending key order.
+type checkpointHeader struct {
+	Format int
+	Count  uint64
+}
+
+type checkpointEntry struct {
+	Key   interface{}
+	Value []byte
+}
+
+// Checkpoint writes the entries of the tree to `w` in ascending key order,
+// one at a time, so that even a huge tree is saved using memory for its
+// height and the buffers only, unlike MarshalJSON which builds the whole
+// output first. Payloads are encoded with `codec`, or with the codec of
+// the tree if it is nil; keys are gob-encoded like in the journal. Expired
+// entries are left out; a nil or empty tree yields a checkpoint of no
+// entries. Restore reads the output back.
+func (t *Tree) Checkpoint(w io.Writer, codec PayloadCodec) error {
+	if codec == nil {
+		codec = t.payloadCodec()
+	}
+	var root *Node
+	var now time.Time
+	if t != nil {
+		// both passes must leave out the same entries
+		root, now = t.Root, t.now()
+	}
+	var count uint64
+	traverse(root, func(step walkStep, n *Node) {
+		if step == stepIn && !n.expiredAt(now) {
+			count++
+		}
+	})
+
+	bw := bufio.NewWriter(w)
+	encoder := gob.NewEncoder(bw)
+	if err := encoder.Encode(checkpointHeader{Format: checkpointFormat, Count: count}); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	var err error
+	traverse(root, func(step walkStep, n *Node) {
+		if err != nil || step != stepIn || n.expiredAt(now) {
+			return
+		}
+		var value []byte
+		if value, err = codec.Encode(n.payload); err != nil {
+			err = fmt.Errorf("checkpoint: encoding payload of key %#v: %w", n.Key, err)
+			return
+		}
+		if err = encoder.Encode(checkpointEntry{Key: n.Key, Value: value}); err != nil {
+			err = fmt.Errorf("checkpoint: encoding key %#v: %w", n.Key, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Restore builds a tree ordered by `cmp` from the output of Checkpoint,
+// decoding payloads with `codec` (JSONCodec if nil), which becomes the
+// codec of the tree. The balanced tree is linked up as the entries are
+// read, like by FromSorted, so no more than its height of entries is held
+// besides the tree itself. A damaged or truncated checkpoint fails with
+// ErrorCheckpointCorrupted, entries out of order with ErrorEntriesUnsorted.
+func Restore(r io.Reader, cmp Comparator, codec PayloadCodec) (*Tree, error) {
+	t := NewTreeWith(cmp)
+	t.SetCodec(codec)
+	decoder := gob.NewDecoder(bufio.NewReader(r))
+	var header checkpointHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrorCheckpointCorrupted, err)
+	}
+	if header.Format != checkpointFormat {
+		return nil, fmt.Errorf("%w: %d", ErrorUnsupportedFormat, header.Format)
+	}
+
+	var last interface{}
+	index := uint64(0)
+	red := redDepth(int(header.Count))
+	// build links the next n entries of the stream into a balanced subtree
+	// the way buildBalanced does: the left half, the middle, the right half.
+	var build func(n uint64, parent *Node, depth int) (*Node, error)
+	build = func(n uint64, parent *Node, depth int) (*Node, error) {
+		if n == 0 {
+			return nil, nil
+		}
+		node := &Node{color: BLACK, parent: parent}
+		if depth == red && depth > 0 {
+			node.color = RED
+		}
+		var err error
+		if node.Left, err = build(n/2, node, depth+1); err != nil {
+			return nil, err
+		}
+		var entry checkpointEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %s", ErrorCheckpointCorrupted, index, err)
+		}
+		if err := mustBeValidKey(entry.Key); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", index, err)
+		}
+		if index > 0 && t.compare(last, entry.Key) >= 0 {
+			return nil, fmt.Errorf("entry %d (%#v): %w", index, entry.Key, ErrorEntriesUnsorted)
+		}
+		payload, err := t.payloadCodec().Decode(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: decoding payload of key %#v: %w", index, entry.Key, err)
+		}
+		node.Key, node.payload = entry.Key, payload
+		last = entry.Key
+		index++
+		if node.Right, err = build(n-n/2-1, node, depth+1); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	root, err := build(header.Count, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	t.Root = root
+	t.size, t.sizeKnown = header.Count, true
+	return t, nil
+}
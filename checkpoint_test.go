@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTreeWithOptions(StringComparator, Options{Now: clock.Now})
+	for _, k := range []string{"m", "c", "x", "a", "q", "e", "z"} {
+		tree.Put(k, map[string]interface{}{"key": k})
+	}
+	tree.PutTTL("gone", "soon", time.Second)
+	clock.now = clock.now.Add(time.Minute)
+
+	var buf bytes.Buffer
+	if err := tree.Checkpoint(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(&buf, StringComparator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, restored)
+	if restored.Has("gone") || !restored.Equal(tree) {
+		t.Errorf("restored %v, want %v without the expired entry", restored.Items(), tree.Items())
+	}
+
+	for n := 0; n <= 33; n++ {
+		tree := NewTree()
+		for k := 0; k < n; k++ {
+			tree.Put(k, k)
+		}
+		buf.Reset()
+		if err := tree.Checkpoint(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := Restore(&buf, nil, nil)
+		if err != nil {
+			t.Fatalf("%d entries: %s", n, err)
+		}
+		checkRedBlack(t, restored)
+		// JSONCodec decodes the int payloads as float64, so compare keys
+		if restored.Size() != uint64(n) || !reflect.DeepEqual(keysOf(restored), keysOf(tree)) {
+			t.Fatalf("%d entries: restored %v", n, restored.Items())
+		}
+	}
+}
+
+func TestRestoreRejectsDamagedInput(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k)
+	}
+	var buf bytes.Buffer
+	if err := tree.Checkpoint(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, cut := range []int{0, 5, len(data) / 2, len(data) - 1} {
+		if _, err := Restore(bytes.NewReader(data[:cut]), nil, nil); !errors.Is(err, ErrorCheckpointCorrupted) {
+			t.Errorf("checkpoint cut at %d of %d bytes: %v, want ErrorCheckpointCorrupted", cut, len(data), err)
+		}
+	}
+
+	buf.Reset()
+	encoder := gob.NewEncoder(&buf)
+	encoder.Encode(checkpointHeader{Format: checkpointFormat, Count: 3})
+	for _, k := range []int{1, 3, 2} {
+		encoder.Encode(checkpointEntry{Key: k, Value: []byte("null")})
+	}
+	if _, err := Restore(&buf, nil, nil); !errors.Is(err, ErrorEntriesUnsorted) {
+		t.Errorf("entries out of order: %v, want ErrorEntriesUnsorted", err)
+	}
+}
+
+// peakHeapWriter discards what is written and samples the live heap.
+type peakHeapWriter struct {
+	written, writes int
+	peak            uint64
+}
+
+func (w *peakHeapWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.writes++; w.writes%16 == 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > w.peak {
+			w.peak = m.HeapAlloc
+		}
+	}
+	return len(p), nil
+}
+
+func TestCheckpointBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a large tree")
+	}
+	tree := NewTree()
+	for k := 0; k < 300000; k++ {
+		tree.Put(k, "a payload of some length to make the checkpoint big")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(5))
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w := &peakHeapWriter{}
+	if err := tree.Checkpoint(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if growth := w.peak - m.HeapAlloc; w.peak > m.HeapAlloc && growth > uint64(w.written)/3 {
+		t.Errorf("live heap grew by %d bytes writing a %d-byte checkpoint", growth, w.written)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+// Clone returns a deep copy of the tree: every node is duplicated, so the
+// copy and the original can be mutated independently. Payloads themselves
+// are shared. The copy keeps the comparator, codec, key normalizer,
+// options, weights and version, but not the journal, the undo history or
+// the deleted keys, so its ChangesSince answers from its current version.
+func (t *Tree) Clone() *Tree {
+	c := &Tree{
+		cmp:           t.cmp,
+		cmpName:       t.cmpName,
+		codec:         t.codec,
+		KeyNormalizer: t.KeyNormalizer,
+		opts:          t.opts,
+		size:          t.size,
+		sizeKnown:     t.sizeKnown,
+		weightOf:      t.weightOf,
+		version:       t.version,
+		floor:         t.version,
+	}
+	c.Root = cloneNodes(t.Root, nil)
+	return c
+}
+
+func cloneNodes(n *Node, parent *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	c := &Node{Key: n.Key, payload: n.payload, color: n.color, Leaf: n.Leaf, parent: parent, expires: n.expires, weight: n.weight, version: n.version}
+	c.Left = cloneNodes(n.Left, c)
+	c.Right = cloneNodes(n.Right, c)
+	return c
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentTree guards a Tree with a sync.RWMutex so that it can be
+// shared between goroutines: lookups share a read lock, mutations take
+// the write lock.
+type ConcurrentTree struct {
+	lock sync.RWMutex
+	tree *Tree
+
+	loadLock sync.Mutex                // protects `loading`
+	loading  map[interface{}]*loadCall // loader calls in flight by key
+
+	writeLock sync.Mutex                 // protects `writing`
+	writing   map[interface{}]*writeSlot // Writer calls in flight by key
+}
+
+// NewConcurrentTree returns an empty ConcurrentTree ordered by `c`.
+func NewConcurrentTree(c Comparator) *ConcurrentTree {
+	return &ConcurrentTree{tree: NewTreeWith(c)}
+}
+
+// Get looks for the node with supplied key and returns its mapped payload.
+// Misses are loaded as described for GetOrLoad.
+func (ct *ConcurrentTree) Get(key interface{}) (bool, interface{}) {
+	found, payload, err := ct.GetOrLoad(key)
+	if err != nil {
+		ct.lock.RLock()
+		ct.tree.tracef(LevelError, "Get: %s\n", err.Error())
+		ct.lock.RUnlock()
+	}
+	return found, payload
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (ct *ConcurrentTree) Has(key interface{}) bool {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Has(key)
+}
+
+// Size returns the number of items in the tree.
+func (ct *ConcurrentTree) Size() uint64 {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Size()
+}
+
+// RangeSearch returns the keys within [low, high] in ascending order.
+func (ct *ConcurrentTree) RangeSearch(low, high interface{}) []interface{} {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.RangeSearch(low, high)
+}
+
+// Walk runs the visitor under the read lock. The visitor must not
+// mutate the tree.
+func (ct *ConcurrentTree) Walk(visitor Visitor) {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	ct.tree.Walk(visitor)
+}
+
+// Put saves the mapping (key, data) into the tree.
+// Options.Writer is called without holding the lock, one write per key
+// at a time.
+func (ct *ConcurrentTree) Put(key interface{}, data interface{}) error {
+	unlock, writer, inverse, err := ct.writeThrough("Put", OpPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ct.lock.Lock()
+	err = ct.tree.save(key, data)
+	ct.lock.Unlock()
+	if err != nil {
+		return writer.undo(inverse, err)
+	}
+	return nil
+}
+
+// Delete removes the item identified by the supplied key.
+// Options.Writer is called as for Put.
+func (ct *ConcurrentTree) Delete(key interface{}) error {
+	unlock, writer, inverse, err := ct.writeThrough("Delete", OpDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ct.lock.Lock()
+	err = ct.tree.erase(key)
+	ct.lock.Unlock()
+	if err != nil {
+		return writer.undo(inverse, err)
+	}
+	return nil
+}
+
+// SwapTree publishes versions of a PersistentTree through an atomic
+// pointer. Readers Load the current version and query it without taking
+// any lock, so reads never wait for writers; writers are serialized by a
+// mutex, derive the next version and Store it.
+type SwapTree struct {
+	lock    sync.Mutex // serializes writers
+	current atomic.Pointer[PersistentTree]
+}
+
+// NewSwapTree returns an empty SwapTree ordered by `c`.
+func NewSwapTree(c Comparator) *SwapTree {
+	s := &SwapTree{}
+	s.current.Store(NewPersistentTree(c))
+	return s
+}
+
+// Load returns the current version. It stays valid and unchanged
+// however many writes happen afterwards.
+func (s *SwapTree) Load() *PersistentTree {
+	return s.current.Load()
+}
+
+// Get looks up `key` in the current version.
+func (s *SwapTree) Get(key interface{}) (bool, interface{}) {
+	return s.Load().Get(key)
+}
+
+// Has checks `key` in the current version.
+func (s *SwapTree) Has(key interface{}) bool {
+	return s.Load().Has(key)
+}
+
+// Size returns the number of items in the current version.
+func (s *SwapTree) Size() uint64 {
+	return s.Load().Size()
+}
+
+// Put publishes a version holding the mapping (key, data).
+func (s *SwapTree) Put(key interface{}, data interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	next, err := s.Load().Put(key, data)
+	if err != nil {
+		return err
+	}
+	s.current.Store(next)
+	return nil
+}
+
+// Delete publishes a version without `key`.
+func (s *SwapTree) Delete(key interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.Store(s.Load().Delete(key))
+}
+
+// Snapshot returns a deep copy of the tree taken under the read lock.
+// The copy can then be scanned at leisure without blocking writers, and
+// reflects the tree at a single point in time.
+func (ct *ConcurrentTree) Snapshot() *Tree {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.tree.Clone()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// Run with -race: readers query while a writer publishes new versions.
+func TestSwapTreeConcurrentReadsAndWrites(t *testing.T) {
+	s := NewSwapTree(IntComparator)
+	for k := 0; k < 100; k++ {
+		s.Put(k, k)
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				version := s.Load()
+				size := version.Size()
+				// the even keys are never touched by the writer
+				for k := 0; k < 100; k += 2 {
+					if found, v := version.Get(k); !found || v != k {
+						t.Errorf("Get(%d) = %v, %v", k, found, v)
+						return
+					}
+				}
+				if version.Size() != size {
+					t.Error("a loaded version changed")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2000; i++ {
+		k := 2*(i%50) + 1
+		if i%2 == 0 {
+			s.Delete(k)
+		} else {
+			s.Put(k, i)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestConcurrentTreeParallelWriters(t *testing.T) {
+	ct := NewConcurrentTree(IntComparator)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := w * 100; k < (w+1)*100; k++ {
+				ct.Put(k, k)
+				ct.Get(k - 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if ct.Size() != 800 {
+		t.Fatalf("Size() = %d, want 800", ct.Size())
+	}
+}
+
+// Run with -race: the source keeps changing while a snapshot is scanned.
+func TestConcurrentTreeSnapshot(t *testing.T) {
+	ct := NewConcurrentTree(IntComparator)
+	for k := 0; k < 1000; k++ {
+		ct.Put(k, k)
+	}
+	snapshot := ct.Snapshot()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for k := 0; k < 1000; k++ {
+			ct.Delete(k)
+			ct.Put(k+1000, k)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		count := 0
+		for it := snapshot.Iterator(); it.Next(); count++ {
+			if it.Key() != count || it.Value() != count {
+				t.Fatalf("snapshot yielded %v: %v at position %d", it.Key(), it.Value(), count)
+			}
+		}
+		if count != 1000 {
+			t.Fatalf("snapshot holds %d entries, want 1000", count)
+		}
+	}
+	wg.Wait()
+	checkRedBlack(t, snapshot)
+	if ct.Has(0) || !ct.Has(1999) {
+		t.Error("writes to the source were lost")
+	}
+}
+
+// benchmarkReads measures Get while another goroutine keeps writing.
+func benchmarkReads(b *testing.B, get func(int), put func(int)) {
+	for k := 0; k < 10000; k++ {
+		put(k)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for k := 0; ; k++ {
+			select {
+			case <-done:
+				return
+			default:
+				put(k % 10000)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		k := 0
+		for pb.Next() {
+			get(k % 10000)
+			k += 7
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkConcurrentTreeReads(b *testing.B) {
+	ct := NewConcurrentTree(IntComparator)
+	benchmarkReads(b, func(k int) { ct.Get(k) }, func(k int) { ct.Put(k, k) })
+}
+
+func BenchmarkSwapTreeReads(b *testing.B) {
+	s := NewSwapTree(IntComparator)
+	benchmarkReads(b, func(k int) { s.Get(k) }, func(k int) { s.Put(k, k) })
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DebugPayloadLimit caps the length of a payload as printed by GoString
+// and DebugString; longer output is cut and ends in "...". 0 disables the cap.
+var DebugPayloadLimit = 64
+
+// GoString describes the node on one line with its key, color, payload and
+// the keys of its children, so that %#v is readable instead of a dump of
+// pointers:
+//
+//	&Node{Key: 5, Color: Black, Payload: "five", Left: 3, Right: <nil>}
+func (n *Node) GoString() string {
+	if n == nil {
+		return "(*Node)(nil)"
+	}
+	return fmt.Sprintf("&Node{Key: %#v, Color: %s, Payload: %s, Left: %s, Right: %s}",
+		n.Key, n.color, debugPayload(n.payload), childKey(n.Left), childKey(n.Right))
+}
+
+// DebugString renders the subtree rooted at n, one node per line and
+// indented by level, down to `depth` levels below n. Deeper subtrees are
+// elided as "...".
+//
+//	5 Black "five"
+//	  L 3 Red "three"
+//	  R 8 Red "eight"
+func (n *Node) DebugString(depth int) string {
+	var b strings.Builder
+	n.debugWrite(&b, "", 0, depth)
+	return b.String()
+}
+
+func (n *Node) debugWrite(b *strings.Builder, side string, level, depth int) {
+	if n == nil {
+		return
+	}
+	indent := strings.Repeat("  ", level)
+	if level > depth {
+		fmt.Fprintf(b, "%s%s...\n", indent, side)
+		return
+	}
+	fmt.Fprintf(b, "%s%s%#v %s %s\n", indent, side, n.Key, n.color, debugPayload(n.payload))
+	n.Left.debugWrite(b, "L ", level+1, depth)
+	n.Right.debugWrite(b, "R ", level+1, depth)
+}
+
+func childKey(n *Node) string {
+	if n == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%#v", n.Key)
+}
+
+func debugPayload(payload interface{}) string {
+	s := fmt.Sprintf("%#v", payload)
+	if DebugPayloadLimit > 0 && len(s) > DebugPayloadLimit {
+		s = s[:DebugPayloadLimit] + "..."
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNodeGoString(t *testing.T) {
+	tree := NewTree()
+	tree.Put(5, "five")
+	tree.Put(3, "three")
+	want := `&Node{Key: 5, Color: Black, Payload: "five", Left: 3, Right: <nil>}`
+	if got := fmt.Sprintf("%#v", tree.Root); got != want {
+		t.Errorf("%%#v = %s, want %s", got, want)
+	}
+	if got := fmt.Sprintf("%#v", (*Node)(nil)); got != "(*Node)(nil)" {
+		t.Errorf("nil node: %s", got)
+	}
+
+	defer func(limit int) { DebugPayloadLimit = limit }(DebugPayloadLimit)
+	DebugPayloadLimit = 8
+	long := NewNode(1, strings.Repeat("x", 100))
+	want = `&Node{Key: 1, Color: Red, Payload: "xxxxxxx..., Left: <nil>, Right: <nil>}`
+	if got := long.GoString(); got != want {
+		t.Errorf("truncated GoString() = %s, want %s", got, want)
+	}
+}
+
+func TestNodeDebugString(t *testing.T) {
+	tree := NewTree()
+	for _, k := range []int{5, 3, 8, 1} {
+		tree.Put(k, k*10)
+	}
+	for _, tc := range []struct {
+		depth int
+		want  string
+	}{
+		{0, "5 Black 50\n  L ...\n  R ...\n"},
+		{1, "5 Black 50\n  L 3 Black 30\n    L ...\n  R 8 Black 80\n"},
+		{5, "5 Black 50\n  L 3 Black 30\n    L 1 Red 10\n  R 8 Black 80\n"},
+	} {
+		if got := tree.Root.DebugString(tc.depth); got != tc.want {
+			t.Errorf("DebugString(%d) =\n%s\nwant\n%s", tc.depth, got, tc.want)
+		}
+	}
+}
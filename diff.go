@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+)
+
+// Difference describes a key on which two trees disagree: it is missing
+// from one of them, or their payloads differ.
+type Difference struct {
+	Key             interface{}
+	Left, Right     interface{} // payloads; nil where the key is missing
+	InLeft, InRight bool
+}
+
+// Diff lists the keys on which `t` (left) and `other` (right) disagree, in
+// ascending key order. Payloads are compared with Options.PayloadEquals of
+// `t`, by default reflect.DeepEqual, so payloads of any type, including
+// maps and slices, can be compared. Both trees must order keys the same
+// way; the comparator of `t` is used.
+func (t *Tree) Diff(other *Tree) []Difference {
+	diffs := []Difference{}
+	t.diff(other, func(d Difference) bool {
+		diffs = append(diffs, d)
+		return true
+	})
+	return diffs
+}
+
+// Equal reports whether `t` and `other` hold the same keys with equal
+// payloads, as judged by Diff, regardless of their shapes. It stops at the
+// first difference.
+func (t *Tree) Equal(other *Tree) bool {
+	equal := true
+	t.diff(other, func(Difference) bool {
+		equal = false
+		return false
+	})
+	return equal
+}
+
+// diff merges the entries of both trees and calls fn with each difference
+// until it returns false.
+func (t *Tree) diff(other *Tree, fn func(Difference) bool) {
+	eq := t.payloadEquals()
+	left, right := t.Items(), other.Items()
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		var d Difference
+		switch {
+		case j == len(right) || (i < len(left) && t.compare(left[i].Key, right[j].Key) < 0):
+			d = Difference{Key: left[i].Key, Left: left[i].Value, InLeft: true}
+			i++
+		case i == len(left) || t.compare(left[i].Key, right[j].Key) > 0:
+			d = Difference{Key: right[j].Key, Right: right[j].Value, InRight: true}
+			j++
+		default:
+			l, r := left[i], right[j]
+			i, j = i+1, j+1
+			if eq(l.Value, r.Value) {
+				continue
+			}
+			d = Difference{Key: l.Key, Left: l.Value, Right: r.Value, InLeft: true, InRight: true}
+		}
+		if !fn(d) {
+			return
+		}
+	}
+}
+
+// payloadEquals returns Options.PayloadEquals, or reflect.DeepEqual if it
+// is not set.
+func (t *Tree) payloadEquals() func(a, b interface{}) bool {
+	if t != nil && t.opts.PayloadEquals != nil {
+		return t.opts.PayloadEquals
+	}
+	return reflect.DeepEqual
+}
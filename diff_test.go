@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffMapPayloads(t *testing.T) {
+	left, right := NewTree(), NewTree()
+	for k := 0; k < 5; k++ {
+		left.Put(k, map[string]int{"v": k})
+		right.Put(k, map[string]int{"v": k})
+	}
+	if !left.Equal(right) {
+		t.Fatal("trees with equal map payloads are not Equal")
+	}
+	right.Put(2, map[string]int{"v": 20})
+	right.Put(5, []int{5})
+	left.Delete(0)
+
+	want := []Difference{
+		{Key: 0, Right: map[string]int{"v": 0}, InRight: true},
+		{Key: 2, Left: map[string]int{"v": 2}, Right: map[string]int{"v": 20}, InLeft: true, InRight: true},
+		{Key: 5, Right: []int{5}, InRight: true},
+	}
+	if got := left.Diff(right); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %v, want %v", got, want)
+	}
+	if left.Equal(right) {
+		t.Error("differing trees are Equal")
+	}
+	if got := left.Diff(left); len(got) != 0 {
+		t.Errorf("Diff of a tree with itself = %v", got)
+	}
+}
+
+func TestDiffIgnoresShape(t *testing.T) {
+	ascending, shuffled := NewTree(), NewTree()
+	for k := 0; k < 100; k++ {
+		ascending.Put(k, k)
+	}
+	for _, k := range []int{50, 25, 75} {
+		shuffled.Put(k, k)
+	}
+	for k := 0; k < 100; k++ {
+		shuffled.Put(k, k)
+	}
+	if shapeOf(ascending) == shapeOf(shuffled) || !ascending.Equal(shuffled) {
+		t.Error("trees with the same entries in different shapes are not Equal")
+	}
+}
+
+func TestPayloadEquals(t *testing.T) {
+	// payloads equal if they agree modulo 10
+	mod10 := func(a, b interface{}) bool { return a.(int)%10 == b.(int)%10 }
+	left := NewTreeWithOptions(IntComparator, Options{PayloadEquals: mod10})
+	right := NewTree()
+	for k := 0; k < 10; k++ {
+		left.Put(k, k)
+		right.Put(k, k+10)
+	}
+	if !left.Equal(right) {
+		t.Errorf("Equal ignores PayloadEquals: %v", left.Diff(right))
+	}
+	if right.Equal(left) {
+		t.Error("Equal uses PayloadEquals of the other tree")
+	}
+}
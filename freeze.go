@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrorTreeIsFrozen = errors.New("The tree is frozen")
+
+// Freeze makes the tree read-only: from now on every mutation fails with
+// ErrorTreeIsFrozen (or reports that nothing changed, for methods without
+// an error result) until Unfreeze is called. Since reads never modify a
+// tree, a frozen tree may be published to any number of goroutines without
+// locking, provided Unfreeze isn't called while they read.
+func (t *Tree) Freeze() {
+	if t != nil {
+		t.frozen = true
+	}
+}
+
+// Unfreeze makes a frozen tree writable again.
+func (t *Tree) Unfreeze() {
+	if t != nil {
+		t.frozen = false
+	}
+}
+
+// IsFrozen reports whether the tree is read-only; see Freeze.
+func (t *Tree) IsFrozen() bool {
+	return t != nil && t.frozen
+}
+
+// mutable returns an error if `op` may not modify the tree.
+func (t *Tree) mutable(op string) error {
+	if t == nil {
+		return ErrorTreeIsNil
+	}
+	if t.frozen {
+		t.tracef(LevelError, "%s was rejected: the tree is frozen\n", op)
+		return fmt.Errorf("%w: %s", ErrorTreeIsFrozen, op)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrozenTreeRejectsMutations(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 4, 5)
+	tree.EnableHistory(10)
+	tree.Put(6, 6)
+	tree.PutTTL(0, 0, time.Nanosecond) // for Sweep to find
+	tree.Freeze()
+	if !tree.IsFrozen() {
+		t.Fatal("IsFrozen() = false after Freeze")
+	}
+	before := tree.Items()
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mutate := range map[string]func() error{
+		"Put":        func() error { return tree.Put(7, 7) },
+		"Delete":     func() error { return tree.Delete(1) },
+		"Insert":     func() error { return tree.Insert(NewNode(8, 8)) },
+		"ReplaceKey": func() error { return tree.ReplaceKey(2, 20) },
+		"BulkPut":    func() error { return tree.BulkPut([]Entry{{Key: 9}}) },
+		"Undo":       func() error { return tree.Undo() },
+		"UnmarshalJSON": func() error {
+			return json.Unmarshal(data, tree)
+		},
+		"Apply": func() error {
+			return tree.Apply(func(tx *Txn) error { return tx.Put(10, 10) })
+		},
+	} {
+		if err := mutate(); !errors.Is(err, ErrorTreeIsFrozen) {
+			t.Errorf("%s on a frozen tree = %v, want ErrorTreeIsFrozen", name, err)
+		}
+	}
+	if tree.SetValue(3, "three") {
+		t.Error("SetValue reported a change to a frozen tree")
+	}
+	if n := tree.DeleteIf(func(key, payload interface{}) bool { return true }); n != 0 {
+		t.Errorf("DeleteIf removed %d entries from a frozen tree", n)
+	}
+	if n := tree.Sweep(time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("Sweep removed %d entries from a frozen tree", n)
+	}
+	tree.MapValues(func(key, payload interface{}) interface{} { return nil })
+	tree.Compact()
+	if got := tree.Items(); !reflect.DeepEqual(got, before) {
+		t.Fatalf("frozen tree changed to %v", got)
+	}
+	if tree.HistoryLen() != 2 {
+		t.Errorf("HistoryLen() = %d, want 2", tree.HistoryLen())
+	}
+
+	tree.Unfreeze()
+	if tree.IsFrozen() {
+		t.Fatal("IsFrozen() = true after Unfreeze")
+	}
+	if err := tree.Put(7, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(1); err != nil || tree.Has(1) {
+		t.Fatalf("Delete after Unfreeze = %v", err)
+	}
+}
+
+func TestFrozenTreeConcurrentReads(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 1000; k++ {
+		tree.Put(k, k)
+	}
+	tree.Freeze()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for k := g; k < 1000; k += 8 {
+				if _, v := tree.Get(k); v != k {
+					t.Errorf("Get(%d) = %v", k, v)
+				}
+				tree.RangeSearch(k, k+10)
+				if tree.Put(k, nil) == nil {
+					t.Errorf("Put(%d) succeeded on a frozen tree", k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestNilTreeIsNotFrozen(t *testing.T) {
+	var tree *Tree
+	tree.Freeze()
+	tree.Unfreeze()
+	if tree.IsFrozen() {
+		t.Error("nil tree reports being frozen")
+	}
+	if err := tree.Delete(1); err != ErrorTreeIsNil {
+		t.Errorf("Delete on a nil tree = %v, want ErrorTreeIsNil", err)
+	}
+}
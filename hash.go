@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Hash returns a 64-bit FNV-1a hash of the entries of the tree in ascending
+// key order, formatted with %#v. It depends on contents only, not on the
+// shape of the tree, so two trees holding the same entries hash equal,
+// which makes it suitable for "did anything change?" checks and cache
+// keys. Payloads holding pointers hash by address, not by what they point
+// to. Expired entries are left out, like everywhere else.
+func (t *Tree) Hash() uint64 {
+	h := fnv.New64a()
+	if t.IsEmpty() {
+		return h.Sum64()
+	}
+	t.rangeWalk(nil, nil, func(n *Node) bool {
+		// %#v quotes strings, so the NUL separators can't be forged
+		fmt.Fprintf(h, "%#v\x00%#v\x00", n.Key, n.payload)
+		return true
+	})
+	return h.Sum64()
+}
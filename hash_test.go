@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHashDependsOnContentsOnly(t *testing.T) {
+	// ascending inserts, descending inserts and a balanced build give
+	// three different shapes of the same entries
+	ascending, descending := NewTree(), NewTree()
+	entries := make([]Entry, 100)
+	for k := 0; k < 100; k++ {
+		ascending.Put(k, k*2)
+		descending.Put(99-k, (99-k)*2)
+		entries[k] = Entry{Key: k, Value: k * 2}
+	}
+	built, err := FromSorted(entries, IntComparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shapeOf(ascending) == shapeOf(descending) || shapeOf(ascending) == shapeOf(built) {
+		t.Fatal("the trees were meant to differ in shape")
+	}
+	want := ascending.Hash()
+	if descending.Hash() != want || built.Hash() != want {
+		t.Errorf("hashes %x, %x, %x differ for the same entries", want, descending.Hash(), built.Hash())
+	}
+
+	if NewTree().Hash() == want {
+		t.Error("empty tree hashes like a full one")
+	}
+	ascending.Put(50, "changed")
+	if ascending.Hash() == want {
+		t.Error("changing a payload kept the hash")
+	}
+	ascending.Put(50, 100)
+	if ascending.Hash() != want {
+		t.Error("restoring the payload did not restore the hash")
+	}
+	ascending.Delete(0)
+	if ascending.Hash() == want {
+		t.Error("deleting a key kept the hash")
+	}
+}
+
+func TestHashSeparatesKeysFromPayloads(t *testing.T) {
+	a := NewTreeWith(StringComparator)
+	a.Put("a", "b")
+	b := NewTreeWith(StringComparator)
+	b.Put("a\x00\"b", "")
+	if a.Hash() == b.Hash() {
+		t.Error("entries spelled alike hash equal")
+	}
+}
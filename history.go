@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+)
+
+var ErrorNothingToUndo = errors.New("Nothing to undo")
+
+// undoRecord is the operation restoring the mapping of `key` to what it
+// was before a mutation: either a put of the old payload or a delete.
+type undoRecord struct {
+	op      byte
+	key     interface{}
+	payload interface{}
+}
+
+// EnableHistory starts recording the inverse of every mutation so that it
+// can be reverted with Undo. Only the most recent `depth` mutations are
+// kept; a depth of zero or less disables recording and drops the history.
+func (t *Tree) EnableHistory(depth int) {
+	if depth <= 0 {
+		t.history, t.historyDepth = nil, 0
+		return
+	}
+	t.historyDepth = depth
+	if len(t.history) > depth {
+		t.history = append([]undoRecord(nil), t.history[len(t.history)-depth:]...)
+	}
+}
+
+// HistoryLen returns the number of mutations Undo can currently revert.
+func (t *Tree) HistoryLen() int {
+	return len(t.history)
+}
+
+// Undo reverts the most recent recorded mutation. Reverting an insert
+// deletes the key again, so the shape of the tree may differ from the one
+// before the insert, but its contents are the same.
+// Undo itself is journaled and handed to Options.Writer but not recorded
+// in the history. If the writer rejects it or the journal write fails,
+// the mutation stays in place and in the history.
+func (t *Tree) Undo() error {
+	if err := t.mutable("Undo"); err != nil {
+		return err
+	}
+	if len(t.history) == 0 {
+		return ErrorNothingToUndo
+	}
+	defer t.beginOp("undo")()
+	record := t.history[len(t.history)-1]
+	written, err := t.writeThrough("Undo", writerOp(record.op), record.key, record.payload)
+	if err != nil {
+		return err
+	}
+	redo := t.captureInverse(record.key)
+	if err := t.apply(record); err != nil {
+		return t.opts.Writer.undo(written, err)
+	}
+	if err := t.journalRecord(record.op, record.key, record.payload); err != nil {
+		t.rollback(redo)
+		return t.opts.Writer.undo(written, err)
+	}
+	t.history = t.history[:len(t.history)-1]
+	return nil
+}
+
+func (t *Tree) revert(record undoRecord) error {
+	if err := t.apply(record); err != nil {
+		return err
+	}
+	return t.journalRecord(record.op, record.key, record.payload)
+}
+
+// apply carries out `record` without journaling it.
+func (t *Tree) apply(record undoRecord) error {
+	switch record.op {
+	case journalPut:
+		return t.put(record.key, record.payload)
+	case journalDelete:
+		t.remove(record.key)
+	}
+	return nil
+}
+
+// rollback undoes a mutation that could not be journaled.
+func (t *Tree) rollback(inverse *undoRecord) {
+	if inverse == nil {
+		return
+	}
+	if err := t.apply(*inverse); err != nil {
+		t.tracef(LevelError, "rollback of key %#v failed: %s\n", inverse.key, err.Error())
+	}
+}
+
+// inverseOf captures how to restore the current mapping of `key`, or
+// returns nil when there is neither a history to record it in nor a
+// journal whose failure would need it to roll the mutation back.
+func (t *Tree) inverseOf(key interface{}) *undoRecord {
+	if t.historyDepth == 0 && t.Journal == nil {
+		return nil
+	}
+	return t.captureInverse(key)
+}
+
+func (t *Tree) captureInverse(key interface{}) *undoRecord {
+	if mustBeValidKey(key) != nil {
+		return nil
+	}
+	if found, node := t.getNode(key); found {
+		return &undoRecord{op: journalPut, key: key, payload: node.payload}
+	}
+	return &undoRecord{op: journalDelete, key: key}
+}
+
+// commit does the bookkeeping of a successful mutation: it appends the
+// mutation to the journal and records the inverse operation, if any.
+// If the journal write fails, the mutation is rolled back with `inverse`
+// and nothing is recorded, so the tree never holds what was not journaled.
+func (t *Tree) commit(op byte, key, payload interface{}, inverse *undoRecord) error {
+	if err := t.journalRecord(op, key, payload); err != nil {
+		t.tracef(LevelError, "journal write of key %#v failed, rolling back: %s\n", key, err.Error())
+		t.rollback(inverse)
+		return err
+	}
+	t.pushHistory(inverse)
+	return nil
+}
+
+func (t *Tree) pushHistory(inverse *undoRecord) {
+	if inverse == nil || t.historyDepth == 0 {
+		return
+	}
+	t.history = append(t.history, *inverse)
+	if len(t.history) > t.historyDepth {
+		t.history = t.history[1:]
+	}
+}
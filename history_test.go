@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestUndoRandomOperations(t *testing.T) {
+	rng := rand.New(rand.NewSource(611))
+	for round := 0; round < 50; round++ {
+		tree := NewTree()
+		for i := 0; i < 30; i++ {
+			k := rng.Intn(60)
+			tree.Put(k, k)
+		}
+		initial := tree.Items()
+
+		const n = 200
+		tree.EnableHistory(n)
+		for i := 0; i < n; i++ {
+			k := rng.Intn(60)
+			if rng.Intn(3) == 0 {
+				tree.Delete(k)
+			} else {
+				tree.Put(k, rng.Int())
+			}
+			checkRedBlack(t, tree)
+		}
+		for tree.HistoryLen() > 0 {
+			if err := tree.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			checkRedBlack(t, tree)
+		}
+		if got := tree.Items(); !reflect.DeepEqual(got, initial) {
+			t.Fatalf("round %d: undoing everything left %v, want %v", round, got, initial)
+		}
+	}
+}
+
+func TestUndoDepth(t *testing.T) {
+	tree := NewTree()
+	if err := tree.Undo(); err != ErrorNothingToUndo {
+		t.Fatalf("got %v, want ErrorNothingToUndo", err)
+	}
+	tree.EnableHistory(2)
+	for k := 1; k <= 4; k++ {
+		tree.Put(k, k)
+	}
+	if tree.HistoryLen() != 2 {
+		t.Fatalf("HistoryLen() = %d, want 2", tree.HistoryLen())
+	}
+	tree.Undo()
+	tree.Undo()
+	if err := tree.Undo(); err != ErrorNothingToUndo {
+		t.Fatalf("got %v, want ErrorNothingToUndo", err)
+	}
+	if got, want := tree.Items(), []Entry{{1, 1}, {2, 2}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	tree.EnableHistory(0)
+	tree.Put(5, 5)
+	if tree.HistoryLen() != 0 {
+		t.Fatal("disabled history still records")
+	}
+}
+
+func TestDeleteKeepsInvariants(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tree := NewTree()
+	keys := rng.Perm(500)
+	for _, k := range keys {
+		tree.Put(k, k)
+	}
+	for _, k := range rng.Perm(500) {
+		tree.Delete(k)
+		checkRedBlack(t, tree)
+	}
+	if tree.Size() != 0 {
+		t.Fatalf("%d nodes left", tree.Size())
+	}
+}
+
+func TestUndoJournalFailure(t *testing.T) {
+	tree := NewTree()
+	tree.EnableHistory(5)
+	tree.Put(1, "one")
+	tree.Put(1, "uno")
+	journal := &failingWriter{}
+	tree.Journal = journal
+	if err := tree.Undo(); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("got %v, want the journal's error", err)
+	}
+	if tree.HistoryLen() != 2 {
+		t.Fatalf("HistoryLen() = %d after a failed Undo, want 2", tree.HistoryLen())
+	}
+	if _, v := tree.Get(1); v != "uno" {
+		t.Fatalf("failed Undo left %v", v)
+	}
+	journal.budget = 1
+	if err := tree.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, v := tree.Get(1); v != "one" {
+		t.Fatalf("Undo left %v", v)
+	}
+}
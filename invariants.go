@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrorInvariantViolated = errors.New("Red-black tree invariant violated")
+
+// CheckInvariants verifies that the tree is a valid red-black tree:
+// keys are strictly ascending in order, parent pointers are consistent
+// (unless nodes are shared after PutPersistent),
+// the root is black, no red node has a red child and every path from a
+// node to its leaves contains the same number of black nodes.
+// It returns nil on success and an error naming the first violation otherwise.
+func (t *Tree) CheckInvariants() error {
+	if t.Root == nil {
+		return nil
+	}
+	if t.Root.parent != nil {
+		return fmt.Errorf("%w: root %s has a parent", ErrorInvariantViolated, t.Root)
+	}
+	if t.Root.color != BLACK {
+		return fmt.Errorf("%w: root %s is red", ErrorInvariantViolated, t.Root)
+	}
+	_, err := t.checkSubtree(t.Root, nil, nil)
+	return err
+}
+
+// checkRangeTree is CheckInvariants for a leaf-based range tree, such as
+// the one built by main, which is not a red-black tree: its keys are held
+// by the leaves, which have no children, while every inner node repeats a
+// key to steer the descent, so that the keys of its left subtree are at
+// or below its own and those of its right subtree above it. Parent
+// pointers are checked as by CheckInvariants; colors are not.
+func (t *Tree) checkRangeTree() error {
+	if t.Root == nil {
+		return nil
+	}
+	if t.Root.parent != nil {
+		return fmt.Errorf("%w: root %s has a parent", ErrorInvariantViolated, t.Root)
+	}
+	// the keys of the subtree rooted at n must lie in (lo, hi]
+	var check func(n, lo, hi *Node) error
+	check = func(n, lo, hi *Node) error {
+		if n == nil {
+			return nil
+		}
+		if lo != nil && t.compare(lo.Key, n.Key) >= 0 {
+			return fmt.Errorf("%w: %s is not after %s", ErrorInvariantViolated, n, lo)
+		}
+		if hi != nil && t.compare(n.Key, hi.Key) > 0 {
+			return fmt.Errorf("%w: %s is after %s", ErrorInvariantViolated, n, hi)
+		}
+		if n.Leaf && (n.Left != nil || n.Right != nil) {
+			return fmt.Errorf("%w: leaf %s has children", ErrorInvariantViolated, n)
+		}
+		for _, child := range []*Node{n.Left, n.Right} {
+			if child != nil && child.parent != n {
+				return fmt.Errorf("%w: %s does not point back to parent %s", ErrorInvariantViolated, child, n)
+			}
+		}
+		if err := check(n.Left, lo, n); err != nil {
+			return err
+		}
+		return check(n.Right, n, hi)
+	}
+	return check(t.Root, nil, nil)
+}
+
+// checkSubtree returns the black height of the subtree rooted at n, whose
+// keys must lie strictly between the keys of `lo` and `hi` (when not nil).
+func (t *Tree) checkSubtree(n, lo, hi *Node) (int, error) {
+	if n == nil {
+		return 1, nil
+	}
+	if lo != nil && t.compare(lo.Key, n.Key) >= 0 {
+		return 0, fmt.Errorf("%w: %s is not after %s", ErrorInvariantViolated, n, lo)
+	}
+	if hi != nil && t.compare(n.Key, hi.Key) >= 0 {
+		return 0, fmt.Errorf("%w: %s is not before %s", ErrorInvariantViolated, n, hi)
+	}
+	for _, child := range []*Node{n.Left, n.Right} {
+		if child == nil {
+			continue
+		}
+		if child.parent != n && !t.shared {
+			return 0, fmt.Errorf("%w: %s does not point back to parent %s", ErrorInvariantViolated, child, n)
+		}
+		if n.color == RED && child.color == RED {
+			return 0, fmt.Errorf("%w: red %s has red child %s", ErrorInvariantViolated, n, child)
+		}
+	}
+
+	left, err := t.checkSubtree(n.Left, lo, n)
+	if err != nil {
+		return 0, err
+	}
+	right, err := t.checkSubtree(n.Right, n, hi)
+	if err != nil {
+		return 0, err
+	}
+	if left != right {
+		return 0, fmt.Errorf("%w: black heights of %s differ (%d left, %d right)", ErrorInvariantViolated, n, left, right)
+	}
+	if n.color == BLACK {
+		left++
+	}
+	return left, nil
+}
+
+// KeyType returns the dynamic type shared by all keys of the tree, and false
+// if keys of different types were mixed, which comparators usually don't
+// expect. An empty tree reports a nil type and true.
+func (t *Tree) KeyType() (reflect.Type, bool) {
+	var common reflect.Type
+	consistent := true
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step != stepIn || !consistent {
+			return
+		}
+		keyType := reflect.TypeOf(n.Key)
+		if common == nil {
+			common = keyType
+		} else if keyType != common {
+			consistent = false
+		}
+	})
+	if !consistent {
+		return nil, false
+	}
+	return common, true
+}
+
+// IsBST reports whether an in-order walk yields strictly ascending keys
+// according to the comparator of the tree. Unlike CheckInvariants it
+// ignores colors and parent pointers, so it also suits hand-built trees.
+func (t *Tree) IsBST() bool {
+	ordered := true
+	var prev *Node
+	rangeWalkFrom(t.Root, nil, nil, t.compare, func(n *Node) bool {
+		if prev != nil && t.compare(prev.Key, n.Key) >= 0 {
+			ordered = false
+			return false
+		}
+		prev = n
+		return true
+	})
+	return ordered
+}
+
+// KeyPair names two related nodes by their keys: a parent and its child, or
+// two neighbors in key order.
+type KeyPair struct {
+	First, Second interface{}
+}
+
+// BlackHeightMismatch reports a node whose subtrees hold paths with
+// different numbers of black nodes.
+type BlackHeightMismatch struct {
+	Key         interface{}
+	Left, Right int // black heights of the subtrees, counting nil leaves
+}
+
+// VerifyReport lists every red-black or ordering defect found by Verify.
+type VerifyReport struct {
+	RedRoot       bool
+	RedRed        []KeyPair             // red parent, red child
+	BlackHeights  []BlackHeightMismatch // deepest mismatches first
+	Unordered     []KeyPair             // in-order neighbors not strictly ascending
+	BrokenParents []KeyPair             // parent, child whose parent pointer differs
+}
+
+// OK reports whether no defect was found.
+func (r VerifyReport) OK() bool {
+	return !r.RedRoot && len(r.RedRed) == 0 && len(r.BlackHeights) == 0 &&
+		len(r.Unordered) == 0 && len(r.BrokenParents) == 0
+}
+
+// Verify checks the same properties as CheckInvariants but keeps going
+// after the first defect and reports all of them. A subtree with unequal
+// black heights counts as the larger of the two for the nodes above it.
+// Parent pointers are only checked when nodes are not shared after
+// PutPersistent.
+func (t *Tree) Verify() VerifyReport {
+	var report VerifyReport
+	if t.IsEmpty() {
+		return report
+	}
+	report.RedRoot = t.Root.color == RED
+	if t.Root.parent != nil {
+		report.BrokenParents = append(report.BrokenParents, KeyPair{nil, t.Root.Key})
+	}
+
+	var check func(n *Node) int
+	check = func(n *Node) int {
+		if n == nil {
+			return 1
+		}
+		for _, child := range []*Node{n.Left, n.Right} {
+			if child == nil {
+				continue
+			}
+			if child.parent != n && !t.shared {
+				report.BrokenParents = append(report.BrokenParents, KeyPair{n.Key, child.Key})
+			}
+			if n.color == RED && child.color == RED {
+				report.RedRed = append(report.RedRed, KeyPair{n.Key, child.Key})
+			}
+		}
+		left, right := check(n.Left), check(n.Right)
+		if left != right {
+			report.BlackHeights = append(report.BlackHeights, BlackHeightMismatch{n.Key, left, right})
+		}
+		height := left
+		if right > height {
+			height = right
+		}
+		if n.color == BLACK {
+			height++
+		}
+		return height
+	}
+	check(t.Root)
+
+	var prev *Node
+	rangeWalkFrom(t.Root, nil, nil, t.compare, func(n *Node) bool {
+		if prev != nil && t.compare(prev.Key, n.Key) >= 0 {
+			report.Unordered = append(report.Unordered, KeyPair{prev.Key, n.Key})
+		}
+		prev = n
+		return true
+	})
+	return report
+}
+
+// BlackHeight returns the black height of the node: the number of black
+// nodes on any path from it down to an empty subtree, not counting the node
+// itself but counting the empty subtree, which is black. It fails if paths
+// below the node disagree.
+func (n *Node) BlackHeight() (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+	height := 0
+	for i, child := range []*Node{n.Left, n.Right} {
+		h, err := child.BlackHeight()
+		if err != nil {
+			return 0, err
+		}
+		if child == nil || child.color == BLACK {
+			h++
+		}
+		if i > 0 && h != height {
+			return 0, fmt.Errorf("%w: black heights of %s differ (%d left, %d right)", ErrorInvariantViolated, n, height, h)
+		}
+		height = h
+	}
+	return height, nil
+}
+
+// Paths returns the keys along every path from the root down to a node
+// without children, left to right. On a valid red-black tree each path
+// holds the same number of black nodes, which makes Paths handy for
+// inspecting small trees. An empty tree has no paths.
+func (t *Tree) Paths() [][]interface{} {
+	paths := [][]interface{}{}
+	if t.IsEmpty() {
+		return paths
+	}
+	var walk func(n *Node, prefix []interface{})
+	walk = func(n *Node, prefix []interface{}) {
+		path := append(prefix[:len(prefix):len(prefix)], n.Key)
+		if n.Left == nil && n.Right == nil {
+			paths = append(paths, path)
+			return
+		}
+		for _, child := range []*Node{n.Left, n.Right} {
+			if child != nil {
+				walk(child, path)
+			}
+		}
+	}
+	walk(t.Root, nil)
+	return paths
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyType(t *testing.T) {
+	if typ, ok := NewTree().KeyType(); typ != nil || !ok {
+		t.Errorf("empty tree: KeyType() = %v, %v, want nil, true", typ, ok)
+	}
+	if typ, ok := newIntTree(t, 3, 1, 2).KeyType(); typ != reflect.TypeOf(0) || !ok {
+		t.Errorf("int tree: KeyType() = %v, %v, want int, true", typ, ok)
+	}
+
+	// a comparator lenient enough to accept anything lets mixed keys in
+	mixed := NewTreeWith(func(o1, o2 interface{}) int {
+		return strings.Compare(fmt.Sprint(o1), fmt.Sprint(o2))
+	})
+	for _, k := range []interface{}{1, 2, "3", 4} {
+		if err := mixed.Put(k, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if typ, ok := mixed.KeyType(); typ != nil || ok {
+		t.Errorf("mixed tree: KeyType() = %v, %v, want nil, false", typ, ok)
+	}
+}
+
+func TestIsBST(t *testing.T) {
+	if !NewTree().IsBST() || !newIntTree(t, 5, 3, 8, 1, 4).IsBST() {
+		t.Error("IsBST rejected a tree built with Put")
+	}
+	// 7 sits left of 5; the colors are valid, the order is not
+	misordered := &Tree{cmp: IntComparator, Root: &Node{Key: 5, color: BLACK,
+		Left:  &Node{Key: 3, color: RED, Right: &Node{Key: 7, color: BLACK}},
+		Right: &Node{Key: 8, color: BLACK},
+	}}
+	if misordered.IsBST() {
+		t.Error("IsBST accepted a misordered tree")
+	}
+	duplicated := &Tree{cmp: IntComparator, Root: &Node{Key: 5, color: BLACK,
+		Left: &Node{Key: 5, color: RED},
+	}}
+	if duplicated.IsBST() {
+		t.Error("IsBST accepted a repeated key")
+	}
+}
+
+func TestVerifyPinpointsDefects(t *testing.T) {
+	// 5 is a red root over a red 3, whose right child 7 belongs right of 5
+	// and leaves 3 with unequal black heights; 8 lost its parent pointer
+	seven := &Node{Key: 7, color: BLACK}
+	three := &Node{Key: 3, color: RED, Right: seven}
+	eight := &Node{Key: 8, color: BLACK}
+	root := &Node{Key: 5, color: RED, Left: three, Right: eight}
+	three.parent, seven.parent = root, three
+	tree := &Tree{Root: root, cmp: IntComparator}
+
+	report := tree.Verify()
+	want := VerifyReport{
+		RedRoot:       true,
+		RedRed:        []KeyPair{{5, 3}},
+		BlackHeights:  []BlackHeightMismatch{{Key: 3, Left: 1, Right: 2}},
+		Unordered:     []KeyPair{{7, 5}},
+		BrokenParents: []KeyPair{{5, 8}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Verify() = %+v, want %+v", report, want)
+	}
+	if report.OK() {
+		t.Error("OK() on a broken tree")
+	}
+	if tree.CheckInvariants() == nil {
+		t.Error("CheckInvariants accepted the broken tree")
+	}
+}
+
+func TestVerifySoundTrees(t *testing.T) {
+	for _, tree := range []*Tree{nil, NewTree(), newIntTree(t, 8, 3, 10, 1, 6, 14, 4, 7, 13)} {
+		if report := tree.Verify(); !report.OK() {
+			t.Errorf("Verify() = %+v on a sound tree", report)
+		}
+	}
+}
+
+func TestBlackHeight(t *testing.T) {
+	// 4 (black) over 2 (red) and 6 (black); 2 has black children 1 and 3
+	one := &Node{Key: 1, color: BLACK}
+	three := &Node{Key: 3, color: BLACK}
+	two := &Node{Key: 2, color: RED, Left: one, Right: three}
+	six := &Node{Key: 6, color: BLACK}
+	four := &Node{Key: 4, color: BLACK, Left: two, Right: six}
+
+	var empty *Node
+	for _, c := range []struct {
+		node *Node
+		want int
+	}{{empty, 0}, {one, 1}, {six, 1}, {two, 2}, {four, 2}} {
+		if got, err := c.node.BlackHeight(); err != nil || got != c.want {
+			t.Errorf("%s.BlackHeight() = %d, %v, want %d", c.node, got, err, c.want)
+		}
+	}
+
+	// turning 6 red leaves its side of 4 one black node short
+	six.color = RED
+	if _, err := four.BlackHeight(); !errors.Is(err, ErrorInvariantViolated) {
+		t.Errorf("BlackHeight() of an unbalanced node = %v, want ErrorInvariantViolated", err)
+	}
+	// a mismatch deep down fails every ancestor
+	six.color, three.color = BLACK, RED
+	for _, n := range []*Node{two, four} {
+		if _, err := n.BlackHeight(); !errors.Is(err, ErrorInvariantViolated) {
+			t.Errorf("%s.BlackHeight() = %v, want ErrorInvariantViolated", n, err)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	if paths := NewTree().Paths(); paths == nil || len(paths) != 0 {
+		t.Errorf("Paths of an empty tree = %#v, want an empty slice", paths)
+	}
+
+	paths := exampleRangeTree().Paths()
+	if len(paths) != 12 {
+		t.Fatalf("%d paths in the example tree, want one per leaf: 12", len(paths))
+	}
+	if want := []interface{}{49, 23, 10, 3, 3}; !reflect.DeepEqual(paths[0], want) {
+		t.Errorf("first path = %v, want %v", paths[0], want)
+	}
+	if want := []interface{}{49, 80, 89, 100, 100}; !reflect.DeepEqual(paths[11], want) {
+		t.Errorf("last path = %v, want %v", paths[11], want)
+	}
+
+	tree := NewTree()
+	for _, k := range rand.New(rand.NewSource(1)).Perm(200) {
+		tree.Put(k, nil)
+	}
+	childless := 0
+	traverse(tree.Root, func(step walkStep, n *Node) {
+		if step == stepIn && n.Left == nil && n.Right == nil {
+			childless++
+		}
+	})
+	paths = tree.Paths()
+	if len(paths) != childless {
+		t.Fatalf("%d paths, want %d", len(paths), childless)
+	}
+	for i, path := range paths {
+		if path[0] != tree.Root.Key {
+			t.Fatalf("path %d starts at %v, not at the root", i, path[0])
+		}
+		if i > 0 && path[len(path)-1].(int) <= paths[i-1][len(paths[i-1])-1].(int) {
+			t.Fatalf("paths %d and %d are not left to right", i-1, i)
+		}
+	}
+}
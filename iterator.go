@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+)
+
+var ErrorTreeModified = errors.New("Tree modified during iteration")
+
+// Iterator walks the entries of a Tree in ascending key order.
+// It is positioned before the first entry; call Next to advance.
+//
+//	for it := t.Iterator(); it.Next(); {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//
+// Adding or removing keys (or otherwise reshaping the tree) while an
+// iterator is in use is a bug: the iterator would skip or repeat entries.
+// Next panics with ErrorTreeModified when it detects that. Overwriting the
+// payload of an existing key is fine.
+type Iterator struct {
+	stack []*Node // ancestors still to be visited, nearest last
+	node  *Node   // current node; nil before the first Next
+
+	// iteration stops after `hi` when set
+	hi  interface{}
+	cmp Comparator
+
+	tree *Tree  // nil for iterators over a nil tree
+	mods uint64 // tree.mods when the iterator was created
+}
+
+// Iterator returns an Iterator over all entries of the tree.
+func (t *Tree) Iterator() *Iterator {
+	it := &Iterator{}
+	if t == nil {
+		return it
+	}
+	it.tree, it.mods = t, t.mods
+	it.pushLeft(t.Root)
+	return it
+}
+
+// IteratorFrom returns an Iterator over the entries whose key is >= start.
+func (t *Tree) IteratorFrom(start interface{}) *Iterator {
+	return t.seek(t.normalize(start), true)
+}
+
+// seek positions an iterator before the first key above `start`,
+// or at/above it when `inclusive`.
+func (t *Tree) seek(start interface{}, inclusive bool) *Iterator {
+	it := &Iterator{}
+	if t == nil || mustBeValidKey(start) != nil {
+		return it
+	}
+	it.tree, it.mods = t, t.mods
+	n := t.Root
+	for n != nil {
+		if c := t.compare(n.Key, start); c > 0 || (c == 0 && inclusive) {
+			it.stack = append(it.stack, n)
+			n = n.Left
+		} else {
+			n = n.Right
+		}
+	}
+	return it
+}
+
+func (it *Iterator) pushLeft(n *Node) {
+	for n != nil {
+		it.stack = append(it.stack, n)
+		n = n.Left
+	}
+}
+
+// Next advances to the next entry and reports whether there is one.
+func (it *Iterator) Next() bool {
+	if it.tree != nil && it.tree.mods != it.mods {
+		panic(ErrorTreeModified)
+	}
+	if len(it.stack) == 0 {
+		it.node = nil
+		return false
+	}
+	it.node = it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	if it.hi != nil && it.cmp(it.node.Key, it.hi) > 0 {
+		it.node, it.stack = nil, nil
+		return false
+	}
+	it.pushLeft(it.node.Right)
+	return true
+}
+
+// Key returns the key of the current entry.
+func (it *Iterator) Key() interface{} {
+	if it.node == nil {
+		return nil
+	}
+	return it.node.Key
+}
+
+// Value returns the payload of the current entry.
+func (it *Iterator) Value() interface{} {
+	if it.node == nil {
+		return nil
+	}
+	return it.node.payload
+}
+
+// Min returns the entry with the smallest key, and false if the tree is empty.
+func (t *Tree) Min() (Entry, bool) {
+	if t.IsEmpty() {
+		return Entry{}, false
+	}
+	n := t.getMinimum(t.Root)
+	return Entry{Key: n.Key, Value: n.payload}, true
+}
+
+// Max returns the entry with the largest key, and false if the tree is empty.
+func (t *Tree) Max() (Entry, bool) {
+	if t.IsEmpty() {
+		return Entry{}, false
+	}
+	n := t.Root
+	for n.Right != nil {
+		n = n.Right
+	}
+	return Entry{Key: n.Key, Value: n.payload}, true
+}
+
+// FindFunc returns the entry with the smallest key for which pred returns
+// true, and false if there is none. The walk stops at the first match.
+// pred must not modify the tree.
+func (t *Tree) FindFunc(pred func(key, value interface{}) bool) (Entry, bool) {
+	var found *Node
+	if !t.IsEmpty() {
+		t.rangeWalk(nil, nil, func(n *Node) bool {
+			if pred(n.Key, n.payload) {
+				found = n
+			}
+			return found == nil
+		})
+	}
+	if found == nil {
+		return Entry{}, false
+	}
+	return Entry{Key: found.Key, Value: found.payload}, true
+}
+
+// FindLastFunc is FindFunc walking from the largest key down: it returns
+// the entry with the largest key for which pred returns true.
+func (t *Tree) FindLastFunc(pred func(key, value interface{}) bool) (Entry, bool) {
+	if t.IsEmpty() {
+		return Entry{}, false
+	}
+	// reverse in-order walk from the maximum, as in LastN
+	var stack []*Node
+	node := t.Root
+	for {
+		for node != nil {
+			stack = append(stack, node)
+			node = node.Right
+		}
+		if len(stack) == 0 {
+			return Entry{}, false
+		}
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !t.expired(node) && pred(node.Key, node.payload) {
+			return Entry{Key: node.Key, Value: node.payload}, true
+		}
+		node = node.Left
+	}
+}
+
+// LowerBound returns the entry with the smallest key >= `key`,
+// and false if there is none.
+func (t *Tree) LowerBound(key interface{}) (Entry, bool) {
+	it := t.IteratorFrom(key)
+	if !it.Next() {
+		return Entry{}, false
+	}
+	return Entry{Key: it.Key(), Value: it.Value()}, true
+}
+
+// UpperBound returns the entry with the smallest key > `key`,
+// and false if there is none.
+func (t *Tree) UpperBound(key interface{}) (Entry, bool) {
+	it := t.seek(t.normalize(key), false)
+	if !it.Next() {
+		return Entry{}, false
+	}
+	return Entry{Key: it.Key(), Value: it.Value()}, true
+}
+
+// Ceiling returns the entry with the smallest key >= `key`, and false if
+// there is none. `key` need not be present. Like Floor, Higher and Lower it
+// costs a single descent.
+func (t *Tree) Ceiling(key interface{}) (Entry, bool) {
+	return t.neighbor(key, true, true)
+}
+
+// Floor returns the entry with the largest key <= `key`, and false if
+// there is none.
+func (t *Tree) Floor(key interface{}) (Entry, bool) {
+	return t.neighbor(key, false, true)
+}
+
+// Higher returns the entry with the smallest key strictly above `key`, and
+// false if there is none; e.g. the first entry of the page after `key`.
+func (t *Tree) Higher(key interface{}) (Entry, bool) {
+	return t.neighbor(key, true, false)
+}
+
+// Lower returns the entry with the largest key strictly below `key`, and
+// false if there is none.
+func (t *Tree) Lower(key interface{}) (Entry, bool) {
+	return t.neighbor(key, false, false)
+}
+
+// Neighbors returns the entries right below and right above `key`, as
+// Lower and Higher would, but in a single descent: the last node where the
+// search turned right is the predecessor, the last where it turned left the
+// successor. If `key` is present its own node is skipped; the nearest keys
+// are then the maximum of its left and the minimum of its right subtree.
+func (t *Tree) Neighbors(key interface{}) (prev Entry, prevOK bool, next Entry, nextOK bool) {
+	key = t.normalize(key)
+	if t.IsEmpty() || mustBeValidKey(key) != nil {
+		return
+	}
+	var below, above *Node
+	for n := t.Root; n != nil; {
+		switch c := t.compare(n.Key, key); {
+		case c < 0:
+			below, n = n, n.Right
+		case c > 0:
+			above, n = n, n.Left
+		default:
+			if n.Left != nil {
+				for below = n.Left; below.Right != nil; below = below.Right {
+				}
+			}
+			if n.Right != nil {
+				above = t.getMinimum(n.Right)
+			}
+			n = nil
+		}
+	}
+	if below != nil {
+		prev, prevOK = Entry{Key: below.Key, Value: below.payload}, true
+	}
+	if above != nil {
+		next, nextOK = Entry{Key: above.Key, Value: above.payload}, true
+	}
+	return
+}
+
+// neighbor looks up the nearest key above (`up`) or below `key`.
+func (t *Tree) neighbor(key interface{}, up, inclusive bool) (Entry, bool) {
+	key = t.normalize(key)
+	if t.IsEmpty() || mustBeValidKey(key) != nil {
+		return Entry{}, false
+	}
+	var n *Node
+	if up {
+		n = t.ceilingNode(key, inclusive)
+	} else {
+		n = t.floorNode(key, inclusive)
+	}
+	if n == nil {
+		return Entry{}, false
+	}
+	return Entry{Key: n.Key, Value: n.payload}, true
+}
+
+// MinInRange returns the entry with the smallest key within [lo, hi], and
+// false if there is none or the bounds are invalid (see CountInRange).
+// A nil bound leaves that side open. It costs one descent, O(log n).
+func (t *Tree) MinInRange(lo, hi interface{}) (Entry, bool) {
+	lo, hi, err := t.rangeBounds("MinInRange", lo, hi)
+	if err != nil || t.IsEmpty() {
+		return Entry{}, false
+	}
+	n := t.ceilingNode(lo, true)
+	if n == nil || (hi != nil && t.compare(n.Key, hi) > 0) {
+		return Entry{}, false
+	}
+	return Entry{Key: n.Key, Value: n.payload}, true
+}
+
+// MaxInRange is MinInRange for the entry with the largest key.
+func (t *Tree) MaxInRange(lo, hi interface{}) (Entry, bool) {
+	lo, hi, err := t.rangeBounds("MaxInRange", lo, hi)
+	if err != nil || t.IsEmpty() {
+		return Entry{}, false
+	}
+	n := t.floorNode(hi, true)
+	if n == nil || (lo != nil && t.compare(n.Key, lo) < 0) {
+		return Entry{}, false
+	}
+	return Entry{Key: n.Key, Value: n.payload}, true
+}
+
+// ceilingNode returns the node with the smallest key above `key`, or at
+// it when `inclusive`; a nil key selects the minimum.
+func (t *Tree) ceilingNode(key interface{}, inclusive bool) *Node {
+	var best *Node
+	for n := t.Root; n != nil; {
+		c := 1 // every key is above an open bound
+		if key != nil {
+			c = t.compare(n.Key, key)
+		}
+		if c > 0 || (c == 0 && inclusive) {
+			best, n = n, n.Left
+		} else {
+			n = n.Right
+		}
+	}
+	return best
+}
+
+// floorNode returns the node with the largest key below `key`, or at it
+// when `inclusive`; a nil key selects the maximum.
+func (t *Tree) floorNode(key interface{}, inclusive bool) *Node {
+	var best *Node
+	for n := t.Root; n != nil; {
+		c := -1
+		if key != nil {
+			c = t.compare(n.Key, key)
+		}
+		if c < 0 || (c == 0 && inclusive) {
+			best, n = n, n.Right
+		} else {
+			n = n.Left
+		}
+	}
+	return best
+}
+
+// FirstN returns the n entries with the smallest keys in ascending order,
+// or all of them if the tree holds fewer.
+func (t *Tree) FirstN(n int) []Entry {
+	entries := []Entry{}
+	if n <= 0 {
+		return entries
+	}
+	t.rangeWalk(nil, nil, func(node *Node) bool {
+		entries = append(entries, Entry{Key: node.Key, Value: node.payload})
+		return len(entries) < n
+	})
+	return entries
+}
+
+// LastN returns the n entries with the largest keys in ascending order,
+// or all of them if the tree holds fewer.
+func (t *Tree) LastN(n int) []Entry {
+	entries := []Entry{}
+	if n <= 0 {
+		return entries
+	}
+	// reverse in-order walk from the maximum
+	var stack []*Node
+	node := t.Root
+	for len(entries) < n {
+		for node != nil {
+			stack = append(stack, node)
+			node = node.Right
+		}
+		if len(stack) == 0 {
+			break
+		}
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !t.expired(node) {
+			entries = append(entries, Entry{Key: node.Key, Value: node.payload})
+		}
+		node = node.Left
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// Between returns the entries whose key lies strictly between
+// `startExclusive` and `endExclusive`, in ascending order. Neither bound
+// needs to be present in the tree. Unlike RangeSearch both bounds are
+// excluded, which suits queries like "rows after X and before Y".
+func (t *Tree) Between(startExclusive, endExclusive interface{}) []Entry {
+	entries := []Entry{}
+	end := t.normalize(endExclusive)
+	if mustBeValidKey(end) != nil {
+		return entries
+	}
+	// like UpperBound, start right after `startExclusive`
+	for it := t.seek(t.normalize(startExclusive), false); it.Next(); {
+		if t.compare(it.Key(), end) >= 0 {
+			break
+		}
+		if last := len(entries) - 1; last >= 0 && t.compare(entries[last].Key, it.Key()) == 0 {
+			continue
+		}
+		entries = append(entries, Entry{Key: it.Key(), Value: it.Value()})
+	}
+	return entries
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Operations recorded in a journal.
+const (
+	journalPut byte = iota + 1
+	journalDelete
+)
+
+var ErrorJournalCorrupted = errors.New("Journal record is corrupted")
+
+type journalEntry struct {
+	Op    byte
+	Key   interface{}
+	Value []byte
+}
+
+// Every record is framed as a big-endian uint32 length and the CRC-32 of the
+// body, followed by the gob-encoded body.
+const journalHeaderSize = 8
+
+// Upper bound on the body of a single record; larger lengths can only come
+// from a damaged header.
+const journalMaxRecordSize = 64 << 20
+
+// journalRecord appends one operation to `t.Journal`, if set.
+func (t *Tree) journalRecord(op byte, key, payload interface{}) error {
+	if t.Journal == nil {
+		return nil
+	}
+	entry := journalEntry{Op: op, Key: key}
+	if op == journalPut {
+		value, err := t.payloadCodec().Encode(payload)
+		if err != nil {
+			return fmt.Errorf("journal: encoding payload of key %#v: %w", key, err)
+		}
+		entry.Value = value
+	}
+
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(&entry); err != nil {
+		return fmt.Errorf("journal: encoding key %#v: %w", key, err)
+	}
+	record := make([]byte, journalHeaderSize, journalHeaderSize+body.Len())
+	binary.BigEndian.PutUint32(record[0:4], uint32(body.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body.Bytes()))
+	record = append(record, body.Bytes()...)
+	if _, err := t.Journal.Write(record); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+// ReplayJournal re-applies the operations read from `r` onto `t`, in order.
+// Payloads are decoded with the codec of `t`. A torn final record (cut short,
+// or failing its checksum with nothing after it) is the expected outcome of
+// a crash mid-write and is skipped; a damaged record followed by more data
+// yields ErrorJournalCorrupted. Replayed operations are not written to the
+// journal of `t`.
+func ReplayJournal(t *Tree, r io.Reader) error {
+	journal := t.Journal
+	t.Journal = nil
+	defer func() { t.Journal = journal }()
+
+	br := bufio.NewReader(r)
+	header := make([]byte, journalHeaderSize)
+	for index := 0; ; index++ {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > journalMaxRecordSize {
+			return fmt.Errorf("%w: record %d claims %d bytes", ErrorJournalCorrupted, index, size)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(br, body); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+			if _, err := br.Peek(1); err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: record %d fails its checksum", ErrorJournalCorrupted, index)
+		}
+
+		var entry journalEntry
+		if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&entry); err != nil {
+			return fmt.Errorf("%w: record %d: %s", ErrorJournalCorrupted, index, err)
+		}
+		switch entry.Op {
+		case journalPut:
+			payload, err := t.payloadCodec().Decode(entry.Value)
+			if err != nil {
+				return fmt.Errorf("journal: decoding payload of key %#v: %w", entry.Key, err)
+			}
+			if err := t.Put(entry.Key, payload); err != nil {
+				return err
+			}
+		case journalDelete:
+			if err := t.Delete(entry.Key); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: record %d has unknown operation %d", ErrorJournalCorrupted, index, entry.Op)
+		}
+	}
+}
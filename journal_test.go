@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// failingWriter accepts `budget` writes and fails every one after.
+type failingWriter struct {
+	budget int
+	bytes.Buffer
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.budget <= 0 {
+		return 0, errWriteFailed
+	}
+	w.budget--
+	return w.Buffer.Write(p)
+}
+
+// journaledTree returns a string-keyed tree journaling to the returned
+// buffer, after a snapshot of its initial contents was taken.
+func journaledTree(t *testing.T) (*Tree, *bytes.Buffer, []byte) {
+	t.Helper()
+	tree := NewTreeWith(StringComparator)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		tree.Put(k, k+k)
+	}
+	var snapshot bytes.Buffer
+	if err := tree.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	journal := &bytes.Buffer{}
+	tree.Journal = journal
+	tree.Put("e", "ee")
+	tree.Delete("b")
+	tree.SetValue("c", "C")
+	tree.Put("a", "A")
+	return tree, journal, snapshot.Bytes()
+}
+
+func TestReplayJournalOntoSnapshot(t *testing.T) {
+	live, journal, snapshot := journaledTree(t)
+	restored, err := ReadSnapshot(bytes.NewReader(snapshot), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplayJournal(restored, journal); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, restored)
+	if got, want := restored.Items(), live.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayJournalTornTail(t *testing.T) {
+	live, journal, snapshot := journaledTree(t)
+	torn := journal.Bytes()[:journal.Len()-3]
+	restored, _ := ReadSnapshot(bytes.NewReader(snapshot), nil)
+	if err := ReplayJournal(restored, bytes.NewReader(torn)); err != nil {
+		t.Fatalf("torn final record: %s", err)
+	}
+	// all but the last Put of "a" made it
+	live.Put("a", "aa")
+	if got, want := restored.Items(), live.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayJournalCorruptedMiddle(t *testing.T) {
+	_, journal, snapshot := journaledTree(t)
+	damaged := append([]byte(nil), journal.Bytes()...)
+	damaged[journalHeaderSize+2] ^= 0xff // inside the first record
+	restored, _ := ReadSnapshot(bytes.NewReader(snapshot), nil)
+	if err := ReplayJournal(restored, bytes.NewReader(damaged)); !errors.Is(err, ErrorJournalCorrupted) {
+		t.Fatalf("got %v, want ErrorJournalCorrupted", err)
+	}
+}
+
+func TestJournalFailureRollsBack(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	tree.Journal = &failingWriter{}
+	before := tree.Items()
+
+	if err := tree.Put(4, 4); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Put: got %v, want the journal's error", err)
+	}
+	if err := tree.Put(2, "two"); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Put: got %v, want the journal's error", err)
+	}
+	if tree.SetValue(3, "three") {
+		t.Fatal("SetValue reported success")
+	}
+	tree.Delete(1)
+	if err := tree.Insert(NewNode(5, 5)); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Insert: got %v, want the journal's error", err)
+	}
+
+	checkRedBlack(t, tree)
+	if got := tree.Items(); !reflect.DeepEqual(got, before) {
+		t.Fatalf("tree holds %v after failed journal writes, want %v", got, before)
+	}
+}
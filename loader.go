@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Loader fetches the value of a key missing from the tree from a backing
+// store; see Options.Loader. It returns false if the store authoritatively
+// has no such key.
+type Loader func(key interface{}) (value interface{}, found bool, err error)
+
+// How many tombstones accumulate before expired ones are swept.
+const tombstoneSweepInterval = 1024
+
+// GetOrLoad is Get reporting loader errors. With Options.Loader set, a key
+// missing from the tree (and not marked absent by a tombstone, see
+// Options.AbsentTTL) is handed to the loader; a found value is saved with
+// Put and returned, so the tree acts as a read-through cache. A loader
+// error is returned as is and nothing is saved. A tree that can't be
+// written, such as a frozen one, still returns the loaded value.
+func (t *Tree) GetOrLoad(key interface{}) (bool, interface{}, error) {
+	found, payload := t.lookup(key)
+	if found || !t.loads(key) {
+		return found, payload, nil
+	}
+	key = t.normalize(key)
+	payload, found, err := t.opts.Loader(key)
+	if err != nil {
+		return false, nil, err
+	}
+	t.storeLoaded(key, payload, found)
+	return found, payload, nil
+}
+
+// loads reports whether a miss of `key` should go to the loader.
+func (t *Tree) loads(key interface{}) bool {
+	if t == nil || t.opts.Loader == nil || mustBeValidKey(key) != nil {
+		return false
+	}
+	return t.absent == nil || !t.absent.Has(t.normalize(key))
+}
+
+// storeLoaded saves the answer of the loader for `key`: its value, or a
+// tombstone if it found nothing and tombstones are enabled.
+func (t *Tree) storeLoaded(key, payload interface{}, found bool) {
+	if found {
+		if err := t.save(key, payload); err != nil {
+			t.tracef(LevelError, "Loader: caching %v failed: %s\n", key, err.Error())
+		}
+		return
+	}
+	if t.opts.AbsentTTL <= 0 || t.IsFrozen() {
+		return
+	}
+	if t.absent == nil {
+		t.absent = NewTreeWithOptions(t.Comparator(), Options{Now: t.opts.Now})
+	}
+	t.absent.PutTTL(key, nil, t.opts.AbsentTTL)
+	if t.absent.Size()%tombstoneSweepInterval == 0 {
+		t.absent.Sweep(t.now())
+	}
+}
+
+// loadCall is a loader call in flight that concurrent misses of the same
+// key wait for.
+type loadCall struct {
+	done    sync.WaitGroup
+	found   bool
+	payload interface{}
+	err     error
+}
+
+// NewConcurrentTreeWithOptions returns an empty ConcurrentTree ordered by
+// `c` and tuned by `opts`. With Options.Loader set, concurrent misses of
+// the same key share a single loader call, made without holding the lock.
+func NewConcurrentTreeWithOptions(c Comparator, opts Options) *ConcurrentTree {
+	return &ConcurrentTree{tree: NewTreeWithOptions(c, opts)}
+}
+
+// GetOrLoad is Tree.GetOrLoad, coalescing concurrent loads of equal keys
+// (keys of a type Go can't compare are loaded independently).
+func (ct *ConcurrentTree) GetOrLoad(key interface{}) (bool, interface{}, error) {
+	ct.lock.RLock()
+	found, payload := ct.tree.lookup(key)
+	loads := !found && ct.tree.loads(key)
+	key = ct.tree.normalize(key)
+	ct.lock.RUnlock()
+	if !loads {
+		return found, payload, nil
+	}
+
+	coalesce := reflect.TypeOf(key).Comparable()
+	if coalesce {
+		ct.loadLock.Lock()
+		if call, ok := ct.loading[key]; ok {
+			ct.loadLock.Unlock()
+			call.done.Wait()
+			return call.found, call.payload, call.err
+		}
+		call := &loadCall{}
+		call.done.Add(1)
+		if ct.loading == nil {
+			ct.loading = map[interface{}]*loadCall{}
+		}
+		ct.loading[key] = call
+		ct.loadLock.Unlock()
+		defer func() {
+			ct.loadLock.Lock()
+			delete(ct.loading, key)
+			ct.loadLock.Unlock()
+			call.done.Done()
+		}()
+		found, payload, err := ct.load(key)
+		call.found, call.payload, call.err = found, payload, err
+		return found, payload, err
+	}
+	return ct.load(key)
+}
+
+// load calls the loader without holding the lock and stores its answer.
+func (ct *ConcurrentTree) load(key interface{}) (bool, interface{}, error) {
+	payload, found, err := ct.tree.opts.Loader(key)
+	if err != nil {
+		return false, nil, err
+	}
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	ct.tree.storeLoaded(key, payload, found)
+	return found, payload, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backingStore is a Loader over a map, counting its calls.
+type backingStore struct {
+	data  map[interface{}]interface{}
+	err   error
+	calls int64
+}
+
+func (s *backingStore) load(key interface{}) (interface{}, bool, error) {
+	atomic.AddInt64(&s.calls, 1)
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	v, ok := s.data[key]
+	return v, ok, nil
+}
+
+func TestLoaderSuccess(t *testing.T) {
+	store := &backingStore{data: map[interface{}]interface{}{1: "one", 2: "two"}}
+	tree := NewTreeWithOptions(IntComparator, Options{Loader: store.load})
+	if found, v := tree.Get(1); !found || v != "one" {
+		t.Fatalf("Get(1) = %v, %v, want the loaded value", found, v)
+	}
+	if !tree.Has(1) || store.calls != 1 {
+		t.Fatalf("loaded value not saved: Has(1) = %v after %d loads", tree.Has(1), store.calls)
+	}
+	tree.Get(1)
+	if store.calls != 1 {
+		t.Errorf("a hit went to the loader: %d loads", store.calls)
+	}
+
+	// a frozen tree returns the loaded value without saving it
+	tree.Freeze()
+	if found, v, err := tree.GetOrLoad(2); !found || v != "two" || err != nil {
+		t.Errorf("GetOrLoad(2) on a frozen tree = %v, %v, %v", found, v, err)
+	}
+	if tree.Has(2) {
+		t.Error("a frozen tree saved a loaded value")
+	}
+}
+
+func TestLoaderAuthoritativeMiss(t *testing.T) {
+	store := &backingStore{data: map[interface{}]interface{}{}}
+	tree := NewTreeWithOptions(IntComparator, Options{Loader: store.load})
+	for i := 0; i < 3; i++ {
+		if found, v := tree.Get(7); found || v != nil {
+			t.Fatalf("Get(7) = %v, %v, want a miss", found, v)
+		}
+	}
+	if store.calls != 3 || tree.Size() != 0 {
+		t.Errorf("without tombstones: %d loads, Size() = %d, want 3 and 0", store.calls, tree.Size())
+	}
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store.calls = 0
+	tree = NewTreeWithOptions(IntComparator, Options{Loader: store.load, AbsentTTL: time.Minute, Now: clock.Now})
+	tree.Get(7)
+	tree.Get(7)
+	if store.calls != 1 {
+		t.Errorf("with a tombstone: %d loads, want 1", store.calls)
+	}
+	if tree.Size() != 0 {
+		t.Errorf("the tombstone counts as an entry: Size() = %d", tree.Size())
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	store.data[7] = "seven"
+	if found, v := tree.Get(7); !found || v != "seven" || store.calls != 2 {
+		t.Errorf("after the tombstone expired: Get(7) = %v, %v after %d loads", found, v, store.calls)
+	}
+}
+
+func TestLoaderError(t *testing.T) {
+	errStore := errors.New("store unavailable")
+	store := &backingStore{err: errStore}
+	tree := NewTreeWithOptions(IntComparator, Options{Loader: store.load, AbsentTTL: time.Minute})
+	if found, v, err := tree.GetOrLoad(1); found || v != nil || err != errStore {
+		t.Fatalf("GetOrLoad(1) = %v, %v, %v, want the loader error", found, v, err)
+	}
+	if found, _ := tree.Get(1); found {
+		t.Error("Get found a key the loader failed on")
+	}
+	if store.calls != 2 || tree.Size() != 0 {
+		t.Errorf("an error was cached: %d loads, Size() = %d", store.calls, tree.Size())
+	}
+
+	if err := tree.ReplaceKey(1, 2); !errors.Is(err, ErrorKeyNotFound) || store.calls != 2 {
+		t.Errorf("ReplaceKey of a missing key = %v after %d loads, want ErrorKeyNotFound without loading", err, store.calls)
+	}
+}
+
+func TestConcurrentLoadsCoalesce(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	loader := func(key interface{}) (interface{}, bool, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return key.(int) * 10, true, nil
+	}
+	tree := NewConcurrentTreeWithOptions(IntComparator, Options{Loader: loader})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if found, v := tree.Get(4); !found || v != 40 {
+				t.Errorf("Get(4) = %v, %v", found, v)
+			}
+		}()
+	}
+	// let the goroutines pile up on the first call before it returns
+	for atomic.LoadInt64(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("16 concurrent misses made %d loader calls, want 1", calls)
+	}
+	if found, _ := tree.Get(5); !found || calls != 2 {
+		t.Errorf("a miss of another key: found %v after %d calls", found, calls)
+	}
+}
@@ -10,8 +10,9 @@ import (
 	"log"
 	"os"
 	"reflect"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Color of a redblack tree node is either
@@ -30,6 +31,14 @@ func (c Color) String() string {
 	}
 }
 
+// Short returns "B" for black and "R" for red.
+func (c Color) Short() string {
+	if c == BLACK {
+		return "B"
+	}
+	return "R"
+}
+
 func (d Direction) String() string {
 	switch d {
 	case LEFT:
@@ -62,16 +71,58 @@ type Node struct {
 	Right   *Node `json:"rightNode"`
 	Leaf    bool  `json:"isLeaf"`
 	parent  *Node
+	shared  bool      // root of a subtree shared by versions; see PutPersistent
+	expires time.Time // zero unless set by PutTTL
+	weight  float64   // total weight of the subtree; see EnableWeights
+	version uint64    // version of the tree that last saved it; see ChangesSince
 }
 
 func (n *Node) String() string {
 	return fmt.Sprintf("(%#v : %s)", n.Key, n.Color())
 }
 
+// Parent returns the parent of the node, or nil for the root. It is nil
+// as well for the root of a subtree that PutPersistent shares between two
+// versions of a tree, which has a different parent in each; so are the
+// Sibling, GrandParent and Uncle derived from it.
 func (n *Node) Parent() *Node {
+	if n.shared {
+		return nil
+	}
 	return n.parent
 }
 
+// Sibling returns the other child of the node's parent, or nil if the node
+// is the root or an only child.
+func (n *Node) Sibling() *Node {
+	parent := n.Parent()
+	switch {
+	case parent == nil:
+		return nil
+	case n == parent.Left:
+		return parent.Right
+	default:
+		return parent.Left
+	}
+}
+
+// GrandParent returns the parent of the node's parent, or nil if there is
+// none.
+func (n *Node) GrandParent() *Node {
+	if parent := n.Parent(); parent != nil {
+		return parent.Parent()
+	}
+	return nil
+}
+
+// Uncle returns the sibling of the node's parent, or nil if there is none.
+func (n *Node) Uncle() *Node {
+	if parent := n.Parent(); parent != nil {
+		return parent.Sibling()
+	}
+	return nil
+}
+
 func (n *Node) SetColor(color Color) {
 	n.color = color
 }
@@ -80,6 +131,23 @@ func (n *Node) Color() Color {
 	return n.color
 }
 
+// NewNode returns a detached red node carrying `key` and `payload`,
+// ready to be spliced into a tree with `Tree.Insert`.
+func NewNode(key, payload interface{}) *Node {
+	return &Node{Key: key, payload: payload}
+}
+
+// Value returns the payload mapped to the node's key.
+func (n *Node) Value() interface{} {
+	return n.payload
+}
+
+// SetValue replaces the payload of the node. The key is left untouched,
+// so the tree does not need rebalancing.
+func (n *Node) SetValue(payload interface{}) {
+	n.payload = payload
+}
+
 type Visitor interface {
 	Visit(*Node)
 }
@@ -116,10 +184,99 @@ func StringComparator(o1, o2 interface{}) int {
 	return bytes.Compare([]byte(s1), []byte(s2))
 }
 
+// TimeComparator provides a basic comparison on time.Time keys, by instant:
+// times in different locations that denote the same instant are equal.
+func TimeComparator(o1, o2 interface{}) int {
+	t1 := o1.(time.Time)
+	t2 := o2.(time.Time)
+	switch {
+	case t1.After(t2):
+		return 1
+	case t1.Before(t2):
+		return -1
+	default:
+		return 0
+	}
+}
+
+// CompositeComparator orders keys lexicographically by the supplied
+// comparators: each one is consulted in turn and the first non-zero
+// result wins. Every comparator receives the whole key, so a struct key
+// can be ordered by field A with one and then by field B with the next.
+func CompositeComparator(cs ...Comparator) Comparator {
+	return func(o1, o2 interface{}) int {
+		for _, c := range cs {
+			if r := c(o1, o2); r != 0 {
+				return r
+			}
+		}
+		return 0
+	}
+}
+
+// CountingComparator wraps `c` so that every comparison atomically adds
+// one to *counter, which makes the cost of operations measurable. Like any
+// closure it is a comparator of its own: register it with
+// RegisterComparator and create the tree with NewTreeByName before
+// serializing a tree ordered by it.
+func CountingComparator(c Comparator, counter *int64) Comparator {
+	return func(o1, o2 interface{}) int {
+		atomic.AddInt64(counter, 1)
+		return c(o1, o2)
+	}
+}
+
 // Tree encapsulates the data structure.
+// The zero value is an empty tree ordered by IntComparator, and a nil
+// *Tree reads as an empty tree: lookups find nothing and Put returns
+// ErrorTreeIsNil.
 type Tree struct {
-	Root *Node      `json:"root"` // tip of the tree
-	cmp  Comparator // required function to order keys
+	Root    *Node        `json:"root"` // tip of the tree
+	cmp     Comparator   // required function to order keys
+	cmpName string       // registered name of cmp, if known; see NewTreeByName
+	codec   PayloadCodec // serializes payloads; nil means JSONCodec
+
+	// KeyNormalizer, if set, canonicalizes every key handed to the tree
+	// (e.g. trimming and lowercasing strings) before it is looked up or
+	// stored. It must be idempotent: normalize(normalize(k)) == normalize(k).
+	KeyNormalizer func(interface{}) interface{}
+
+	// Journal, if set, receives a framed record of every successful
+	// mutation so that it can be re-applied with ReplayJournal.
+	Journal io.Writer
+
+	history      []undoRecord // inverse operations, most recent last
+	historyDepth int          // max len(history); 0 disables recording
+
+	pool  *sync.Pool // recycles deleted nodes; nil allocates every node
+	arena *nodeArena // allocates nodes from slabs; see NewTreeWithArena
+
+	size      uint64 // number of nodes, valid if sizeKnown
+	sizeKnown bool   // false until counted; reset when Root is replaced
+
+	opts Options
+
+	shared bool // nodes may be shared with another Tree; see PutPersistent
+
+	weightOf func(payload interface{}) float64 // nil unless EnableWeights
+
+	ops       []traceOp // public mutations in progress, outermost first
+	fixupStep int       // iteration of the running fixup loop; 0 outside
+
+	traceLevel TraceLevel
+
+	frozen bool // see Freeze
+
+	mods uint64 // count of structural changes; see Iterator
+
+	negative *bloomFilter // nil until built; see Options.NegativeFilter
+
+	absent *Tree // tombstones of keys the loader didn't find; see Options.AbsentTTL
+
+	version uint64  // see Version
+	floor   uint64  // oldest version ChangesSince can answer from
+	graves  *Tree   // version of every deleted key; see Options.TombstoneHorizon
+	buried  []grave // the graves in the order they were dug
 }
 
 // `lock` protects `logger`
@@ -148,27 +305,104 @@ func SetOutput(w io.Writer) {
 	logger = log.New(w, "", log.LstdFlags)
 }
 
+func (t *Tree) normalize(key interface{}) interface{} {
+	if t == nil || t.KeyNormalizer == nil || key == nil {
+		return key
+	}
+	return t.KeyNormalizer(key)
+}
+
+// Comparator returns the comparator ordering the keys, so that code
+// outside the tree can order keys the same way, e.g. to merge results.
+// A zero or nil Tree has none and orders its keys with IntComparator, like
+// NewTree.
+func (t *Tree) Comparator() Comparator {
+	if t == nil || t.cmp == nil {
+		return IntComparator
+	}
+	return t.cmp
+}
+
+func (t *Tree) compare(o1, o2 interface{}) int {
+	return t.Comparator()(o1, o2)
+}
+
 // NewTree returns an empty Tree with default comparator `IntComparator`.
 // `IntComparator` expects keys to be type-assertable to `int`.
 func NewTree() *Tree {
-	return &Tree{Root: nil, cmp: IntComparator}
+	return &Tree{Root: nil, cmp: IntComparator, sizeKnown: true}
 }
 
 // NewTreeWith returns an empty Tree with a supplied `Comparator`.
+// A nil comparator falls back to `IntComparator` with a logged warning;
+// use NewTreeWithChecked to reject it instead.
 func NewTreeWith(c Comparator) *Tree {
-	return &Tree{Root: nil, cmp: c}
+	return NewTreeWithOptions(c, Options{})
+}
+
+// NewTreeWithChecked is NewTreeWith failing with ErrorComparatorIsNil if
+// `c` is nil.
+func NewTreeWithChecked(c Comparator) (*Tree, error) {
+	if c == nil {
+		return nil, ErrorComparatorIsNil
+	}
+	return NewTreeWith(c), nil
+}
+
+// NewTreeWithOptions returns an empty Tree with a supplied `Comparator`
+// and behavior tuned by `opts`. A nil comparator is handled as by
+// NewTreeWith.
+func NewTreeWithOptions(c Comparator, opts Options) *Tree {
+	t := &Tree{Root: nil, cmp: c, opts: opts, sizeKnown: true}
+	if c == nil {
+		t.tracef(LevelError, "Warning: no comparator supplied, falling back to IntComparator\n")
+		t.cmp = IntComparator
+	}
+	return t
+}
+
+// NewTreeByName returns an empty Tree ordered by the comparator registered
+// under `name` and tuned by `opts`. The tree records the name, so the
+// serializers write it even when other registered comparators share the
+// code of its comparator, such as two CompositeComparators.
+func NewTreeByName(name string, opts Options) (*Tree, error) {
+	c, err := comparatorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTreeWithOptions(c, opts)
+	t.cmpName = name
+	return t, nil
 }
 
 // Get looks for the node with supplied key and returns its mapped payload.
 // Return value in 1st position indicates whether any payload was found.
+// With Options.Loader set, a miss is loaded; see GetOrLoad, which also
+// reports loader errors.
 func (t *Tree) Get(key interface{}) (bool, interface{}) {
+	found, payload, err := t.GetOrLoad(key)
+	if err != nil {
+		t.tracef(LevelError, "Get: %s\n", err.Error())
+	}
+	return found, payload
+}
+
+// lookup is Get without loading.
+func (t *Tree) lookup(key interface{}) (bool, interface{}) {
+	if t == nil {
+		return false, nil
+	}
+	key = t.normalize(key)
 	if err := mustBeValidKey(key); err != nil {
-		logger.Printf("Get was prematurely aborted: %s\n", err.Error())
+		t.tracef(LevelError, "Get was prematurely aborted: %s\n", err.Error())
 		return false, nil
 	}
 
+	if t.definitelyAbsent(key) {
+		return false, nil
+	}
 	ok, node := t.getNode(key)
-	if ok {
+	if ok && !t.expired(node) {
 		return true, node.payload
 	} else {
 		return false, nil
@@ -211,8 +445,9 @@ func (t *Tree) getMinimum(x *Node) *Node {
 
 // GetParent looks for the node with supplied key and returns the parent node.
 func (t *Tree) GetParent(key interface{}) (found bool, parent *Node, dir Direction) {
+	key = t.normalize(key)
 	if err := mustBeValidKey(key); err != nil {
-		logger.Printf("GetParent was prematurely aborted: %s\n", err.Error())
+		t.tracef(LevelError, "GetParent was prematurely aborted: %s\n", err.Error())
 		return false, nil, NODIR
 	}
 
@@ -224,31 +459,53 @@ func (t *Tree) GetParent(key interface{}) (found bool, parent *Node, dir Directi
 }
 
 func (t *Tree) internalLookup(parent *Node, this *Node, key interface{}, dir Direction) (bool, *Node, Direction) {
-	switch {
-	case this == nil:
-		return false, parent, dir
-	case t.cmp(key, this.Key) == 0:
-		return true, parent, dir
-	case t.cmp(key, this.Key) < 0:
-		return t.internalLookup(this, this.Left, key, LEFT)
-	case t.cmp(key, this.Key) > 0:
-		return t.internalLookup(this, this.Right, key, RIGHT)
-	default:
-		return false, parent, NODIR
+	return t.descend(parent, this, key, dir, nil)
+}
+
+// descend is the single search loop behind every lookup. It compares the
+// key once per level and, if `trace` is set, reports each node it passes
+// with the comparator result and the direction taken (NODIR on a match).
+func (t *Tree) descend(parent *Node, this *Node, key interface{}, dir Direction, trace func(*Node, int, Direction)) (bool, *Node, Direction) {
+	for this != nil {
+		c := t.compare(key, this.Key)
+		next, child := NODIR, (*Node)(nil)
+		switch {
+		case c < 0:
+			next, child = LEFT, this.Left
+		case c > 0:
+			next, child = RIGHT, this.Right
+		}
+		if trace != nil {
+			trace(this, c, next)
+		}
+		if next == NODIR {
+			return true, parent, dir
+		}
+		parent, this, dir = this, child, next
 	}
+	return false, parent, dir
+}
+
+// rotationNoop handles a rotation that can't be done: it panics with
+// ErrorInvalidRotation in strict mode and is a traced noop otherwise.
+func (t *Tree) rotationNoop(reason string) {
+	if t.opts.Strict {
+		panic(fmt.Errorf("%w: %s", ErrorInvalidRotation, reason))
+	}
+	t.tracef(LevelError, "%s. Noop\n", reason)
 }
 
 // Reverses actions of RotateLeft
 func (t *Tree) RotateRight(y *Node) {
 	if y == nil {
-		logger.Printf("RotateRight: nil arg cannot be rotated. Noop\n")
+		t.rotationNoop("RotateRight: nil arg cannot be rotated")
 		return
 	}
 	if y.Left == nil {
-		logger.Printf("RotateRight: y has nil left subtree. Noop\n")
+		t.rotationNoop("RotateRight: y has nil left subtree")
 		return
 	}
-	logger.Printf("\t\t\trotate right of %s\n", y)
+	t.tracef(LevelOp, "rotate right of %s\n", y)
 	x := y.Left
 	y.Left = x.Right
 	if x.Right != nil {
@@ -266,19 +523,21 @@ func (t *Tree) RotateRight(y *Node) {
 	}
 	x.Right = y
 	y.parent = x
+	t.reweigh(y)
+	t.reweigh(x)
 }
 
 // Side-effect: red-black tree properties is maintained.
 func (t *Tree) RotateLeft(x *Node) {
 	if x == nil {
-		logger.Printf("RotateLeft: nil arg cannot be rotated. Noop\n")
+		t.rotationNoop("RotateLeft: nil arg cannot be rotated")
 		return
 	}
 	if x.Right == nil {
-		logger.Printf("RotateLeft: x has nil right subtree. Noop\n")
+		t.rotationNoop("RotateLeft: x has nil right subtree")
 		return
 	}
-	logger.Printf("\t\t\trotate left of %s\n", x)
+	t.tracef(LevelOp, "rotate left of %s\n", x)
 
 	y := x.Right
 	x.Right = y.Left
@@ -297,54 +556,205 @@ func (t *Tree) RotateLeft(x *Node) {
 	}
 	y.Left = x
 	x.parent = y
+	t.reweigh(x)
+	t.reweigh(y)
 }
 
 // Put saves the mapping (key, data) into the tree.
 // If a mapping identified by `key` already exists, it is overwritten.
 // Constraint: Not everything can be a key.
+// With Options.Writer set, the mapping is handed to it first; see Writer.
 func (t *Tree) Put(key interface{}, data interface{}) error {
+	inverse, err := t.writeThrough("Put", OpPut, key, data)
+	if err != nil {
+		return err
+	}
+	err = t.save(key, data)
+	if err != nil && inverse != nil {
+		// the store took the mutation the tree failed to apply
+		err = t.opts.Writer.undo(inverse, err)
+	}
+	return err
+}
+
+// save is Put without the write-through.
+func (t *Tree) save(key interface{}, data interface{}) error {
+	if err := t.mutable("Put"); err != nil {
+		return err
+	}
+	defer t.beginOp("put")()
+	key = t.normalize(key)
+	inverse := t.inverseOf(key)
+	evicted := t.evictFor(key)
+	size := t.Size()
+	err := t.put(key, data)
+	if err == nil && t.Size() > size {
+		err = t.guardHeight(key) // takes the key out again if it fails
+	}
+	if err != nil {
+		t.rollback(evicted)
+		return err
+	}
+	if evicted != nil {
+		if err := t.journalRecord(journalDelete, evicted.key, nil); err != nil {
+			t.tracef(LevelError, "journal write of key %#v failed, rolling back: %s\n", evicted.key, err.Error())
+			t.rollback(inverse)
+			t.rollback(evicted)
+			return err
+		}
+	}
+	if err := t.journalRecord(journalPut, key, data); err != nil {
+		t.tracef(LevelError, "journal write of key %#v failed, rolling back: %s\n", key, err.Error())
+		t.rollback(inverse)
+		if evicted != nil {
+			// the eviction is journaled already, so its rollback must be too
+			if err := t.revert(*evicted); err != nil {
+				t.tracef(LevelError, "journal write of key %#v failed: %s\n", evicted.key, err.Error())
+			}
+		}
+		return err
+	}
+	t.pushHistory(evicted)
+	t.pushHistory(inverse)
+	t.reportEviction(evicted)
+	return nil
+}
+
+// put stores the mapping without the bookkeeping done by Put.
+func (t *Tree) put(key interface{}, data interface{}) error {
 	if err := mustBeValidKey(key); err != nil {
-		logger.Printf("Put was prematurely aborted: %s\n", err.Error())
+		t.tracef(LevelError, "Put was prematurely aborted: %s\n", err.Error())
 		return err
 	}
+	t.own()
+	t.filterAdd(key)
 
 	if t.Root == nil {
-		t.Root = &Node{Key: key, color: BLACK, payload: data}
-		logger.Printf("Added %s as root node\n", t.Root.String())
+		t.Root = t.newNode(key, data, nil)
+		t.Root.color = BLACK
+		t.stamp(t.Root)
+		t.grew(1)
+		t.reweighPath(t.Root)
+		t.tracef(LevelOp, "Added %s as root node\n", t.Root.String())
 		return nil
 	}
 
 	found, parent, dir := t.internalLookup(nil, t.Root, key, NODIR)
 	if found {
+		node := t.Root
 		if parent == nil {
-			logger.Printf("Put: parent=nil & found. Overwrite ROOT node\n")
-			t.Root.payload = data
+			t.tracef(LevelOp, "Put: parent=nil & found. Overwrite ROOT node\n")
 		} else {
-			logger.Printf("Put: parent!=nil & found. Overwriting\n")
+			t.tracef(LevelOp, "Put: parent!=nil & found. Overwriting\n")
 			switch dir {
 			case LEFT:
-				parent.Left.payload = data
+				node = parent.Left
 			case RIGHT:
-				parent.Right.payload = data
+				node = parent.Right
 			}
 		}
+		node.payload, node.expires = data, time.Time{}
+		t.stamp(node)
+		t.reweighPath(node)
 
 	} else {
 		if parent != nil {
-			newNode := &Node{Key: key, parent: parent, payload: data}
+			newNode := t.newNode(key, data, parent)
 			switch dir {
 			case LEFT:
 				parent.Left = newNode
 			case RIGHT:
 				parent.Right = newNode
 			}
-			logger.Printf("Added %s to %s node of parent %s\n", newNode.String(), dir, parent.String())
+			t.tracef(LevelOp, "Added %s to %s node of parent %s\n", newNode.String(), dir, parent.String())
+			t.stamp(newNode)
+			t.grew(1)
+			t.reweighPath(newNode)
 			t.fixupPut(newNode)
 		}
 	}
 	return nil
 }
 
+// Insert splices the pre-built node `n` into the tree and rebalances.
+// Any links `n` carried are reset. If a node with the same key already
+// exists, `n` takes its place (position, color and children); if `n` is
+// that node already, nothing moves.
+func (t *Tree) Insert(n *Node) error {
+	if err := t.mutable("Insert"); err != nil {
+		return err
+	}
+	defer t.beginOp("insert")()
+	if n == nil {
+		return ErrorNodeIsNil
+	}
+	n.Key = t.normalize(n.Key)
+	inverse := t.inverseOf(n.Key)
+	if err := t.insert(n); err != nil {
+		return err
+	}
+	return t.commit(journalPut, n.Key, n.payload, inverse)
+}
+
+func (t *Tree) insert(n *Node) error {
+	if err := mustBeValidKey(n.Key); err != nil {
+		t.tracef(LevelError, "Insert was prematurely aborted: %s\n", err.Error())
+		return err
+	}
+	t.own()
+	t.filterAdd(n.Key)
+	// look up before the links are reset: `n` may already be in the tree
+	found, old := t.getNode(n.Key)
+	if found && old == n {
+		t.tracef(LevelOp, "Insert: %s is already in place\n", n)
+		t.stamp(n)
+		t.reweighPath(n)
+		return nil
+	}
+	n.Left, n.Right, n.parent = nil, nil, nil
+	t.stamp(n)
+
+	if t.Root == nil {
+		n.color = BLACK
+		t.Root = n
+		t.grew(1)
+		t.reweighPath(n)
+		t.tracef(LevelOp, "Inserted %s as root node\n", n.String())
+		return nil
+	}
+
+	if found {
+		t.tracef(LevelOp, "Insert: replacing %s\n", old)
+		n.color = old.color
+		n.Left, n.Right = old.Left, old.Right
+		if n.Left != nil {
+			n.Left.parent = n
+		}
+		if n.Right != nil {
+			n.Right.parent = n
+		}
+		t.transplant(old, n)
+		old.Left, old.Right, old.parent = nil, nil, nil
+		t.reweighPath(n)
+		return nil
+	}
+
+	_, parent, dir := t.internalLookup(nil, t.Root, n.Key, NODIR)
+	n.color = RED
+	n.parent = parent
+	switch dir {
+	case LEFT:
+		parent.Left = n
+	case RIGHT:
+		parent.Right = n
+	}
+	t.tracef(LevelOp, "Inserted %s to %s node of parent %s\n", n.String(), dir, parent.String())
+	t.grew(1)
+	t.reweighPath(n)
+	t.fixupPut(n)
+	return nil
+}
+
 func isRed(n *Node) bool {
 	key := reflect.ValueOf(n)
 	if key.IsNil() {
@@ -364,10 +774,12 @@ func isRed(n *Node) bool {
 //
 // @param z - the newly added Node to the tree.
 func (t *Tree) fixupPut(z *Node) {
-	logger.Printf("\tfixup new node z %s\n", z.String())
+	t.tracef(LevelDetail, "fixup new node z %s\n", z.String())
+	defer func() { t.fixupStep = 0 }()
 loop:
 	for {
-		logger.Printf("\tcurrent z %s\n", z.String())
+		t.fixupStep++
+		t.tracef(LevelDetail, "current z %s\n", z.String())
 		switch {
 		case z.parent == nil:
 			fallthrough
@@ -375,18 +787,25 @@ loop:
 			fallthrough
 		default:
 			// When the loop terminates, it does so because p[z] is black.
-			logger.Printf("\t\t=> bye\n")
+			t.tracef(LevelDetail, "=> bye\n")
 			break loop
 		case z.parent.color == RED:
-			grandparent := z.parent.parent
-			logger.Printf("\t\tgrandparent is nil %t\n", grandparent == nil)
+			grandparent := z.GrandParent()
+			t.tracef(LevelDetail, "grandparent is nil %t\n", grandparent == nil)
+			if grandparent == nil {
+				// The parent is a red root. Put and Insert keep the root
+				// black, so this only happens with a hand-built or
+				// otherwise corrupted tree; blackening the root below
+				// repairs it, since z's red parent then turns black.
+				break loop
+			}
 			if z.parent == grandparent.Left {
-				logger.Printf("\t\t%s is the left child of %s\n", z.parent, grandparent)
-				y := grandparent.Right
-				logger.Printf("\t\ty (right) %s\n", y)
+				t.tracef(LevelDetail, "%s is the left child of %s\n", z.parent, grandparent)
+				y := z.Uncle()
+				t.tracef(LevelDetail, "y (right) %s\n", y)
 				if isRed(y) {
 					// case 1 - y is RED
-					logger.Printf("\t\t(*) case 1\n")
+					t.tracef(LevelDetail, "(*) case 1\n")
 					z.parent.color = BLACK
 					y.color = BLACK
 					grandparent.color = RED
@@ -395,40 +814,40 @@ loop:
 				} else {
 					if z == z.parent.Right {
 						// case 2
-						logger.Printf("\t\t(*) case 2\n")
+						t.tracef(LevelDetail, "(*) case 2\n")
 						z = z.parent
 						t.RotateLeft(z)
 					}
 
 					// case 3
-					logger.Printf("\t\t(*) case 3\n")
+					t.tracef(LevelDetail, "(*) case 3\n")
 					z.parent.color = BLACK
 					grandparent.color = RED
 					t.RotateRight(grandparent)
 				}
 			} else {
-				logger.Printf("\t\t%s is the right child of %s\n", z.parent, grandparent)
-				y := grandparent.Left
-				logger.Printf("\t\ty (left) %s\n", y)
+				t.tracef(LevelDetail, "%s is the right child of %s\n", z.parent, grandparent)
+				y := z.Uncle()
+				t.tracef(LevelDetail, "y (left) %s\n", y)
 				if isRed(y) {
 					// case 1 - y is RED
-					logger.Printf("\t\t..(*) case 1\n")
+					t.tracef(LevelDetail, "..(*) case 1\n")
 					z.parent.color = BLACK
 					y.color = BLACK
 					grandparent.color = RED
 					z = grandparent
 
 				} else {
-					logger.Printf("\t\t## %s\n", z.parent.Left)
+					t.tracef(LevelDetail, "## %s\n", z.parent.Left)
 					if z == z.parent.Left {
 						// case 2
-						logger.Printf("\t\t..(*) case 2\n")
+						t.tracef(LevelDetail, "..(*) case 2\n")
 						z = z.parent
 						t.RotateRight(z)
 					}
 
 					// case 3
-					logger.Printf("\t\t..(*) case 3\n")
+					t.tracef(LevelDetail, "..(*) case 3\n")
 					z.parent.color = BLACK
 					grandparent.color = RED
 					t.RotateLeft(grandparent)
@@ -440,20 +859,146 @@ loop:
 }
 
 // Size returns the number of items in the tree.
+// The count is taken with a walk the first time and then kept up to date
+// by the tree's own mutations.
 func (t *Tree) Size() uint64 {
-	visitor := &countingVisitor{}
-	t.Walk(visitor)
-	return visitor.Count
+	if t == nil {
+		return 0
+	}
+	if !t.sizeKnown {
+		visitor := &countingVisitor{}
+		t.Walk(visitor)
+		t.size, t.sizeKnown = visitor.Count, true
+	}
+	return t.size
+}
+
+// grew adjusts the cached size after a node was added (+1) or removed (-1).
+// Being a structural change, it also counts as a modification.
+func (t *Tree) grew(delta int) {
+	t.mods++
+	if t.sizeKnown {
+		t.size = uint64(int64(t.size) + int64(delta))
+	}
+}
+
+// Keys returns all keys of the tree in ascending order; see InorderKeys.
+func (t *Tree) Keys() []interface{} {
+	return t.InorderKeys()
+}
+
+// InorderKeys returns all keys of the tree in ascending order, as an
+// in-order walk with a collecting Visitor would, but without writing one.
+// Expired entries are left out.
+func (t *Tree) InorderKeys() []interface{} {
+	if t.IsEmpty() {
+		return []interface{}{}
+	}
+	keys := make([]interface{}, 0, t.Size())
+	t.rangeWalk(nil, nil, func(n *Node) bool {
+		keys = append(keys, n.Key)
+		return true
+	})
+	return keys
 }
 
 // Has checks for existence of a item identified by supplied key.
 func (t *Tree) Has(key interface{}) bool {
+	if t == nil {
+		return false
+	}
+	key = t.normalize(key)
 	if err := mustBeValidKey(key); err != nil {
-		logger.Printf("Has was prematurely aborted: %s\n", err.Error())
+		t.tracef(LevelError, "Has was prematurely aborted: %s\n", err.Error())
+		return false
+	}
+	if t.definitelyAbsent(key) {
+		return false
+	}
+	found, node := t.getNode(key)
+	return found && !t.expired(node)
+}
+
+// SetValue replaces the payload mapped to an existing key.
+// Unlike Put it never adds a node; it returns false if `key` is absent,
+// if Options.Writer rejects the change, or if the change cannot be
+// journaled, in which case it is rolled back in the tree and the writer.
+func (t *Tree) SetValue(key, payload interface{}) bool {
+	if t.mutable("SetValue") != nil {
+		return false
+	}
+	defer t.beginOp("set")()
+	key = t.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "SetValue was prematurely aborted: %s\n", err.Error())
+		return false
+	}
+	found, node := t.getNode(key)
+	if !found {
+		return false
+	}
+	written, err := t.writeThrough("SetValue", OpPut, key, payload)
+	if err != nil {
+		return false
+	}
+	inverse := t.inverseOf(key)
+	t.own()
+	_, node = t.getNode(key)
+	node.payload = payload
+	t.stamp(node)
+	t.reweighPath(node)
+	if err := t.commit(journalPut, key, payload, inverse); err != nil {
+		t.tracef(LevelError, "SetValue: %s\n", t.opts.Writer.undo(written, err).Error())
 		return false
 	}
-	found, _, _ := t.internalLookup(nil, t.Root, key, NODIR)
-	return found
+	return true
+}
+
+// ReplaceKey moves the payload mapped to `oldKey` over to `newKey`, for
+// when the sort value of an entry changes. The node is deleted and the
+// payload put back under the new key, so the tree is rebalanced as usual
+// and the move is journaled and recorded as a Delete followed by a Put;
+// an expiry set by PutTTL is not carried over.
+// It fails with ErrorKeyNotFound if `oldKey` is absent from the tree,
+// without asking Options.Loader for it, and with ErrorKeyExists if
+// `newKey` is present, unless Options.ReplaceOverwrites is set, in which
+// case the payload of `newKey` is replaced. If the Put of `newKey` fails
+// (see Options.Writer and Options.MaxHeight), the entry is put back under
+// `oldKey` and the error returned.
+func (t *Tree) ReplaceKey(oldKey, newKey interface{}) error {
+	if err := t.mutable("ReplaceKey"); err != nil {
+		return err
+	}
+	defer t.beginOp("replace")()
+	oldKey, newKey = t.normalize(oldKey), t.normalize(newKey)
+	for _, key := range []interface{}{oldKey, newKey} {
+		if err := mustBeValidKey(key); err != nil {
+			t.tracef(LevelError, "ReplaceKey was prematurely aborted: %s\n", err.Error())
+			return err
+		}
+	}
+	found, payload := t.lookup(oldKey)
+	if !found {
+		return fmt.Errorf("%w: %#v", ErrorKeyNotFound, oldKey)
+	}
+	if t.compare(oldKey, newKey) == 0 {
+		return nil
+	}
+	if !t.opts.ReplaceOverwrites && t.Has(newKey) {
+		return fmt.Errorf("%w: %#v", ErrorKeyExists, newKey)
+	}
+	if err := t.Delete(oldKey); err != nil {
+		return err
+	}
+	if err := t.Put(newKey, payload); err != nil {
+		// put the entry back so that a failed move loses nothing
+		if restoreErr := t.Put(oldKey, payload); restoreErr != nil {
+			t.tracef(LevelError, "ReplaceKey: restoring %#v failed: %s\n", oldKey, restoreErr.Error())
+			return fmt.Errorf("%w (restoring %#v failed too: %s)", err, oldKey, restoreErr)
+		}
+		return err
+	}
+	return nil
 }
 
 func (t *Tree) transplant(u *Node, v *Node) {
@@ -469,47 +1014,116 @@ func (t *Tree) transplant(u *Node, v *Node) {
 	}
 }
 
-// Delete removes the item identified by the supplied key.
-// Delete is a noop if the supplied key doesn't exist.
-func (t *Tree) Delete(key interface{}) {
-	if !t.Has(key) {
-		logger.Printf("Delete: bail as no node exists for key %d\n", key)
+// MapValues replaces the payload of every entry with fn(key, payload),
+// in ascending key order. Keys and shape are left alone, so no rebalancing
+// happens. Each replacement is handed to Options.Writer, journaled and
+// recorded like a SetValue, and rolled back like one if the journal write
+// fails; an entry whose replacement the writer rejects keeps its payload.
+func (t *Tree) MapValues(fn func(key, payload interface{}) interface{}) {
+	if t.IsEmpty() || t.mutable("MapValues") != nil {
 		return
 	}
-	_, z := t.getNode(key)
-	logger.Printf("Delete: attempt to delete %s\n", z)
+	defer t.beginOp("map")()
+	t.own()
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step != stepIn {
+			return
+		}
+		payload := fn(n.Key, n.payload)
+		written, err := t.writeThrough("MapValues", OpPut, n.Key, payload)
+		if err != nil {
+			return
+		}
+		inverse := t.inverseOf(n.Key)
+		n.payload = payload
+		t.stamp(n)
+		if err := t.commit(journalPut, n.Key, n.payload, inverse); err != nil {
+			t.tracef(LevelError, "MapValues: %s\n", t.opts.Writer.undo(written, err).Error())
+		}
+	})
+	t.reweighAll()
+}
+
+// Delete removes the item identified by the supplied key.
+// Delete is a noop if the supplied key doesn't exist. It fails if the tree
+// is frozen or the removal cannot be journaled. With Options.Writer set,
+// the removal is handed to it first, even if the key is missing from the
+// tree; see Writer.
+func (t *Tree) Delete(key interface{}) error {
+	inverse, err := t.writeThrough("Delete", OpDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	err = t.erase(key)
+	if err != nil && inverse != nil {
+		// the store took the mutation the tree failed to apply
+		err = t.opts.Writer.undo(inverse, err)
+	}
+	return err
+}
+
+// erase is Delete without the write-through.
+func (t *Tree) erase(key interface{}) error {
+	if err := t.mutable("Delete"); err != nil {
+		return err
+	}
+	defer t.beginOp("delete")()
+	key = t.normalize(key)
+	inverse := t.inverseOf(key)
+	if !t.remove(key) {
+		return nil
+	}
+	return t.commit(journalDelete, key, nil, inverse)
+}
+
+// remove unlinks the node identified by key without the bookkeeping done
+// by Delete. It reports whether a node was removed.
+func (t *Tree) remove(key interface{}) bool {
+	found, z := t.getNode(key)
+	if !found {
+		t.tracef(LevelError, "Delete: bail as no node exists for key %d\n", key)
+		return false
+	}
+	if t.shared {
+		t.own()
+		_, z = t.getNode(key)
+	}
+	t.tracef(LevelOp, "Delete: attempt to delete %s\n", z)
 	y := z
 	yOriginalColor := y.color
 	var x *Node
+	xParent := z.parent
 
 	if z.Left == nil {
 		// one child (RIGHT)
-		logger.Printf("\t\tDelete: case (a)\n")
+		t.tracef(LevelDetail, "Delete: case (a)\n")
 		x = z.Right
-		logger.Printf("\t\t\t--- x is right of z")
+		t.tracef(LevelDetail, "--- x is right of z")
 		t.transplant(z, z.Right)
 
 	} else if z.Right == nil {
 		// one child (LEFT)
-		logger.Printf("\t\tDelete: case (b)\n")
+		t.tracef(LevelDetail, "Delete: case (b)\n")
 		x = z.Left
-		logger.Printf("\t\t\t--- x is left of z")
+		t.tracef(LevelDetail, "--- x is left of z")
 		t.transplant(z, z.Left)
 
 	} else {
 		// two children
-		logger.Printf("\t\tDelete: case (c) & (d)\n")
+		t.tracef(LevelDetail, "Delete: case (c) & (d)\n")
 		y = t.getMinimum(z.Right)
-		logger.Printf("\t\t\tminimum of z.Right is %s (color=%s)\n", y, y.color)
+		t.tracef(LevelDetail, "minimum of z.Right is %s (color=%s)\n", y, y.color)
 		yOriginalColor = y.color
 		x = y.Right
-		logger.Printf("\t\t\t--- x is right of minimum")
+		t.tracef(LevelDetail, "--- x is right of minimum")
 
 		if y.parent == z {
+			xParent = y
 			if x != nil {
 				x.parent = y
 			}
 		} else {
+			xParent = y.parent
 			t.transplant(y, y.Right)
 			y.Right = z.Right
 			y.Right.parent = y
@@ -519,109 +1133,124 @@ func (t *Tree) Delete(key interface{}) {
 		y.Left.parent = y
 		y.color = z.color
 	}
+	t.reweighPath(xParent)
 	if yOriginalColor == BLACK {
-		t.fixupDelete(x)
+		t.fixupDelete(x, xParent)
 	}
+	t.freeNode(z)
+	t.grew(-1)
+	t.filterRemoved()
+	t.bury(key)
+	return true
 }
 
-func (t *Tree) fixupDelete(x *Node) {
-	logger.Printf("\t\t\tfixupDelete of node %s\n", x)
-	if x == nil {
-		return
-	}
+// fixupDelete restores the red-black properties after a black node was
+// unlinked. x is the node that took its place and may be nil, which is why
+// its parent is passed explicitly.
+func (t *Tree) fixupDelete(x *Node, parent *Node) {
+	t.tracef(LevelDetail, "fixupDelete of node %s\n", x)
+	defer func() { t.fixupStep = 0 }()
 loop:
 	for {
+		t.fixupStep++
 		switch {
 		case x == t.Root:
-			logger.Printf("\t\t\t=> bye .. is root\n")
+			t.tracef(LevelDetail, "=> bye .. is root\n")
 			break loop
-		case x.color == RED:
-			logger.Printf("\t\t\t=> bye .. RED\n")
+		case isRed(x):
+			t.tracef(LevelDetail, "=> bye .. RED\n")
 			break loop
-		case x == x.parent.Right:
-			logger.Printf("\t\tBRANCH: x is right child of parent\n")
-			w := x.parent.Left // is nillable
+		case x == parent.Right:
+			t.tracef(LevelDetail, "BRANCH: x is right child of parent\n")
+			w := parent.Left // not nil: the removed black node left a deficit
 			if isRed(w) {
 				// Convert case 1 into case 2, 3, or 4
-				logger.Printf("\t\t\tR> case 1\n")
+				t.tracef(LevelDetail, "R> case 1\n")
 				w.color = BLACK
-				x.parent.color = RED
-				t.RotateRight(x.parent)
-				w = x.parent.Left
+				parent.color = RED
+				t.RotateRight(parent)
+				w = parent.Left
 			}
-			if w != nil {
-				switch {
-				case !isRed(w.Left) && !isRed(w.Right):
-					// case 2 - both children of w are BLACK
-					logger.Printf("\t\t\tR> case 2\n")
-					w.color = RED
-					x = x.parent // recurse up tree
-				case isRed(w.Right) && !isRed(w.Left):
-					// case 3 - right child RED & left child BLACK
-					// convert to case 4
-					logger.Printf("\t\t\tR> case 3\n")
-					w.Right.color = BLACK
-					w.color = RED
-					t.RotateLeft(w)
-					w = x.parent.Left
-				}
-				if isRed(w.Left) {
-					// case 4 - left child is RED
-					logger.Printf("\t\t\tR> case 4\n")
-					w.color = x.parent.color
-					x.parent.color = BLACK
-					w.Left.color = BLACK
-					t.RotateRight(x.parent)
-					x = t.Root
-				}
+			if !isRed(w.Left) && !isRed(w.Right) {
+				// case 2 - both children of w are BLACK
+				t.tracef(LevelDetail, "R> case 2\n")
+				w.color = RED
+				x = parent // recurse up tree
+				parent = x.parent
+				continue
 			}
-		case x == x.parent.Left:
-			logger.Printf("\t\tBRANCH: x is left child of parent\n")
-			w := x.parent.Right // is nillable
+			if !isRed(w.Left) {
+				// case 3 - right child RED & left child BLACK
+				// convert to case 4
+				t.tracef(LevelDetail, "R> case 3\n")
+				w.Right.color = BLACK
+				w.color = RED
+				t.RotateLeft(w)
+				w = parent.Left
+			}
+			// case 4 - left child is RED
+			t.tracef(LevelDetail, "R> case 4\n")
+			w.color = parent.color
+			parent.color = BLACK
+			w.Left.color = BLACK
+			t.RotateRight(parent)
+			x, parent = t.Root, nil
+		default:
+			t.tracef(LevelDetail, "BRANCH: x is left child of parent\n")
+			w := parent.Right // not nil: the removed black node left a deficit
 			if isRed(w) {
 				// Convert case 1 into case 2, 3, or 4
-				logger.Printf("\t\t\tL> case 1\n")
+				t.tracef(LevelDetail, "L> case 1\n")
 				w.color = BLACK
-				x.parent.color = RED
-				t.RotateLeft(x.parent)
-				w = x.parent.Right
+				parent.color = RED
+				t.RotateLeft(parent)
+				w = parent.Right
 			}
-			if w != nil {
-				switch {
-				case !isRed(w.Left) && !isRed(w.Right):
-					// case 2 - both children of w are BLACK
-					logger.Printf("\t\t\tL> case 2\n")
-					w.color = RED
-					x = x.parent // recurse up tree
-				case isRed(w.Left) && !isRed(w.Right):
-					// case 3 - left child RED & right child BLACK
-					// convert to case 4
-					logger.Printf("\t\t\tL> case 3\n")
-					w.Left.color = BLACK
-					w.color = RED
-					t.RotateRight(w)
-					w = x.parent.Right
-				}
-				if isRed(w.Right) {
-					// case 4 - right child is RED
-					logger.Printf("\t\t\tL> case 4\n")
-					w.color = x.parent.color
-					x.parent.color = BLACK
-					w.Right.color = BLACK
-					t.RotateLeft(x.parent)
-					x = t.Root
-				}
+			if !isRed(w.Left) && !isRed(w.Right) {
+				// case 2 - both children of w are BLACK
+				t.tracef(LevelDetail, "L> case 2\n")
+				w.color = RED
+				x = parent // recurse up tree
+				parent = x.parent
+				continue
 			}
+			if !isRed(w.Right) {
+				// case 3 - left child RED & right child BLACK
+				// convert to case 4
+				t.tracef(LevelDetail, "L> case 3\n")
+				w.Left.color = BLACK
+				w.color = RED
+				t.RotateRight(w)
+				w = parent.Right
+			}
+			// case 4 - right child is RED
+			t.tracef(LevelDetail, "L> case 4\n")
+			w.color = parent.color
+			parent.color = BLACK
+			w.Right.color = BLACK
+			t.RotateLeft(parent)
+			x, parent = t.Root, nil
 		}
 	}
-	x.color = BLACK
+	if x != nil {
+		x.color = BLACK
+	}
 }
 
 // Walk accepts a Visitor
 func (t *Tree) Walk(visitor Visitor) {
+	if t == nil {
+		visitor.Visit(nil)
+		return
+	}
 	visitor.Visit(t.Root)
 }
 
+// IsEmpty reports whether the tree holds no entries.
+func (t *Tree) IsEmpty() bool {
+	return t == nil || t.Root == nil
+}
+
 // countingVisitor counts the number
 // of nodes in the tree.
 type countingVisitor struct {
@@ -629,19 +1258,27 @@ type countingVisitor struct {
 }
 
 func (v *countingVisitor) Visit(node *Node) {
-	if node == nil {
-		return
-	}
+	traverse(node, v.step)
+}
 
-	v.Visit(node.Left)
-	v.Count = v.Count + 1
-	v.Visit(node.Right)
+func (v *countingVisitor) step(step walkStep, n *Node) {
+	if step == stepIn {
+		v.Count = v.Count + 1
+	}
 }
 
 // InorderVisitor walks the tree in inorder fashion.
 // This visitor maintains internal state; thus do not
 // reuse after the completion of a walk.
+//
+// Every subtree is rendered as "(left key right)" with "." for an empty
+// one. The zero value renders keys with %d; the options below add the
+// color of each node as "{B}" or "{R}" and its payload as ":payload".
 type InorderVisitor struct {
+	IncludeColor   bool
+	IncludePayload bool
+	KeyFormat      func(key interface{}) string // nil means %d
+
 	buffer bytes.Buffer
 }
 
@@ -652,30 +1289,30 @@ func (v *InorderVisitor) Eq(other *InorderVisitor) bool {
 	return v.String() == other.String()
 }
 
-func (v *InorderVisitor) trim(s string) string {
-	return strings.TrimRight(strings.TrimRight(s, "ed"), "lack")
-}
-
 func (v *InorderVisitor) String() string {
 	return v.buffer.String()
 }
 
 func (v *InorderVisitor) Visit(node *Node) {
-	if node == nil {
-		v.buffer.Write([]byte("."))
-		return
-	}
-	v.buffer.Write([]byte("("))
-	v.Visit(node.Left)
-	v.buffer.Write([]byte(fmt.Sprintf("%d", node.Key))) // @TODO
-	//v.buffer.Write([]byte(fmt.Sprintf("%d{%s}", node.Key, v.trim(node.color.String()))))
-	v.Visit(node.Right)
-	v.buffer.Write([]byte(")"))
+	traverse(node, v.step)
+}
+
+func (v *InorderVisitor) step(step walkStep, n *Node) {
+	writeParenthesized(&v.buffer, step, n, stepIn, func(n *Node) string {
+		return nodeLabel(n, v.IncludeColor, v.IncludePayload, v.KeyFormat)
+	})
 }
 
 var (
-	ErrorKeyIsNil      = errors.New("The literal nil not allowed as keys")
-	ErrorKeyDisallowed = errors.New("Disallowed key type")
+	ErrorKeyIsNil        = errors.New("The literal nil not allowed as keys")
+	ErrorKeyDisallowed   = errors.New("Disallowed key type")
+	ErrorNodeIsNil       = errors.New("The literal nil not allowed as node")
+	ErrorTreeIsNil       = errors.New("The tree is nil")
+	ErrorInvalidRotation = errors.New("Node cannot be rotated")
+	ErrorKeyNotFound     = errors.New("Key does not exist")
+	ErrorKeyExists       = errors.New("Key already exists")
+	ErrorComparatorIsNil = errors.New("The comparator is nil")
+	ErrorCyclicTree      = errors.New("Node links form a cycle")
 )
 
 func mustBeValidKey(key interface{}) error {
@@ -702,23 +1339,41 @@ func mustBeValidKey(key interface{}) error {
 	}
 }
 
-func getSplitNode(n *Node, x1, x2 int, debug bool) *Node {
-
-	if n.Key.(int) >= x1 && n.Key.(int) <= x2 {
-		if debug {
-			log.Printf("[SUCCESS] - Found Split Node: %+v", n.String())
+// getSplitNode returns the node of a leaf-based range tree where the search
+// paths to x1 and x2 part ways, or the leaf both paths end at. Inner nodes
+// hold the largest key of their left subtree, so a range entirely at or
+// below a node's key lies to its left and one entirely above it to its right.
+// The descent is a loop, so skewed hand-built trees can't overflow the
+// stack, and links that lead back to a node already passed (a corrupted
+// tree) fail with ErrorCyclicTree instead of looping forever. The check is
+// Brent's cycle detection, which needs no memory beyond two pointers.
+func getSplitNode(n *Node, x1, x2 int, debug bool) (*Node, error) {
+	tortoise, steps, power := n, 0, 1
+	for n != nil {
+		k := n.Key.(int)
+		var next *Node
+		switch {
+		case n.Leaf || n.isLeaf():
+		case x2 <= k && n.Left != nil:
+			next = n.Left
+		case x1 > k && n.Right != nil:
+			next = n.Right
+		}
+		if next == nil {
+			if debug {
+				log.Printf("[SUCCESS] - Found Split Node: %+v", n.String())
+			}
+			return n, nil
+		}
+		n = next
+		if n == tortoise {
+			return nil, fmt.Errorf("%w: %s is its own descendant", ErrorCyclicTree, n)
+		}
+		if steps++; steps == power {
+			tortoise, steps, power = n, 0, power*2
 		}
-		return n
-	}
-
-	if n.Left != nil {
-		return getSplitNode(n.Left, x1, x2, debug)
-	}
-
-	if n.Right != nil {
-		return getSplitNode(n.Right, x1, x2, debug)
 	}
-	return nil
+	return nil, nil
 }
 
 func (n *Node) isLeaf() bool {
@@ -728,73 +1383,46 @@ func (n *Node) isLeaf() bool {
 	return false
 }
 
-func (t *Tree) getValuesInRange(x1, x2 int, debug bool) []int {
+// getValuesInRange answers a range query on a leaf-based range tree such as
+// the one built by main: keys are stored in the nodes flagged Leaf, while
+// inner nodes repeat the largest key of their left subtree to guide the
+// search. It returns the leaf keys within [x1, x2] in ascending order, each
+// exactly once. Trees built with Put have no leaves; use RangeSearch there.
+// Since such trees are put together by hand, links forming a cycle are
+// reported with ErrorCyclicTree.
+func (t *Tree) getValuesInRange(x1, x2 int, debug bool) ([]int, error) {
 	if debug {
 		log.Printf("[Query] Values between %v and %v", x1, x2)
 	}
-	rangeNodes := []Node{}
-	Vs := getSplitNode(t.Root, x1, x2, debug)
+	keys := []int{}
+	Vs, err := getSplitNode(t.Root, x1, x2, debug)
+	if err != nil {
+		log.Printf("\n\t[ERR] %s\n", err)
+		return keys, err
+	}
 	if Vs == nil {
 		log.Printf("\n\t[ERR] Couldn't find Split Node\n")
-		return []int{}
-	}
-
-	curNode := Vs
-	if curNode.isLeaf() {
-		if curNode.Key.(int) >= x1 && curNode.Key.(int) <= x2 {
-			rangeNodes = append(rangeNodes, *curNode)
-		}
-	} else {
-		curNode = curNode.Left
+		return keys, nil
 	}
 
-	/*Going left*/
-
-	for true {
-		if !curNode.isLeaf() {
-
-			if x1 <= curNode.Key.(int) {
-				rangeNodes = append(rangeNodes, *curNode.Right)
-				curNode = curNode.Left
-			} else {
-				curNode = curNode.Right
-			}
-
-		} else {
-			break
+	// every key in range lies in the subtree of the split node
+	err = acyclicRangeWalkFrom(Vs, x1, x2, IntComparator, func(n *Node) bool {
+		if !n.Leaf {
+			return true
 		}
-	}
-
-	if curNode.Key.(int) >= x1 && curNode.Key.(int) <= x2 {
-		rangeNodes = append(rangeNodes, *curNode)
-	}
-
-	/*Going right*/
-
-	curNode = Vs.Right
-	for true {
-		if !curNode.isLeaf() {
-			if curNode.Key.(int) <= x2 {
-				rangeNodes = append(rangeNodes, *curNode.Left)
-				curNode = curNode.Right
-			} else {
-				curNode = curNode.Left
-			}
-		} else {
-			break
+		// a hand-built tree may repeat a leaf key; report it once
+		if last := len(keys) - 1; last < 0 || keys[last] != n.Key.(int) {
+			keys = append(keys, n.Key.(int))
 		}
-	}
-
-	if curNode.Key.(int) >= x1 && curNode.Key.(int) <= x2 {
-		rangeNodes = append(rangeNodes, *curNode)
-	}
-	keys := []int{}
-	for _, node := range rangeNodes {
-		keys = append(keys, node.Key.(int))
+		return true
+	})
+	if err != nil {
+		log.Printf("\n\t[ERR] %s\n", err)
+		return keys, err
 	}
 
 	log.Printf("Values in Range [%v, %v] -> %+v", x1, x2, keys)
-	return keys
+	return keys, nil
 }
 
 func (t *Tree) printToJSON() {
@@ -835,8 +1463,8 @@ func main() {
 	tree := Tree{Root: &Node{Key: 49, Left: &node23, Right: &node80}, cmp: IntComparator}
 
 	/* Range TESTS */
-	_ = tree.getValuesInRange(19, 77, false)
-	_ = tree.getValuesInRange(15, 30, false)
+	_, _ = tree.getValuesInRange(19, 77, false)
+	_, _ = tree.getValuesInRange(15, 30, false)
 
 	/* JSON Tree Export*/
 	tree.printToJSON()
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newIntTree returns an IntComparator tree holding `keys`, inserted in the
+// given order, each mapped to itself.
+func newIntTree(tb testing.TB, keys ...int) *Tree {
+	tb.Helper()
+	tree := NewTree()
+	for _, k := range keys {
+		if err := tree.Put(k, k); err != nil {
+			tb.Fatalf("Put(%d): %s", k, err)
+		}
+	}
+	return tree
+}
+
+// checkRedBlack fails the test if `tree` violates an invariant checked by
+// CheckInvariants.
+func checkRedBlack(tb testing.TB, tree *Tree) {
+	tb.Helper()
+	if err := tree.CheckInvariants(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestInsertBuiltNodes(t *testing.T) {
+	tree := NewTree()
+	for _, k := range []int{8, 3, 10, 1, 6, 14, 4, 7, 13} {
+		n := NewNode(k, k*10)
+		n.SetValue(k * 100)
+		if err := tree.Insert(n); err != nil {
+			t.Fatalf("Insert(%d): %s", k, err)
+		}
+		checkRedBlack(t, tree)
+	}
+	for _, k := range []int{1, 3, 4, 6, 7, 8, 10, 13, 14} {
+		if found, v := tree.Get(k); !found || v != k*100 {
+			t.Errorf("Get(%d) = %v, %v", k, found, v)
+		}
+	}
+
+	replacement := NewNode(6, "six")
+	if err := tree.Insert(replacement); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	if found, v := tree.Get(6); !found || v != "six" || tree.Size() != 9 {
+		t.Errorf("after replacing 6: Get = %v, %v; Size = %d", found, v, tree.Size())
+	}
+
+	// a node already in the tree stays where it is, subtrees included
+	if err := tree.Insert(tree.Root); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	if tree.Size() != 9 {
+		t.Errorf("re-inserting the root left %d nodes", tree.Size())
+	}
+}
+
+func TestInsertNil(t *testing.T) {
+	if err := NewTree().Insert(nil); err != ErrorNodeIsNil {
+		t.Fatalf("got %v, want ErrorNodeIsNil", err)
+	}
+}
+
+func TestReplaceKey(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 50; k++ {
+		tree.Put(k, k*10)
+	}
+	// from the far left to the far right, then across the root
+	for _, move := range []struct{ from, to, payload int }{
+		{1, 100, 10}, {100, -5, 10}, {25, 75, 250}, {49, 0, 490},
+	} {
+		if err := tree.ReplaceKey(move.from, move.to); err != nil {
+			t.Fatalf("ReplaceKey(%d, %d): %s", move.from, move.to, err)
+		}
+		if tree.Has(move.from) {
+			t.Errorf("ReplaceKey(%d, %d) kept the old key", move.from, move.to)
+		}
+		if _, v := tree.Get(move.to); v != move.payload {
+			t.Errorf("ReplaceKey(%d, %d) moved payload %v, want %d", move.from, move.to, v, move.payload)
+		}
+		checkRedBlack(t, tree)
+		if tree.Size() != 50 {
+			t.Fatalf("Size() = %d after moving %d", tree.Size(), move.from)
+		}
+	}
+
+	if err := tree.ReplaceKey(7, 7); err != nil {
+		t.Errorf("ReplaceKey onto the same key: %s", err)
+	}
+	if err := tree.ReplaceKey(999, 1000); !errors.Is(err, ErrorKeyNotFound) {
+		t.Errorf("absent old key: %v, want ErrorKeyNotFound", err)
+	}
+	if err := tree.ReplaceKey(2, 3); !errors.Is(err, ErrorKeyExists) {
+		t.Errorf("present new key: %v, want ErrorKeyExists", err)
+	}
+	if _, v := tree.Get(2); v != 20 {
+		t.Errorf("failed ReplaceKey left Get(2) = %v", v)
+	}
+
+	overwriting := NewTreeWithOptions(IntComparator, Options{ReplaceOverwrites: true})
+	overwriting.Put(1, "one")
+	overwriting.Put(2, "two")
+	if err := overwriting.ReplaceKey(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, v := overwriting.Get(2); v != "one" || overwriting.Size() != 1 {
+		t.Errorf("overwriting ReplaceKey: Get(2) = %v, Size() = %d", v, overwriting.Size())
+	}
+}
+
+// nthFailingWriter fails only the fail-th write.
+type nthFailingWriter struct {
+	writes, fail int
+}
+
+func (w *nthFailingWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.writes == w.fail {
+		return 0, errWriteFailed
+	}
+	return len(p), nil
+}
+
+func TestReplaceKeyRestoresOnFailedPut(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 4, 5)
+	// the Delete of the old key is journaled, the Put of the new one not
+	tree.Journal = &nthFailingWriter{fail: 2}
+	if err := tree.ReplaceKey(3, 30); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("ReplaceKey = %v, want errWriteFailed", err)
+	}
+	if _, v := tree.Get(3); v != 3 || tree.Has(30) || tree.Size() != 5 {
+		t.Errorf("failed ReplaceKey left keys %v, Get(3) = %v", keysOf(tree), v)
+	}
+	checkRedBlack(t, tree)
+}
+
+func TestSiblingAndUncle(t *testing.T) {
+	// 4 is the root, with 2 (1, 3) and 6 (5, 7) below it
+	tree := newIntTree(t, 4, 2, 6, 1, 3, 5, 7)
+	node := func(k int) *Node {
+		_, n := tree.getNode(k)
+		return n
+	}
+	for _, tc := range []struct {
+		key            int
+		sibling, uncle interface{}
+	}{
+		{4, nil, nil},
+		{2, 6, nil},
+		{6, 2, nil},
+		{1, 3, 6},
+		{3, 1, 6},
+		{5, 7, 2},
+		{7, 5, 2},
+	} {
+		n := node(tc.key)
+		if got := keyOrNil(n.Sibling()); got != tc.sibling {
+			t.Errorf("%d.Sibling() = %v, want %v", tc.key, got, tc.sibling)
+		}
+		if got := keyOrNil(n.Uncle()); got != tc.uncle {
+			t.Errorf("%d.Uncle() = %v, want %v", tc.key, got, tc.uncle)
+		}
+	}
+
+	// an only child has no sibling
+	tree.Delete(7)
+	if s := node(5).Sibling(); s != nil {
+		t.Errorf("only child 5 has sibling %v", s)
+	}
+	// the root of a subtree shared between versions has no single parent
+	version := tree.PutPersistent(0, nil)
+	if s := node(3).Sibling(); s == nil || s.Key != 1 {
+		t.Errorf("3.Sibling() = %v in the original tree", s)
+	}
+	_, right := version.getNode(6)
+	if !right.shared || right.Sibling() != nil || node(5).Uncle() != nil {
+		t.Error("a shared subtree root reports a sibling")
+	}
+}
+
+// keyOrNil returns the key of `n`, or nil if there is no node.
+func keyOrNil(n *Node) interface{} {
+	if n == nil {
+		return nil
+	}
+	return n.Key
+}
+
+func TestGrandParent(t *testing.T) {
+	tree := newIntTree(t, 4, 2, 6, 1, 3, 5, 7)
+	for k, want := range map[int]interface{}{4: nil, 2: nil, 6: nil, 1: 4, 3: 4, 5: 4, 7: 4} {
+		_, n := tree.getNode(k)
+		if got := keyOrNil(n.GrandParent()); got != want {
+			t.Errorf("%d.GrandParent() = %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestPutBelowRedRoot(t *testing.T) {
+	// a hand-built tree whose red root has no parent for the fixup to
+	// climb to when a red child is added below it
+	tree := &Tree{Root: &Node{Key: 5, color: RED}, cmp: IntComparator}
+	if err := tree.Put(3, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	if got := keysOf(tree); !reflect.DeepEqual(got, []interface{}{3, 5}) {
+		t.Errorf("keys %v", got)
+	}
+}
+
+func TestPutEveryInsertionOrder(t *testing.T) {
+	// every order of 7 keys, so that each key in turn lands as a red
+	// child of the root, including with a red sibling
+	var permute func(keys []int, k int)
+	permute = func(keys []int, k int) {
+		if k == len(keys) {
+			tree := newIntTree(t, keys...)
+			checkRedBlack(t, tree)
+			if tree.Size() != uint64(len(keys)) {
+				t.Fatalf("order %v: Size() = %d", keys, tree.Size())
+			}
+			return
+		}
+		for i := k; i < len(keys); i++ {
+			keys[k], keys[i] = keys[i], keys[k]
+			permute(keys, k+1)
+			keys[k], keys[i] = keys[i], keys[k]
+		}
+	}
+	permute([]int{1, 2, 3, 4, 5, 6, 7}, 0)
+}
+
+func TestStrictRotations(t *testing.T) {
+	// in a tree of 2 over 1 and 3, the leaves 1 and 3 have no children to
+	// rotate with
+	for _, tc := range []struct {
+		name   string
+		rotate func(tree *Tree)
+	}{
+		{"RotateRight(nil)", func(tree *Tree) { tree.RotateRight(nil) }},
+		{"RotateLeft(nil)", func(tree *Tree) { tree.RotateLeft(nil) }},
+		{"RotateRight without left child", func(tree *Tree) { _, n := tree.getNode(3); tree.RotateRight(n) }},
+		{"RotateLeft without right child", func(tree *Tree) { _, n := tree.getNode(1); tree.RotateLeft(n) }},
+	} {
+		lenient := newIntTree(t, 2, 1, 3)
+		shape := shapeOf(lenient)
+		tc.rotate(lenient)
+		if shapeOf(lenient) != shape {
+			t.Errorf("%s: lenient tree changed", tc.name)
+		}
+
+		strict := NewTreeWithOptions(IntComparator, Options{Strict: true})
+		for _, k := range []int{2, 1, 3} {
+			strict.Put(k, nil)
+		}
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, ErrorInvalidRotation) {
+					t.Errorf("%s: strict tree panicked with %v, want ErrorInvalidRotation", tc.name, err)
+				}
+			}()
+			tc.rotate(strict)
+		}()
+	}
+
+	// the rebalancing itself never makes an invalid rotation
+	strict := NewTreeWithOptions(IntComparator, Options{Strict: true})
+	for k := 0; k < 500; k++ {
+		strict.Put(k, nil)
+	}
+	for k := 0; k < 500; k += 2 {
+		strict.Delete(k)
+	}
+	checkRedBlack(t, strict)
+}
+
+func TestTreeSetValue(t *testing.T) {
+	tree := newIntTree(t, 2, 1, 3)
+	if !tree.SetValue(2, "two") {
+		t.Fatal("SetValue of a present key returned false")
+	}
+	if found, v := tree.Get(2); !found || v != "two" {
+		t.Errorf("Get(2) = %v, %v", found, v)
+	}
+	if tree.SetValue(4, "four") {
+		t.Fatal("SetValue of an absent key returned true")
+	}
+	if tree.Has(4) || tree.Size() != 3 {
+		t.Errorf("SetValue of an absent key inserted it")
+	}
+}
+
+func TestCompositeComparator(t *testing.T) {
+	type name struct{ last, first string }
+	byLast := func(o1, o2 interface{}) int {
+		return StringComparator(o1.(name).last, o2.(name).last)
+	}
+	byFirst := func(o1, o2 interface{}) int {
+		return StringComparator(o1.(name).first, o2.(name).first)
+	}
+	cmp := CompositeComparator(byLast, byFirst)
+	for _, tc := range []struct {
+		a, b name
+		want int
+	}{
+		{name{"Doe", "Jane"}, name{"Roe", "Adam"}, -1},
+		{name{"Doe", "Jane"}, name{"Doe", "Adam"}, 1},
+		{name{"Doe", "Jane"}, name{"Doe", "Jane"}, 0},
+	} {
+		if got := cmp(tc.a, tc.b); got != tc.want {
+			t.Errorf("cmp(%v, %v) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	tree := NewTreeWith(cmp)
+	tree.Put(name{"Roe", "Adam"}, 1)
+	tree.Put(name{"Doe", "Jane"}, 2)
+	tree.Put(name{"Doe", "Adam"}, 3)
+	var firsts []string
+	tree.Walk(&OrderedVisitor{Order: InOrder, Fn: func(n *Node) {
+		firsts = append(firsts, n.Key.(name).first)
+	}})
+	if got := strings.Join(firsts, " "); got != "Adam Jane Adam" {
+		t.Errorf("inorder first names %q", got)
+	}
+}
+
+func TestCountingComparator(t *testing.T) {
+	var count int64
+	entries := make([]Entry, 1<<10-1)
+	for i := range entries {
+		entries[i] = Entry{Key: i}
+	}
+	tree, err := FromSorted(entries, CountingComparator(IntComparator, &count))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a perfectly balanced tree of 2^10-1 keys is 10 levels deep, and Get
+	// compares with one node per level it descends
+	for _, k := range []int{0, 511, 700, 1022} {
+		count = 0
+		if found, _ := tree.Get(k); !found {
+			t.Fatalf("Get(%d) missed", k)
+		}
+		if count < 1 || count > 10 {
+			t.Errorf("Get(%d) made %d comparisons, want at most log2(n) = 10", k, count)
+		}
+	}
+	count = 0
+	tree.Get(-1)
+	if count != 10 {
+		t.Errorf("missing Get made %d comparisons, want 10", count)
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	tree := NewTreeWith(StringComparator)
+	tree.KeyNormalizer = func(key interface{}) interface{} {
+		return strings.ToLower(strings.TrimSpace(key.(string)))
+	}
+	tree.Put("  Foo ", 1)
+	tree.Put("foo", 2)
+	if tree.Size() != 1 {
+		t.Fatalf("Size() = %d, want 1", tree.Size())
+	}
+	for _, key := range []string{"foo", "  Foo ", "FOO"} {
+		if found, v := tree.Get(key); !found || v != 2 {
+			t.Errorf("Get(%q) = %v, %v", key, found, v)
+		}
+	}
+	if tree.Root.Key != "foo" {
+		t.Errorf("stored key %q, want the normalized one", tree.Root.Key)
+	}
+	tree.Delete(" FOO")
+	if tree.Size() != 0 {
+		t.Errorf("Delete didn't normalize its key")
+	}
+}
+
+func TestNilAndZeroValueTrees(t *testing.T) {
+	var nilTree *Tree
+	for _, tc := range []struct {
+		name string
+		tree *Tree
+	}{
+		{"nil", nilTree},
+		{"zero value", &Tree{}},
+		{"NewTree", NewTree()},
+	} {
+		tree := tc.tree
+		if !tree.IsEmpty() || tree.Size() != 0 || len(tree.Keys()) != 0 {
+			t.Errorf("%s: IsEmpty() = %v, Size() = %d, Keys() = %v", tc.name, tree.IsEmpty(), tree.Size(), tree.Keys())
+		}
+		if found, _ := tree.Get(1); found || tree.Has(1) || tree.SetValue(1, nil) {
+			t.Errorf("%s: found a key in an empty tree", tc.name)
+		}
+		if got := tree.RangeSearch(0, 10); len(got) != 0 {
+			t.Errorf("%s: RangeSearch = %v", tc.name, got)
+		}
+		if _, ok := tree.Min(); ok || tree.Iterator().Next() {
+			t.Errorf("%s: Min or Iterator reported an entry", tc.name)
+		}
+		tree.Delete(1)
+		counter := &countingVisitor{}
+		tree.Walk(counter)
+		if counter.Count != 0 {
+			t.Errorf("%s: Walk counted %d nodes", tc.name, counter.Count)
+		}
+
+		err := tree.Put(2, "two")
+		if tree == nil {
+			if err != ErrorTreeIsNil || tree.Insert(NewNode(1, nil)) != ErrorTreeIsNil {
+				t.Errorf("%s: Put = %v, want ErrorTreeIsNil", tc.name, err)
+			}
+			continue
+		}
+		// the zero value orders its keys with IntComparator
+		for _, k := range []int{3, 1} {
+			if err := tree.Put(k, nil); err != nil {
+				t.Fatalf("%s: Put(%d): %s", tc.name, k, err)
+			}
+		}
+		if err != nil || tree.IsEmpty() || !reflect.DeepEqual(tree.Keys(), []interface{}{1, 2, 3}) {
+			t.Errorf("%s: Put = %v, Keys() = %v", tc.name, err, tree.Keys())
+		}
+		checkRedBlack(t, tree)
+	}
+}
+
+func TestComparator(t *testing.T) {
+	// by length, then bytewise: an order sort.Strings would not produce
+	byLength := func(a, b interface{}) int {
+		s1, s2 := a.(string), b.(string)
+		if len(s1) != len(s2) {
+			return len(s1) - len(s2)
+		}
+		return StringComparator(s1, s2)
+	}
+	tree := NewTreeWith(byLength)
+	keys := []interface{}{"pear", "fig", "banana", "kiwi", "apple", "date"}
+	for _, k := range keys {
+		tree.Put(k, nil)
+	}
+	cmp := tree.Comparator()
+	sort.Slice(keys, func(i, j int) bool { return cmp(keys[i], keys[j]) < 0 })
+	var inTree []interface{}
+	for _, e := range tree.Items() {
+		inTree = append(inTree, e.Key)
+	}
+	if !reflect.DeepEqual(keys, inTree) {
+		t.Errorf("sorted with Comparator() = %v, tree order %v", keys, inTree)
+	}
+
+	var zero *Tree
+	if zero.Comparator()(1, 2) >= 0 {
+		t.Error("nil tree does not default to IntComparator")
+	}
+}
+
+func TestNilComparator(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	tree := NewTreeWith(nil)
+	TraceOff()
+	if !strings.Contains(buf.String(), "no comparator supplied") {
+		t.Errorf("no warning logged for a nil comparator, got %q", buf.String())
+	}
+	for k := 0; k < 10; k++ {
+		if err := tree.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found, v := tree.Get(5); !found || v != 5 {
+		t.Errorf("Get(5) = %v, %v", found, v)
+	}
+	checkRedBlack(t, tree)
+
+	if tree, err := NewTreeWithChecked(nil); tree != nil || err != ErrorComparatorIsNil {
+		t.Errorf("NewTreeWithChecked(nil) = %v, %v, want ErrorComparatorIsNil", tree, err)
+	}
+	if tree, err := NewTreeWithChecked(StringComparator); err != nil || tree == nil {
+		t.Errorf("NewTreeWithChecked(StringComparator) = %v, %v", tree, err)
+	}
+}
+
+func TestInorderKeys(t *testing.T) {
+	if keys := NewTree().InorderKeys(); keys == nil || len(keys) != 0 {
+		t.Errorf("InorderKeys of an empty tree = %#v, want an empty slice", keys)
+	}
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTreeWithOptions(StringComparator, Options{Now: clock.Now})
+	inserted := []string{"pear", "apple", "fig", "kiwi", "banana", "date"}
+	for _, k := range inserted {
+		tree.Put(k, nil)
+	}
+	tree.PutTTL("cherry", nil, time.Second)
+	clock.now = clock.now.Add(time.Minute)
+
+	want := append([]string(nil), inserted...)
+	sort.Strings(want)
+	var got []string
+	for _, k := range tree.InorderKeys() {
+		got = append(got, k.(string))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InorderKeys() = %v, want %v without the expired key", got, want)
+	}
+	if keys := tree.Keys(); !reflect.DeepEqual(keys, tree.InorderKeys()) {
+		t.Errorf("Keys() = %v, differs from InorderKeys", keys)
+	}
+}
+
+func TestTimeComparator(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tree := NewTreeWith(TimeComparator)
+	for _, h := range []int{5, 1, 9, 3, 7, 0, 8, 2, 6, 4} {
+		tree.Put(base.Add(time.Duration(h)*time.Hour), h)
+	}
+	checkRedBlack(t, tree)
+
+	var hours []int
+	for _, k := range tree.RangeSearch(base.Add(2*time.Hour), base.Add(6*time.Hour+30*time.Minute)) {
+		hours = append(hours, int(k.(time.Time).Sub(base)/time.Hour))
+	}
+	if want := []int{2, 3, 4, 5, 6}; !reflect.DeepEqual(hours, want) {
+		t.Errorf("hours in range = %v, want %v", hours, want)
+	}
+
+	// the same instant in another location is the same key
+	tokyo := base.Add(3 * time.Hour).In(time.FixedZone("JST", 9*60*60))
+	if TimeComparator(tokyo, base.Add(3*time.Hour)) != 0 {
+		t.Error("TimeComparator tells apart the same instant in two locations")
+	}
+	if found, v := tree.Get(tokyo); !found || v != 3 {
+		t.Errorf("Get(%v) = %v, %v, want 3", tokyo, found, v)
+	}
+}
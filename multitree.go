@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+)
+
+// MultiTree maps each key to a list of payloads. Payloads of a key are kept
+// in insertion order, or in the order set with SetPayloadOrder, so that
+// lookups and range scans over duplicate keys are deterministic.
+type MultiTree struct {
+	tree *Tree // payload of each node is a []interface{}
+	less func(a, b interface{}) bool
+	size uint64
+}
+
+// NewMultiTree returns an empty MultiTree ordered by `c`.
+func NewMultiTree(c Comparator) *MultiTree {
+	return &MultiTree{tree: NewTreeWith(c)}
+}
+
+// SetPayloadOrder keeps the payloads of each key sorted by `less`;
+// payloads that compare equal stay in insertion order. Existing lists are
+// re-sorted. A nil `less` keeps insertion order for later Puts.
+func (m *MultiTree) SetPayloadOrder(less func(a, b interface{}) bool) {
+	m.less = less
+	if less == nil {
+		return
+	}
+	traverse(m.tree.Root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			values := n.payload.([]interface{})
+			sort.SliceStable(values, func(i, j int) bool { return less(values[i], values[j]) })
+		}
+	})
+}
+
+// Put adds `value` to the payloads of `key`.
+func (m *MultiTree) Put(key interface{}, value interface{}) error {
+	key = m.tree.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		return err
+	}
+	found, node := m.tree.getNode(key)
+	if !found {
+		if err := m.tree.Put(key, []interface{}{value}); err != nil {
+			return err
+		}
+		m.size++
+		return nil
+	}
+
+	values := node.payload.([]interface{})
+	at := len(values)
+	if m.less != nil {
+		at = sort.Search(len(values), func(i int) bool { return m.less(value, values[i]) })
+	}
+	values = append(values, nil)
+	copy(values[at+1:], values[at:])
+	values[at] = value
+	node.payload = values
+	m.size++
+	return nil
+}
+
+// GetAll returns a copy of the payloads of `key` in their configured order,
+// or nil if `key` is absent.
+func (m *MultiTree) GetAll(key interface{}) []interface{} {
+	found, payload := m.tree.Get(key)
+	if !found {
+		return nil
+	}
+	return append([]interface{}(nil), payload.([]interface{})...)
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (m *MultiTree) Has(key interface{}) bool {
+	return m.tree.Has(key)
+}
+
+// Delete removes `key` with all of its payloads.
+func (m *MultiTree) Delete(key interface{}) {
+	found, payload := m.tree.Get(key)
+	if !found {
+		return
+	}
+	m.tree.Delete(key)
+	m.size -= uint64(len(payload.([]interface{})))
+}
+
+// Size returns the number of payloads over all keys.
+func (m *MultiTree) Size() uint64 {
+	return m.size
+}
+
+// Keys returns the distinct keys in ascending order.
+func (m *MultiTree) Keys() []interface{} {
+	return m.tree.Keys()
+}
+
+// RangeSearch returns one entry per payload of the keys within [low, high],
+// by ascending key and then in payload order.
+func (m *MultiTree) RangeSearch(low, high interface{}) []Entry {
+	entries := []Entry{}
+	m.tree.rangeWalk(m.tree.normalize(low), m.tree.normalize(high), func(n *Node) bool {
+		for _, value := range n.payload.([]interface{}) {
+			entries = append(entries, Entry{Key: n.Key, Value: value})
+		}
+		return true
+	})
+	return entries
+}
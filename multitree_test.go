@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultiTreePayloadOrder(t *testing.T) {
+	byLength := func(a, b interface{}) bool { return len(a.(string)) < len(b.(string)) }
+	for _, tc := range []struct {
+		name  string
+		less  func(a, b interface{}) bool
+		want1 []interface{}
+	}{
+		{"insertion", nil, []interface{}{"ccc", "a", "bb", "dd"}},
+		{"custom", byLength, []interface{}{"a", "bb", "dd", "ccc"}},
+	} {
+		m := NewMultiTree(IntComparator)
+		m.SetPayloadOrder(tc.less)
+		for _, e := range []Entry{{1, "ccc"}, {2, "x"}, {1, "a"}, {1, "bb"}, {1, "dd"}} {
+			if err := m.Put(e.Key, e.Value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := m.GetAll(1); !reflect.DeepEqual(got, tc.want1) {
+			t.Errorf("%s: GetAll(1) = %v, want %v", tc.name, got, tc.want1)
+		}
+		want := []Entry{}
+		for _, v := range tc.want1 {
+			want = append(want, Entry{1, v})
+		}
+		want = append(want, Entry{2, "x"})
+		if got := m.RangeSearch(0, 5); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: RangeSearch = %v, want %v", tc.name, got, want)
+		}
+		if m.Size() != 5 {
+			t.Errorf("%s: Size() = %d, want 5", tc.name, m.Size())
+		}
+	}
+}
+
+func TestMultiTreeSetPayloadOrderResorts(t *testing.T) {
+	m := NewMultiTree(IntComparator)
+	for _, v := range []int{3, 1, 2} {
+		m.Put(7, v)
+	}
+	m.SetPayloadOrder(func(a, b interface{}) bool { return a.(int) < b.(int) })
+	if got := m.GetAll(7); !reflect.DeepEqual(got, []interface{}{1, 2, 3}) {
+		t.Errorf("GetAll(7) = %v after SetPayloadOrder", got)
+	}
+	m.Delete(7)
+	if m.Size() != 0 || m.Has(7) {
+		t.Errorf("Size() = %d, Has(7) = %v after Delete", m.Size(), m.Has(7))
+	}
+}
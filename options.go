@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EvictionPolicy selects which entry a size-capped tree drops to make room.
+type EvictionPolicy byte
+
+const (
+	EvictMin EvictionPolicy = iota // drop the smallest key
+	EvictMax                       // drop the largest key
+)
+
+// Options tunes a Tree created with NewTreeWithOptions.
+// The zero value gives the behavior of NewTreeWith.
+type Options struct {
+	// MaxSize caps the number of entries; 0 means unbounded. When a Put of
+	// a new key would exceed it, an entry chosen by Eviction is removed
+	// first. Overwriting an existing key never evicts.
+	MaxSize  uint64
+	Eviction EvictionPolicy
+	// OnEvict, if set, is called with every evicted entry once the Put
+	// that evicted it has succeeded. A Put that fails (see MaxHeight and
+	// Tree.Journal) puts the entry back and reports nothing.
+	OnEvict func(key, value interface{})
+	// MaxHeight, if not 0, makes Put fail with ErrorHeightExceeded instead
+	// of adding a key that leaves the tree higher than this many levels.
+	// A valid red-black tree of n keys is at most 2*log2(n+1) high, so this
+	// is a tripwire for corrupted trees; each insert then costs a full walk.
+	MaxHeight int
+	// Strict makes RotateLeft and RotateRight panic with
+	// ErrorInvalidRotation when handed a nil node or one lacking the child
+	// to rotate with, instead of silently doing nothing. The rebalancing
+	// code never does that, so a panic points at a corrupted tree or at an
+	// outside caller of the rotations, which can't keep the tree valid
+	// anyway. Meant for tests and debugging.
+	Strict bool
+	// ReplaceOverwrites lets ReplaceKey move an entry onto a key that is
+	// already present, replacing its payload, instead of failing.
+	ReplaceOverwrites bool
+	// SwapInvertedBounds makes range queries swap a lower bound above the
+	// upper one instead of failing with ErrorInvalidRange.
+	SwapInvertedBounds bool
+	// NegativeFilter keeps a Bloom filter of the keys so that Get and Has
+	// answer most misses without descending the tree. It never causes a
+	// false negative; deleted keys linger in it as false positives until
+	// enough deletions trigger a rebuild. The filter is built by the first
+	// write; until then, and after a wholesale load such as FromSorted,
+	// lookups go to the tree.
+	NegativeFilter bool
+	// KeyHash hashes keys for NegativeFilter; nil hashes ints, strings and
+	// time.Time instants directly and other keys by their %#v formatting.
+	// Keys equal according to the comparator must hash equal, so set it
+	// for comparators that equate distinct values, such as
+	// case-insensitive ones.
+	KeyHash func(key interface{}) uint64
+	// Loader, if set, turns the tree into a read-through cache: Get and
+	// GetOrLoad hand keys missing from the tree to it and save what it
+	// finds. See GetOrLoad.
+	Loader Loader
+	// AbsentTTL, if positive, makes the tree remember for this long that
+	// the loader found no value for a key, so that further misses of it
+	// don't reach the loader. Tombstones are kept apart from the entries.
+	AbsentTTL time.Duration
+	// Writer, if set, is called with every Put and Delete before the tree
+	// changes; see Writer.
+	Writer Writer
+	// TombstoneHorizon is for how many versions ChangesSince keeps
+	// reporting a deleted key; 0 keeps none, so that every Delete makes
+	// ChangesSince fail for the versions before it. See ChangesSince.
+	TombstoneHorizon uint64
+	// SkipValidation makes UnmarshalJSON and ReadSnapshotWithOptions trust
+	// their input. Otherwise the loaded tree is checked with
+	// CheckInvariants, a walk of the whole tree, before it is returned, so
+	// that a damaged or hand-edited file fails to load instead of yielding
+	// a tree that misbehaves much later.
+	SkipValidation bool
+	// PayloadEquals compares payloads for Diff and Equal, and for
+	// CompareAndSwap and CompareAndDelete unless they are given a func;
+	// nil means reflect.DeepEqual.
+	PayloadEquals func(a, b interface{}) bool
+	// Now is the clock deciding whether entries saved with PutTTL have
+	// expired; nil means time.Now.
+	Now func() time.Time
+}
+
+var ErrorHeightExceeded = errors.New("Tree would exceed its maximum height")
+
+// guardHeight takes the just added `key` out again if the tree got higher
+// than Options.MaxHeight.
+func (t *Tree) guardHeight(key interface{}) error {
+	if t.opts.MaxHeight <= 0 {
+		return nil
+	}
+	if h := t.Height(); h > t.opts.MaxHeight {
+		t.remove(key)
+		return fmt.Errorf("%w: %d levels with key %#v, at most %d allowed", ErrorHeightExceeded, h, key, t.opts.MaxHeight)
+	}
+	return nil
+}
+
+// Height returns the number of levels of the tree: 0 if it is empty, 1 for
+// a single node. A red-black tree of n keys is at least ceil(log2(n+1))
+// and at most 2*log2(n+1) high; see Compact.
+func (t *Tree) Height() int {
+	if t == nil {
+		return 0
+	}
+	height, depth := 0, 0
+	traverse(t.Root, func(step walkStep, n *Node) {
+		switch step {
+		case stepPre:
+			if depth++; depth > height {
+				height = depth
+			}
+		case stepPost:
+			depth--
+		}
+	})
+	return height
+}
+
+// evictFor makes room for inserting `key` into a size-capped tree and
+// returns the inverse of the eviction, nil if there was none. The caller
+// rolls the eviction back with it if the insert fails; otherwise it
+// journals the eviction like a Delete and reports it with reportEviction.
+func (t *Tree) evictFor(key interface{}) *undoRecord {
+	if t.opts.MaxSize == 0 || mustBeValidKey(key) != nil || t.Size() < t.opts.MaxSize {
+		return nil
+	}
+	if found, _ := t.getNode(key); found {
+		return nil
+	}
+	victim, ok := t.Min()
+	if t.opts.Eviction == EvictMax {
+		victim, ok = t.Max()
+	}
+	if !ok {
+		return nil
+	}
+	inverse := t.captureInverse(victim.Key)
+	t.remove(victim.Key)
+	t.tracef(LevelOp, "Evicted %v to make room for %v\n", victim.Key, key)
+	return inverse
+}
+
+// reportEviction hands the entry whose eviction `inverse` undoes to
+// Options.OnEvict, once the insert that caused it has succeeded.
+func (t *Tree) reportEviction(inverse *undoRecord) {
+	if inverse != nil && t.opts.OnEvict != nil {
+		t.opts.OnEvict(inverse.key, inverse.payload)
+	}
+}
+
+// DeleteMin removes the entry with the smallest key and returns it,
+// or false if the tree is empty.
+func (t *Tree) DeleteMin() (Entry, bool) {
+	e, ok := t.Min()
+	if !ok || t.Delete(e.Key) != nil {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// DeleteMax removes the entry with the largest key and returns it,
+// or false if the tree is empty.
+func (t *Tree) DeleteMax() (Entry, bool) {
+	e, ok := t.Max()
+	if !ok || t.Delete(e.Key) != nil {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// DeleteIf removes every entry for which pred returns true and returns how
+// many were removed. The matching keys are collected by one walk first and
+// then deleted one by one, so pred never sees a tree being restructured.
+// A failing Delete (see Options.Writer) is traced and skipped; its entry
+// stays and is not counted.
+func (t *Tree) DeleteIf(pred func(key, payload interface{}) bool) int {
+	if t.mutable("DeleteIf") != nil {
+		return 0
+	}
+	defer t.beginOp("deleteif")()
+	var doomed []interface{}
+	t.rangeWalk(nil, nil, func(n *Node) bool {
+		if pred(n.Key, n.payload) {
+			doomed = append(doomed, n.Key)
+		}
+		return true
+	})
+	removed := 0
+	for _, key := range doomed {
+		if err := t.Delete(key); err != nil {
+			t.tracef(LevelError, "DeleteIf: %s\n", err.Error())
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// PutAll saves the entries in order, as a sequence of Put calls, and stops
+// at the first error. On a size-capped tree each new key may evict an
+// earlier one, including one saved by the same batch.
+func (t *Tree) PutAll(entries []Entry) error {
+	defer t.beginOp("putall")()
+	for _, e := range entries {
+		if err := t.Put(e.Key, e.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
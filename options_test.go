@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMaxSizeEviction(t *testing.T) {
+	for _, tc := range []struct {
+		policy  EvictionPolicy
+		keys    []interface{}
+		evicted []interface{}
+	}{
+		{EvictMin, []interface{}{6, 7, 8, 9}, []interface{}{0, 1, 2, 3, 4, 5}},
+		{EvictMax, []interface{}{0, 1, 2, 9}, []interface{}{3, 4, 5, 6, 7, 8}},
+	} {
+		var evicted []interface{}
+		tree := NewTreeWithOptions(IntComparator, Options{
+			MaxSize:  4,
+			Eviction: tc.policy,
+			OnEvict: func(key, value interface{}) {
+				if key != value {
+					t.Errorf("OnEvict(%v, %v): value doesn't match", key, value)
+				}
+				evicted = append(evicted, key)
+			},
+		})
+		for k := 0; k < 10; k++ {
+			if err := tree.Put(k, k); err != nil {
+				t.Fatal(err)
+			}
+			// overwrites never evict
+			if err := tree.Put(k, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := tree.Keys(); !reflect.DeepEqual(got, tc.keys) {
+			t.Errorf("policy %d: kept %v, want %v", tc.policy, got, tc.keys)
+		}
+		if !reflect.DeepEqual(evicted, tc.evicted) {
+			t.Errorf("policy %d: evicted %v, want %v", tc.policy, evicted, tc.evicted)
+		}
+		if tree.Size() != 4 {
+			t.Errorf("policy %d: Size() = %d, want 4", tc.policy, tree.Size())
+		}
+		checkRedBlack(t, tree)
+	}
+}
+
+func TestPutAllEvictsWithinBatch(t *testing.T) {
+	evictions := 0
+	tree := NewTreeWithOptions(IntComparator, Options{MaxSize: 3, OnEvict: func(key, value interface{}) {
+		evictions++
+	}})
+	var batch []Entry
+	for k := 10; k > 0; k-- {
+		batch = append(batch, Entry{Key: k, Value: k})
+	}
+	if err := tree.PutAll(batch); err != nil {
+		t.Fatal(err)
+	}
+	// with EvictMin each new, smaller key evicts the last one put
+	if got := tree.Keys(); !reflect.DeepEqual(got, []interface{}{1, 9, 10}) {
+		t.Errorf("Keys() = %v, want [1 9 10]", got)
+	}
+	if evictions != 7 || tree.Size() != 3 {
+		t.Errorf("%d evictions, Size() = %d, want 7 and 3", evictions, tree.Size())
+	}
+}
+
+func TestFailedPutUndoesEviction(t *testing.T) {
+	evictions := 0
+	tree := NewTreeWithOptions(IntComparator, Options{MaxSize: 3, OnEvict: func(key, value interface{}) {
+		evictions++
+	}})
+	tree.PutAll([]Entry{{1, 1}, {2, 2}, {3, 3}})
+	for budget := 0; budget < 2; budget++ {
+		// fail the journal write of the eviction, then that of the put
+		tree.Journal = &failingWriter{budget: budget}
+		if err := tree.Put(4, 4); !errors.Is(err, errWriteFailed) {
+			t.Fatalf("budget %d: Put = %v, want the journal's error", budget, err)
+		}
+		if got := tree.Keys(); !reflect.DeepEqual(got, []interface{}{1, 2, 3}) {
+			t.Fatalf("budget %d: Keys() = %v after the failed Put", budget, got)
+		}
+	}
+	if evictions != 0 {
+		t.Errorf("OnEvict was called %d times for failed Puts", evictions)
+	}
+
+	tree.Journal = nil
+	tree.Apply(func(tx *Txn) error {
+		tx.Put(4, 4)
+		return errWriteFailed
+	})
+	if evictions != 0 || !tree.Has(1) {
+		t.Errorf("a rolled back Txn reported %d evictions, Has(1) = %v", evictions, tree.Has(1))
+	}
+	tree.Apply(func(tx *Txn) error {
+		return tx.Put(4, 4)
+	})
+	if evictions != 1 || tree.Has(1) {
+		t.Errorf("a committed Txn reported %d evictions, Has(1) = %v", evictions, tree.Has(1))
+	}
+}
+
+func TestDeleteMinMax(t *testing.T) {
+	tree := newIntTree(t, 5, 3, 8)
+	if e, ok := tree.DeleteMin(); !ok || e.Key != 3 {
+		t.Errorf("DeleteMin() = %v, %v", e, ok)
+	}
+	if e, ok := tree.DeleteMax(); !ok || e.Key != 8 {
+		t.Errorf("DeleteMax() = %v, %v", e, ok)
+	}
+	tree.DeleteMin()
+	if _, ok := tree.DeleteMax(); ok {
+		t.Error("DeleteMax() of an empty tree reported an entry")
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	even := func(key, payload interface{}) bool { return key.(int)%2 == 0 }
+	if removed := tree.DeleteIf(even); removed != 5 {
+		t.Errorf("DeleteIf removed %d entries, want 5", removed)
+	}
+	if got := tree.Keys(); !reflect.DeepEqual(got, []interface{}{1, 3, 5, 7, 9}) {
+		t.Errorf("kept %v", got)
+	}
+	checkRedBlack(t, tree)
+
+	// only the deletes that could be journaled count
+	tree.Journal = &failingWriter{budget: 2}
+	if removed := tree.DeleteIf(func(key, payload interface{}) bool { return true }); removed != 2 {
+		t.Errorf("DeleteIf removed %d entries with two journal writes allowed, want 2", removed)
+	}
+	if tree.Size() != 3 {
+		t.Errorf("Size() = %d, want 3", tree.Size())
+	}
+}
+
+func TestMaxHeight(t *testing.T) {
+	// 2*log2(n+1) bounds a red-black tree of n keys: 20 levels for 1000
+	const n = 1000
+	maxHeight := 2 * bits.Len(n)
+	for _, order := range []string{"ascending", "random"} {
+		tree := NewTreeWithOptions(IntComparator, Options{MaxHeight: maxHeight})
+		keys := rand.New(rand.NewSource(1)).Perm(n)
+		if order == "ascending" {
+			sort.Ints(keys)
+		}
+		for _, k := range keys {
+			if err := tree.Put(k, nil); err != nil {
+				t.Fatalf("%s: Put(%d): %s", order, k, err)
+			}
+		}
+		if h := tree.Height(); h > maxHeight {
+			t.Errorf("%s: height %d", order, h)
+		}
+		checkRedBlack(t, tree)
+	}
+}
+
+func TestMaxHeightTripsOnCorruptTree(t *testing.T) {
+	// a hand-built chain of 21 nodes, far higher than a red-black tree
+	var root *Node
+	for k := 21; k >= 1; k-- {
+		n := &Node{Key: k * 2, color: BLACK, Right: root}
+		if root != nil {
+			root.parent = n
+		}
+		root = n
+	}
+	tree := &Tree{Root: root, cmp: IntComparator, opts: Options{MaxHeight: 12}}
+	if err := tree.Put(1, nil); !errors.Is(err, ErrorHeightExceeded) {
+		t.Fatalf("Put = %v, want ErrorHeightExceeded", err)
+	}
+	if found, _ := tree.Get(1); found {
+		t.Error("the rejected key stayed in the tree")
+	}
+	if got := tree.Size(); got != 21 {
+		t.Errorf("Size() = %d, want 21", got)
+	}
+	// overwriting adds no level and is let through
+	if err := tree.Put(2, "two"); err != nil {
+		t.Errorf("overwriting Put = %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+// PersistentTree is an immutable red-black tree. Put and Delete leave the
+// receiver untouched and return a new version that shares every subtree the
+// operation did not touch, so each version costs O(log n) extra nodes and
+// old versions stay valid forever. Because nodes are shared between
+// versions they carry no parent pointers: Node.Parent returns nil.
+type PersistentTree struct {
+	root *Node
+	cmp  Comparator
+	size uint64
+}
+
+// NewPersistentTree returns an empty PersistentTree ordered by `c`.
+func NewPersistentTree(c Comparator) *PersistentTree {
+	return &PersistentTree{cmp: c}
+}
+
+// Get looks for the node with supplied key and returns its mapped payload.
+// Return value in 1st position indicates whether any payload was found.
+func (p *PersistentTree) Get(key interface{}) (bool, interface{}) {
+	if err := mustBeValidKey(key); err != nil {
+		logger.Printf("Get was prematurely aborted: %s\n", err.Error())
+		return false, nil
+	}
+	n := p.root
+	for n != nil {
+		switch c := p.cmp(key, n.Key); {
+		case c < 0:
+			n = n.Left
+		case c > 0:
+			n = n.Right
+		default:
+			return true, n.payload
+		}
+	}
+	return false, nil
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (p *PersistentTree) Has(key interface{}) bool {
+	found, _ := p.Get(key)
+	return found
+}
+
+// Size returns the number of items in this version.
+func (p *PersistentTree) Size() uint64 {
+	return p.size
+}
+
+// Walk accepts a Visitor
+func (p *PersistentTree) Walk(visitor Visitor) {
+	visitor.Visit(p.root)
+}
+
+// Put returns a version of the tree holding the mapping (key, data).
+func (p *PersistentTree) Put(key interface{}, data interface{}) (*PersistentTree, error) {
+	if err := mustBeValidKey(key); err != nil {
+		logger.Printf("Put was prematurely aborted: %s\n", err.Error())
+		return p, err
+	}
+	size := p.size
+	if !p.Has(key) {
+		size++
+	}
+	root := persistentInsert(p.root, key, data, p.cmp)
+	return &PersistentTree{root: blacken(root), cmp: p.cmp, size: size}, nil
+}
+
+// Delete returns a version of the tree without `key`. If `key` is absent
+// the receiver itself is returned.
+func (p *PersistentTree) Delete(key interface{}) *PersistentTree {
+	if !p.Has(key) {
+		return p
+	}
+	root := persistentDelete(p.root, key, p.cmp)
+	if root != nil {
+		root = blacken(root)
+	}
+	return &PersistentTree{root: root, cmp: p.cmp, size: p.size - 1}
+}
+
+// PutPersistent returns a new version of the tree holding the mapping
+// (key, data) and leaves the receiver untouched. Only the O(log n) nodes on
+// the search path are copied; every other subtree is shared by both trees,
+// which makes it a cheap way to keep old versions around.
+//
+// The copied nodes are linked to their parents in the new version. The
+// root of a shared subtree can't have a parent in each tree, so until a
+// tree is modified in place again its Parent (and the Sibling, GrandParent
+// and Uncle derived from it) is nil in both. The first Put, Insert,
+// SetValue or Delete on either tree copies its nodes once and restores the
+// parent links; further PutPersistent calls stay O(log n).
+// The new version keeps the comparator, codec, key normalizer and options,
+// but not the journal, the undo history or the weights, and PutPersistent itself is
+// neither journaled nor recorded. An invalid key returns the receiver.
+func (t *Tree) PutPersistent(key, data interface{}) *Tree {
+	key = t.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "PutPersistent was prematurely aborted: %s\n", err.Error())
+		return t
+	}
+	size := t.Size()
+	if found, _ := t.getNode(key); !found {
+		size++
+	}
+	t.shared = true
+	root := blacken(persistentInsert(t.Root, key, data, t.compare))
+	linkCopies(root)
+	return &Tree{
+		Root:          root,
+		cmp:           t.cmp,
+		cmpName:       t.cmpName,
+		codec:         t.codec,
+		KeyNormalizer: t.KeyNormalizer,
+		opts:          t.opts,
+		size:          size,
+		sizeKnown:     true,
+		shared:        true,
+		version:       t.version + 1,
+		floor:         t.version + 1,
+	}
+}
+
+// linkCopies points the children of the nodes path copying built below
+// `n` back at them. Those have no parent yet; any other child is the root
+// of a subtree shared with the previous version and is marked as such.
+func linkCopies(n *Node) {
+	for _, child := range []*Node{n.Left, n.Right} {
+		switch {
+		case child == nil:
+		case child.parent == nil:
+			child.parent = n
+			linkCopies(child)
+		default:
+			child.shared = true
+		}
+	}
+}
+
+// own gives the tree exclusive nodes with valid parent links before it is
+// modified in place, copying them if they may be shared.
+func (t *Tree) own() {
+	if !t.shared {
+		return
+	}
+	t.Root = cloneNodes(t.Root, nil)
+	t.shared = false
+	t.mods++
+}
+
+// The functions below implement the functional red-black tree of Okasaki
+// (insertion) and Kahrs (deletion). They never modify a node: every node on
+// the search path is rebuilt with mk.
+
+func mk(color Color, left *Node, from *Node, right *Node) *Node {
+	return &Node{Key: from.Key, payload: from.payload, color: color, Left: left, Right: right, Leaf: from.Leaf, expires: from.expires, version: from.version}
+}
+
+func isBlackNode(n *Node) bool {
+	return n != nil && n.color == BLACK
+}
+
+func blacken(n *Node) *Node {
+	if n.color == BLACK {
+		return n
+	}
+	return mk(BLACK, n.Left, n, n.Right)
+}
+
+// redden turns a black node red; the caller guarantees it is black.
+func redden(n *Node) *Node {
+	if !isBlackNode(n) {
+		panic("redden: node is not black")
+	}
+	return mk(RED, n.Left, n, n.Right)
+}
+
+func balance(l *Node, x *Node, r *Node) *Node {
+	switch {
+	case isRed(l) && isRed(r):
+		return mk(RED, blacken(l), x, blacken(r))
+	case isRed(l) && isRed(l.Left):
+		return mk(RED, blacken(l.Left), l, mk(BLACK, l.Right, x, r))
+	case isRed(l) && isRed(l.Right):
+		return mk(RED, mk(BLACK, l.Left, l, l.Right.Left), l.Right, mk(BLACK, l.Right.Right, x, r))
+	case isRed(r) && isRed(r.Right):
+		return mk(RED, mk(BLACK, l, x, r.Left), r, blacken(r.Right))
+	case isRed(r) && isRed(r.Left):
+		return mk(RED, mk(BLACK, l, x, r.Left.Left), r.Left, mk(BLACK, r.Left.Right, r, r.Right))
+	default:
+		return mk(BLACK, l, x, r)
+	}
+}
+
+func persistentInsert(n *Node, key, data interface{}, cmp Comparator) *Node {
+	if n == nil {
+		return &Node{Key: key, payload: data, color: RED}
+	}
+	c := cmp(key, n.Key)
+	switch {
+	case c == 0:
+		return &Node{Key: n.Key, payload: data, color: n.color, Left: n.Left, Right: n.Right, Leaf: n.Leaf}
+	case n.color == BLACK && c < 0:
+		return balance(persistentInsert(n.Left, key, data, cmp), n, n.Right)
+	case n.color == BLACK:
+		return balance(n.Left, n, persistentInsert(n.Right, key, data, cmp))
+	case c < 0:
+		return mk(RED, persistentInsert(n.Left, key, data, cmp), n, n.Right)
+	default:
+		return mk(RED, n.Left, n, persistentInsert(n.Right, key, data, cmp))
+	}
+}
+
+func persistentDelete(n *Node, key interface{}, cmp Comparator) *Node {
+	if n == nil {
+		return nil
+	}
+	c := cmp(key, n.Key)
+	switch {
+	case c < 0 && isBlackNode(n.Left):
+		return balanceLeft(persistentDelete(n.Left, key, cmp), n, n.Right)
+	case c < 0:
+		return mk(RED, persistentDelete(n.Left, key, cmp), n, n.Right)
+	case c > 0 && isBlackNode(n.Right):
+		return balanceRight(n.Left, n, persistentDelete(n.Right, key, cmp))
+	case c > 0:
+		return mk(RED, n.Left, n, persistentDelete(n.Right, key, cmp))
+	default:
+		return fuse(n.Left, n.Right)
+	}
+}
+
+// balanceLeft rebalances x after its left subtree lost one black node.
+func balanceLeft(l *Node, x *Node, r *Node) *Node {
+	switch {
+	case isRed(l):
+		return mk(RED, blacken(l), x, r)
+	case isBlackNode(r):
+		return balance(l, x, redden(r))
+	default: // r is red with a black left child
+		return mk(RED, mk(BLACK, l, x, r.Left.Left), r.Left, balance(r.Left.Right, r, redden(r.Right)))
+	}
+}
+
+// balanceRight rebalances x after its right subtree lost one black node.
+func balanceRight(l *Node, x *Node, r *Node) *Node {
+	switch {
+	case isRed(r):
+		return mk(RED, l, x, blacken(r))
+	case isBlackNode(l):
+		return balance(redden(l), x, r)
+	default: // l is red with a black right child
+		return mk(RED, balance(redden(l.Left), l, l.Right.Left), l.Right, mk(BLACK, l.Right.Right, x, r))
+	}
+}
+
+// fuse joins the two subtrees of a removed node.
+func fuse(l *Node, r *Node) *Node {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case isRed(l) && isRed(r):
+		m := fuse(l.Right, r.Left)
+		if isRed(m) {
+			return mk(RED, mk(RED, l.Left, l, m.Left), m, mk(RED, m.Right, r, r.Right))
+		}
+		return mk(RED, l.Left, l, mk(RED, m, r, r.Right))
+	case !isRed(l) && !isRed(r):
+		m := fuse(l.Right, r.Left)
+		if isRed(m) {
+			return mk(RED, mk(BLACK, l.Left, l, m.Left), m, mk(BLACK, m.Right, r, r.Right))
+		}
+		return balanceLeft(l.Left, l, mk(BLACK, m, r, r.Right))
+	case isRed(r):
+		return mk(RED, fuse(l, r.Left), r, r.Right)
+	default:
+		return mk(RED, l.Left, l, fuse(l.Right, r))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// checkPersistent is checkRedBlack for the parentless nodes of a
+// PersistentTree.
+func checkPersistent(tb testing.TB, p *PersistentTree) {
+	tb.Helper()
+	if isRed(p.root) {
+		tb.Fatalf("root %v is red", p.root.Key)
+	}
+	var check func(n *Node, lo, hi interface{}) int
+	check = func(n *Node, lo, hi interface{}) int {
+		if n == nil {
+			return 1
+		}
+		if lo != nil && p.cmp(lo, n.Key) >= 0 || hi != nil && p.cmp(n.Key, hi) >= 0 {
+			tb.Fatalf("%v is out of order", n.Key)
+		}
+		if isRed(n) && (isRed(n.Left) || isRed(n.Right)) {
+			tb.Fatalf("red %v has a red child", n.Key)
+		}
+		left, right := check(n.Left, lo, n.Key), check(n.Right, n.Key, hi)
+		if left != right {
+			tb.Fatalf("black heights below %v differ: %d vs %d", n.Key, left, right)
+		}
+		if !isRed(n) {
+			left++
+		}
+		return left
+	}
+	check(p.root, nil, nil)
+}
+
+func TestPersistentTreeVersions(t *testing.T) {
+	rng := rand.New(rand.NewSource(613))
+	versions := []*PersistentTree{NewPersistentTree(IntComparator)}
+	contents := []map[int]int{{}}
+	for i := 0; i < 2000; i++ {
+		p, want := versions[len(versions)-1], map[int]int{}
+		for k, v := range contents[len(contents)-1] {
+			want[k] = v
+		}
+		k := rng.Intn(300)
+		if rng.Intn(3) == 0 {
+			p = p.Delete(k)
+			delete(want, k)
+		} else {
+			var err error
+			if p, err = p.Put(k, i); err != nil {
+				t.Fatal(err)
+			}
+			want[k] = i
+		}
+		checkPersistent(t, p)
+		versions, contents = append(versions, p), append(contents, want)
+	}
+	// every old version still holds exactly what it held
+	for i, p := range versions {
+		if p.Size() != uint64(len(contents[i])) {
+			t.Fatalf("version %d: Size() = %d, want %d", i, p.Size(), len(contents[i]))
+		}
+		for k, v := range contents[i] {
+			if found, got := p.Get(k); !found || got != v {
+				t.Fatalf("version %d: Get(%d) = %v, %v, want %d", i, k, found, got, v)
+			}
+		}
+	}
+}
+
+func TestPutPersistent(t *testing.T) {
+	var keys []int
+	for k := 0; k < 1000; k++ {
+		keys = append(keys, k*2)
+	}
+	original := newIntTree(t, keys...)
+	before := original.Items()
+	oldNodes := map[*Node]bool{}
+	traverse(original.Root, func(step walkStep, n *Node) {
+		if step == stepIn {
+			oldNodes[n] = true
+		}
+	})
+
+	version := original.PutPersistent(501, "new")
+	if got := original.Items(); !reflect.DeepEqual(got, before) {
+		t.Fatal("PutPersistent changed the original tree")
+	}
+	if found, v := version.Get(501); !found || v != "new" || version.Size() != 1001 {
+		t.Fatalf("new version: Get(501) = %v, %v; Size() = %d", found, v, version.Size())
+	}
+	checkRedBlack(t, version)
+
+	// only the search path is copied, and the copies link to their parents
+	copies := 0
+	var walk func(n, parent *Node)
+	walk = func(n, parent *Node) {
+		if n == nil {
+			return
+		}
+		if oldNodes[n] {
+			if parent != nil && !oldNodes[parent] && n.Parent() != nil {
+				t.Fatalf("shared %v reports parent %v", n.Key, n.Parent().Key)
+			}
+			return
+		}
+		copies++
+		if n.Parent() != parent {
+			t.Fatalf("copied %v does not point back to its parent", n.Key)
+		}
+		walk(n.Left, n)
+		walk(n.Right, n)
+	}
+	walk(version.Root, nil)
+	// a red-black tree of 1001 nodes is at most 2*log2(1002) < 20 high
+	if copies > 40 {
+		t.Errorf("%d nodes copied for a single insert", copies)
+	}
+
+	// changing either version in place leaves the other alone
+	version.Put(3, 3)
+	original.Delete(0)
+	checkRedBlack(t, version)
+	checkRedBlack(t, original)
+	if original.Has(501) || original.Has(3) || !version.Has(0) {
+		t.Error("an in-place change leaked into the other version")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+)
+
+// NewTreeWithPool returns an empty Tree with comparator `IntComparator`
+// whose nodes are recycled through a sync.Pool: nodes unlinked by Delete are
+// cleared and handed out again by later inserts, which relieves the garbage
+// collector under insert/delete churn.
+// Since a deleted node is reused, callers must not hold on to *Node values
+// obtained from such a tree across a Delete.
+func NewTreeWithPool() *Tree {
+	t := NewTree()
+	t.pool = &sync.Pool{New: func() interface{} { return new(Node) }}
+	return t
+}
+
+// newNode returns a red node with no children.
+func (t *Tree) newNode(key, payload interface{}, parent *Node) *Node {
+	if t.arena != nil {
+		n := t.arena.alloc()
+		n.Key, n.payload, n.parent = key, payload, parent
+		return n
+	}
+	if t.pool == nil {
+		return &Node{Key: key, payload: payload, parent: parent}
+	}
+	n := t.pool.Get().(*Node)
+	n.Key, n.payload, n.parent = key, payload, parent
+	return n
+}
+
+// freeNode recycles a node that was unlinked from the tree. All of its
+// fields are cleared first so the pool doesn't keep keys, payloads or
+// other nodes alive.
+func (t *Tree) freeNode(n *Node) {
+	if t.pool == nil {
+		return
+	}
+	*n = Node{}
+	t.pool.Put(n)
+}
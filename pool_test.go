@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPooledTreeChurn(t *testing.T) {
+	rng := rand.New(rand.NewSource(612))
+	tree := NewTreeWithPool()
+	present := map[int]bool{}
+	for i := 0; i < 5000; i++ {
+		k := rng.Intn(200)
+		if present[k] {
+			tree.Delete(k)
+			delete(present, k)
+		} else {
+			tree.Put(k, k)
+			present[k] = true
+		}
+	}
+	checkRedBlack(t, tree)
+	if tree.Size() != uint64(len(present)) {
+		t.Fatalf("Size() = %d, want %d", tree.Size(), len(present))
+	}
+	for k := range present {
+		if found, v := tree.Get(k); !found || v != k {
+			t.Fatalf("Get(%d) = %v, %v", k, found, v)
+		}
+	}
+}
+
+func benchmarkChurn(b *testing.B, tree *Tree) {
+	for k := 0; k < 1000; k++ {
+		tree.Put(k, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % 1000
+		tree.Delete(k)
+		tree.Put(k, nil)
+	}
+}
+
+func BenchmarkChurn(b *testing.B) {
+	benchmarkChurn(b, NewTree())
+}
+
+func BenchmarkChurnPooled(b *testing.B) {
+	benchmarkChurn(b, NewTreeWithPool())
+}
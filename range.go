@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// How many in-range nodes RangeSearchContext visits between two
+// checks of its context.
+const rangeCheckInterval = 64
+
+var (
+	ErrorInvalidRange     = errors.New("Lower bound is above upper bound")
+	ErrorComparatorFailed = errors.New("Comparator failed")
+)
+
+// rangeBounds normalizes and validates the bounds of a range query run by
+// `op`. A nil bound is open. Bounds the comparator cannot order, against
+// each other or against the keys of the tree, fail with
+// ErrorComparatorFailed instead of panicking mid-walk. Inverted bounds fail
+// with ErrorInvalidRange, or are swapped if Options.SwapInvertedBounds is
+// set; equal bounds are fine and select at most one key.
+func (t *Tree) rangeBounds(op string, low, high interface{}) (interface{}, interface{}, error) {
+	low, high = t.normalize(low), t.normalize(high)
+	err := func() error {
+		for _, bound := range []interface{}{low, high} {
+			if bound == nil {
+				continue
+			}
+			if err := mustBeValidKey(bound); err != nil {
+				return err
+			}
+			if !t.IsEmpty() {
+				if _, err := t.tryCompare(bound, t.Root.Key); err != nil {
+					return err
+				}
+			}
+		}
+		if low == nil || high == nil {
+			return nil
+		}
+		c, err := t.tryCompare(low, high)
+		if err != nil || c <= 0 {
+			return err
+		}
+		if t == nil || !t.opts.SwapInvertedBounds {
+			return fmt.Errorf("%w: [%#v, %#v]", ErrorInvalidRange, low, high)
+		}
+		low, high = high, low
+		return nil
+	}()
+	if err != nil {
+		t.tracef(LevelError, "%s was prematurely aborted: %s\n", op, err.Error())
+		return nil, nil, err
+	}
+	return low, high, nil
+}
+
+// tryCompare compares like the comparator of the tree, but turns a panic,
+// such as the failed type assertion of IntComparator handed a string, into
+// ErrorComparatorFailed.
+func (t *Tree) tryCompare(o1, o2 interface{}) (c int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: comparing %#v and %#v: %v", ErrorComparatorFailed, o1, o2, r)
+		}
+	}()
+	return t.compare(o1, o2), nil
+}
+
+// RangeSearch returns the keys within [low, high] in ascending order.
+// A nil bound leaves that side of the range open.
+// Like every range query of the tree (Fold, CountInRange,
+// ForEachInRange, Between, ...) it reports keys in strictly ascending
+// order, each once, even on hand-built trees, such as the range tree of
+// main, whose inner nodes repeat the keys of their leaves.
+//
+// It runs in O(log n + k) for k keys in range: the walk descends once to
+// the smallest key >= low, skipping every subtree below the range on the
+// way, then emits keys in order and stops at the first key above high,
+// never entering a subtree beyond it. The same holds for every range query
+// built on rangeWalk.
+func (t *Tree) RangeSearch(low, high interface{}) []interface{} {
+	keys, _ := t.RangeSearchContext(context.Background(), low, high)
+	return keys
+}
+
+// RangeClamped is RangeSearch that also reports the extent of the keys
+// actually found: the smallest and largest key within [low, high], which
+// is narrower than the request when the data doesn't fill it. All three
+// results are nil when nothing is in range.
+func (t *Tree) RangeClamped(low, high interface{}) (actualLow, actualHigh interface{}, keys []interface{}) {
+	keys = t.RangeSearch(low, high)
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	return keys[0], keys[len(keys)-1], keys
+}
+
+// RangeSearchContext is RangeSearch for long scans: it checks `ctx`
+// periodically and returns ctx.Err() with the keys gathered so far if the
+// context is cancelled before the scan completes. Invalid bounds fail as
+// described for CountInRange.
+func (t *Tree) RangeSearchContext(ctx context.Context, low, high interface{}) ([]interface{}, error) {
+	low, high, err := t.rangeBounds("RangeSearch", low, high)
+	if err != nil || t.IsEmpty() {
+		return []interface{}{}, err
+	}
+
+	keys := []interface{}{}
+	t.rangeWalk(low, high, func(n *Node) bool {
+		if len(keys)%rangeCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if last := len(keys) - 1; last >= 0 && t.compare(keys[last], n.Key) == 0 {
+			// keys come in ascending order, so a repeat follows its first copy
+			return true
+		}
+		keys = append(keys, n.Key)
+		return true
+	})
+	return keys, err
+}
+
+// Fold threads an accumulator through the entries within [lo, hi] in
+// ascending key order, starting from `init`, and returns the final
+// accumulator. It walks only the bounded range and materializes nothing,
+// so it serves counting and summing over a window:
+//
+//	total := t.Fold(a, b, 0, func(acc, key, value interface{}) interface{} {
+//		return acc.(int) + value.(int)
+//	})
+//
+// A nil bound leaves that side of the range open; invalid bounds, as
+// described for CountInRange, return `init` untouched.
+func (t *Tree) Fold(lo, hi interface{}, init interface{}, fn func(acc interface{}, key, value interface{}) interface{}) interface{} {
+	acc := init
+	t.checkedRangeWalk("Fold", lo, hi, func(n *Node) bool {
+		acc = fn(acc, n.Key, n.payload)
+		return true
+	})
+	return acc
+}
+
+// CountInRange returns the number of keys within [low, high]; a nil bound
+// leaves that side open. Like every range query of the tree it fails with
+// ErrorInvalidRange if low is above high (unless
+// Options.SwapInvertedBounds is set) and with ErrorComparatorFailed if the
+// comparator cannot order the bounds, e.g. because of mismatched types.
+func (t *Tree) CountInRange(low, high interface{}) (uint64, error) {
+	var count uint64
+	err := t.checkedRangeWalk("CountInRange", low, high, func(n *Node) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// ForEachInRange calls fn for each entry within [low, high] in ascending
+// key order, until fn returns false. fn must not modify the tree. Bounds
+// are validated as by CountInRange.
+func (t *Tree) ForEachInRange(low, high interface{}, fn func(key, payload interface{}) bool) error {
+	return t.checkedRangeWalk("ForEachInRange", low, high, func(n *Node) bool {
+		return fn(n.Key, n.payload)
+	})
+}
+
+// RangeChan streams the entries within [low, high] in ascending key order
+// over a channel that is closed after the last one, for consumers ranging
+// over it with `for e := range t.RangeChan(lo, hi)`. Entries are sent
+// unbuffered and the producing goroutine only exits once all of them were
+// received, so a consumer that may stop early should use RangeChanContext
+// and cancel the context instead.
+func (t *Tree) RangeChan(low, high interface{}) <-chan Entry {
+	return t.RangeChanContext(context.Background(), low, high, 0)
+}
+
+// RangeChanContext is RangeChan with a channel buffering up to `buffer`
+// entries ahead of the consumer, whose producing goroutine exits, closing
+// the channel, as soon as `ctx` is done. Invalid bounds (see CountInRange)
+// yield a channel closed at once. The tree must not be modified until the
+// channel is closed.
+func (t *Tree) RangeChanContext(ctx context.Context, low, high interface{}, buffer int) <-chan Entry {
+	entries := make(chan Entry, buffer)
+	low, high, err := t.rangeBounds("RangeChan", low, high)
+	if err != nil || t.IsEmpty() {
+		close(entries)
+		return entries
+	}
+	go func() {
+		defer close(entries)
+		t.checkedRangeWalk("RangeChan", low, high, func(n *Node) bool {
+			select {
+			case entries <- Entry{Key: n.Key, Value: n.payload}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return entries
+}
+
+// NodesInRange returns the live nodes with a key within [lo, hi], in
+// ascending order, so that their payloads can be updated in place with
+// Node.SetValue without looking each key up again. Bounds are validated as
+// by CountInRange; invalid ones yield no nodes. The slice is only valid
+// until the next structural change (Put of a new key, Delete, ...), which
+// may move or recycle nodes. Updates through it bypass the journal, the
+// undo history and weights. If the tree shared its nodes after
+// PutPersistent, it copies them first so that updates stay private.
+func (t *Tree) NodesInRange(lo, hi interface{}) []*Node {
+	nodes := []*Node{}
+	if t.IsEmpty() {
+		return nodes
+	}
+	t.own()
+	t.checkedRangeWalk("NodesInRange", lo, hi, func(n *Node) bool {
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+// DeleteRange removes every entry within [low, high] and returns how many
+// were removed. Bounds are validated as by CountInRange. Like DeleteIf it
+// collects the keys first, deletes them one by one and skips those whose
+// Delete fails; the first such error is returned along with the count.
+func (t *Tree) DeleteRange(low, high interface{}) (int, error) {
+	if err := t.mutable("DeleteRange"); err != nil {
+		return 0, err
+	}
+	defer t.beginOp("deleterange")()
+	var doomed []interface{}
+	err := t.checkedRangeWalk("DeleteRange", low, high, func(n *Node) bool {
+		doomed = append(doomed, n.Key)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var first error
+	for _, key := range doomed {
+		if err := t.Delete(key); err != nil {
+			t.tracef(LevelError, "DeleteRange: %s\n", err.Error())
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		removed++
+	}
+	return removed, first
+}
+
+// checkedRangeWalk validates the bounds with rangeBounds and then runs
+// rangeWalk, reporting repeated keys of range trees only once.
+func (t *Tree) checkedRangeWalk(op string, low, high interface{}, fn func(*Node) bool) error {
+	low, high, err := t.rangeBounds(op, low, high)
+	if err != nil || t.IsEmpty() {
+		return err
+	}
+	var last *Node
+	t.rangeWalk(low, high, func(n *Node) bool {
+		if last != nil && t.compare(last.Key, n.Key) == 0 {
+			return true
+		}
+		last = n
+		return fn(n)
+	})
+	return nil
+}
+
+// rangeWalk calls fn for each node with a key in [low, high], in ascending
+// order, until fn returns false. Nil bounds are open and expired nodes are
+// skipped. Subtrees entirely
+// outside the range are never entered, so the walk costs O(log n + k)
+// for k matching nodes.
+func (t *Tree) rangeWalk(low, high interface{}, fn func(*Node) bool) {
+	rangeWalkFrom(t.Root, low, high, t.compare, func(n *Node) bool {
+		return t.expired(n) || fn(n)
+	})
+}
+
+// rangeWalkFrom is rangeWalk over the subtree rooted at `root`. It only
+// needs every key of a left subtree to be <= the key of its parent and every
+// key of a right subtree to be >= it, so it also serves range trees whose
+// inner nodes repeat keys.
+func rangeWalkFrom(root *Node, low, high interface{}, cmp Comparator, fn func(*Node) bool) {
+	var stack []*Node
+	n := root
+	for {
+		for n != nil {
+			if low != nil && cmp(n.Key, low) < 0 {
+				// n and its left subtree are below the range
+				n = n.Right
+				continue
+			}
+			stack = append(stack, n)
+			n = n.Left
+		}
+		if len(stack) == 0 {
+			return
+		}
+		n = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if high != nil && cmp(n.Key, high) > 0 {
+			return
+		}
+		if !fn(n) {
+			return
+		}
+		n = n.Right
+	}
+}
+
+// acyclicRangeWalkFrom is rangeWalkFrom for hand-built trees, whose links
+// may be corrupted: it remembers every node it enters and fails with
+// ErrorCyclicTree, instead of looping forever, on reaching one twice.
+func acyclicRangeWalkFrom(root *Node, low, high interface{}, cmp Comparator, fn func(*Node) bool) error {
+	seen := map[*Node]bool{}
+	var stack []*Node
+	n := root
+	for {
+		for n != nil {
+			if seen[n] {
+				return fmt.Errorf("%w: %s is reached twice", ErrorCyclicTree, n)
+			}
+			seen[n] = true
+			if low != nil && cmp(n.Key, low) < 0 {
+				n = n.Right
+				continue
+			}
+			stack = append(stack, n)
+			n = n.Left
+		}
+		if len(stack) == 0 {
+			return nil
+		}
+		n = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if high != nil && cmp(n.Key, high) > 0 {
+			return nil
+		}
+		if !fn(n) {
+			return nil
+		}
+		n = n.Right
+	}
+}
+
+// RangeIntervals returns the keys within [low, high] as runs of
+// consecutive integers, each reported as its [start, end] pair:
+// keys {1, 2, 3, 7, 8} over [1, 10] yield [[1 3] [7 8]].
+// Warning: like `IntComparator`, it panics if a key in range is not an `int`.
+func (t *Tree) RangeIntervals(low, high interface{}) [][2]int {
+	intervals := [][2]int{}
+	for _, key := range t.RangeSearch(low, high) {
+		k := key.(int)
+		if last := len(intervals) - 1; last >= 0 && intervals[last][1] == k-1 {
+			intervals[last][1] = k
+			continue
+		}
+		intervals = append(intervals, [2]int{k, k})
+	}
+	return intervals
+}
+
+// Histogram counts the keys falling into each of the len(boundaries)+1
+// buckets delimited by `boundaries`: bucket 0 holds the keys below
+// boundaries[0], bucket i the keys in [boundaries[i-1], boundaries[i]) and
+// the last one the keys at or above the final boundary. The boundaries
+// must be strictly ascending according to the comparator of the tree;
+// boundaries it cannot compare fail with ErrorComparatorFailed.
+// The tree is walked once, in order. For example, the keys 1..100 split
+// by the boundaries 34 and 67 yield the counts [33 33 34].
+func (t *Tree) Histogram(boundaries []interface{}) ([]uint64, error) {
+	bounds := make([]interface{}, len(boundaries))
+	for i := range boundaries {
+		bounds[i] = t.normalize(boundaries[i])
+		if err := mustBeValidKey(bounds[i]); err != nil {
+			return nil, fmt.Errorf("boundary %d: %w", i, err)
+		}
+		if i == 0 {
+			continue
+		}
+		order, err := t.tryCompare(bounds[i-1], bounds[i])
+		if err != nil {
+			return nil, fmt.Errorf("boundary %d: %w", i, err)
+		}
+		if order >= 0 {
+			return nil, fmt.Errorf("boundary %d (%#v): %w", i, boundaries[i], ErrorEntriesUnsorted)
+		}
+	}
+
+	counts := make([]uint64, len(bounds)+1)
+	if t.IsEmpty() {
+		return counts, nil
+	}
+	if len(bounds) > 0 {
+		// the boundaries compare with each other, so one of them
+		// comparing with a key makes the walk safe
+		if _, err := t.tryCompare(bounds[0], t.Root.Key); err != nil {
+			t.tracef(LevelError, "Histogram was prematurely aborted: %s\n", err.Error())
+			return nil, fmt.Errorf("boundary 0: %w", err)
+		}
+	}
+	bucket := 0
+	t.rangeWalk(nil, nil, func(n *Node) bool {
+		for bucket < len(bounds) && t.compare(n.Key, bounds[bucket]) >= 0 {
+			bucket++
+		}
+		counts[bucket]++
+		return true
+	})
+	return counts, nil
+}
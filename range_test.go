@@ -0,0 +1,943 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRangeSearch(t *testing.T) {
+	tree := newIntTree(t, 50, 20, 80, 10, 30, 70, 90, 60)
+	for _, tc := range []struct {
+		low, high interface{}
+		want      []interface{}
+	}{
+		{20, 70, []interface{}{20, 30, 50, 60, 70}},
+		{21, 69, []interface{}{30, 50, 60}},
+		{nil, 30, []interface{}{10, 20, 30}},
+		{80, nil, []interface{}{80, 90}},
+		{91, nil, []interface{}{}},
+	} {
+		if got := tree.RangeSearch(tc.low, tc.high); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("RangeSearch(%v, %v) = %v, want %v", tc.low, tc.high, got, tc.want)
+		}
+	}
+}
+
+// cancelAfter is a context that reports cancellation once Err was
+// consulted `checks` times.
+type cancelAfter struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.checks <= 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestRangeSearchContextCancelledMidScan(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 10*rangeCheckInterval; k++ {
+		tree.Put(k, nil)
+	}
+	ctx := &cancelAfter{Context: context.Background(), checks: 2}
+	keys, err := tree.RangeSearchContext(ctx, nil, nil)
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(keys) != 2*rangeCheckInterval {
+		t.Fatalf("gathered %d keys before the cancellation, want %d", len(keys), 2*rangeCheckInterval)
+	}
+	for i, k := range keys {
+		if k != i {
+			t.Fatalf("keys[%d] = %v", i, k)
+		}
+	}
+}
+
+func TestRangeSearchContextAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	keys, err := newIntTree(t, 1, 2, 3).RangeSearchContext(ctx, nil, nil)
+	if err != context.Canceled || len(keys) != 0 {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+}
+
+func TestRangeIntervals(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 7, 8, 12, 14, 15)
+	for _, tc := range []struct {
+		low, high int
+		want      [][2]int
+	}{
+		{1, 10, [][2]int{{1, 3}, {7, 8}}},
+		{0, 20, [][2]int{{1, 3}, {7, 8}, {12, 12}, {14, 15}}},
+		{2, 14, [][2]int{{2, 3}, {7, 8}, {12, 12}, {14, 14}}},
+		{4, 6, [][2]int{}},
+	} {
+		if got := tree.RangeIntervals(tc.low, tc.high); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("RangeIntervals(%d, %d) = %v, want %v", tc.low, tc.high, got, tc.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	tree := newIntTree(t, 10, 20, 30)
+	for _, tc := range []struct {
+		key          int
+		lower, upper interface{}
+	}{
+		{5, 10, 10},
+		{10, 10, 20},
+		{25, 30, 30},
+		{30, 30, nil},
+		{31, nil, nil},
+	} {
+		lower, ok := tree.LowerBound(tc.key)
+		if ok != (tc.lower != nil) || ok && lower.Key != tc.lower {
+			t.Errorf("LowerBound(%d) = %v, %v, want %v", tc.key, lower.Key, ok, tc.lower)
+		}
+		upper, ok := tree.UpperBound(tc.key)
+		if ok != (tc.upper != nil) || ok && upper.Key != tc.upper {
+			t.Errorf("UpperBound(%d) = %v, %v, want %v", tc.key, upper.Key, ok, tc.upper)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tree := newIntTree(t, 10, 20, 30, 40, 50)
+	for _, tc := range []struct {
+		start, end int
+		want       []interface{}
+	}{
+		{10, 50, []interface{}{20, 30, 40}}, // both bounds present
+		{15, 45, []interface{}{20, 30, 40}}, // both absent
+		{10, 35, []interface{}{20, 30}},     // start present, end absent
+		{5, 40, []interface{}{10, 20, 30}},  // start absent, end present
+		{0, 100, []interface{}{10, 20, 30, 40, 50}},
+		{20, 30, []interface{}{}}, // adjacent keys
+		{30, 30, []interface{}{}},
+		{40, 20, []interface{}{}}, // inverted
+		{50, 60, []interface{}{}},
+	} {
+		got := []interface{}{}
+		for _, e := range tree.Between(tc.start, tc.end) {
+			if e.Value != e.Key {
+				t.Errorf("Between(%d, %d): entry %v", tc.start, tc.end, e)
+			}
+			got = append(got, e.Key)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Between(%d, %d) = %v, want %v", tc.start, tc.end, got, tc.want)
+		}
+	}
+}
+
+func TestRangeBoundValidation(t *testing.T) {
+	// every range query runs the same bound check on a tree of 10..50
+	queries := map[string]func(tree *Tree, low, high interface{}) (int, error){
+		"CountInRange": func(tree *Tree, low, high interface{}) (int, error) {
+			n, err := tree.CountInRange(low, high)
+			return int(n), err
+		},
+		"ForEachInRange": func(tree *Tree, low, high interface{}) (int, error) {
+			n := 0
+			err := tree.ForEachInRange(low, high, func(key, payload interface{}) bool {
+				n++
+				return true
+			})
+			return n, err
+		},
+		"DeleteRange": func(tree *Tree, low, high interface{}) (int, error) {
+			return tree.DeleteRange(low, high)
+		},
+		"RangeSearchContext": func(tree *Tree, low, high interface{}) (int, error) {
+			keys, err := tree.RangeSearchContext(context.Background(), low, high)
+			return len(keys), err
+		},
+	}
+	for _, tc := range []struct {
+		name      string
+		low, high interface{}
+		swap      bool
+		want      int
+		err       error
+	}{
+		{"equal bounds on a key", 30, 30, false, 1, nil},
+		{"equal bounds between keys", 35, 35, false, 0, nil},
+		{"open low", nil, 25, false, 2, nil},
+		{"open high", 25, nil, false, 3, nil},
+		{"inverted", 40, 20, false, 0, ErrorInvalidRange},
+		{"inverted, swapped", 40, 20, true, 3, nil},
+		{"mismatched types", "a", 30, false, 0, ErrorComparatorFailed},
+		{"mismatched types, swapped", 30, "a", true, 0, ErrorComparatorFailed},
+		{"mismatched open bound", nil, "z", false, 0, ErrorComparatorFailed},
+	} {
+		for name, query := range queries {
+			tree := NewTreeWithOptions(IntComparator, Options{SwapInvertedBounds: tc.swap})
+			for k := 10; k <= 50; k += 10 {
+				tree.Put(k, k)
+			}
+			got, err := query(tree, tc.low, tc.high)
+			if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+				t.Errorf("%s: %s = %v, want %v", tc.name, name, err, tc.err)
+			}
+			if got != tc.want {
+				t.Errorf("%s: %s found %d keys, want %d", tc.name, name, got, tc.want)
+			}
+			if tc.err != nil && tree.Size() != 5 {
+				t.Errorf("%s: failed %s changed the tree", tc.name, name)
+			}
+		}
+	}
+}
+
+func TestDeleteRangeSkipsFailingDeletes(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 4, 5)
+	// the second of the four deletes can't be journaled
+	tree.Journal = &nthFailingWriter{fail: 2}
+	removed, err := tree.DeleteRange(2, 5)
+	if removed != 3 || !errors.Is(err, errWriteFailed) {
+		t.Fatalf("DeleteRange = %d, %v, want 3, errWriteFailed", removed, err)
+	}
+	if got := keysOf(tree); !reflect.DeepEqual(got, []interface{}{1, 3}) {
+		t.Errorf("keys left %v, want [1 3]", got)
+	}
+	checkRedBlack(t, tree)
+}
+
+func TestHistogram(t *testing.T) {
+	ints := NewTree()
+	for k := 0; k < 200; k += 3 {
+		ints.Put(k, nil)
+	}
+	strs := NewTreeWith(StringComparator)
+	for _, k := range []string{"apple", "banana", "cherry", "date", "fig", "grape", "kiwi", "lime"} {
+		strs.Put(k, nil)
+	}
+	for _, tc := range []struct {
+		tree       *Tree
+		boundaries []interface{}
+	}{
+		{ints, []interface{}{30, 60, 61, 62, 150}}, // on a key, empty buckets
+		{ints, []interface{}{-5, 500}},
+		{ints, nil},
+		{strs, []interface{}{"b", "cherry", "d", "e", "f", "z"}},
+		{NewTree(), []interface{}{1, 2}},
+	} {
+		// brute force: a key's bucket is the number of boundaries <= key
+		want := make([]uint64, len(tc.boundaries)+1)
+		for _, k := range tc.tree.Keys() {
+			bucket := 0
+			for _, b := range tc.boundaries {
+				if tc.tree.cmp(k, b) >= 0 {
+					bucket++
+				}
+			}
+			want[bucket]++
+		}
+		got, err := tc.tree.Histogram(tc.boundaries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Histogram(%v) = %v, want %v", tc.boundaries, got, want)
+		}
+	}
+	if _, err := ints.Histogram([]interface{}{5, 5}); !errors.Is(err, ErrorEntriesUnsorted) {
+		t.Errorf("repeated boundary: %v, want ErrorEntriesUnsorted", err)
+	}
+	// a lone boundary has no other to be compared with before the walk
+	for _, boundaries := range [][]interface{}{{"x"}, {5, "x"}} {
+		if _, err := ints.Histogram(boundaries); !errors.Is(err, ErrorComparatorFailed) {
+			t.Errorf("Histogram(%v) = %v, want ErrorComparatorFailed", boundaries, err)
+		}
+	}
+}
+
+func TestHistogramThreeBuckets(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 100; k++ {
+		tree.Put(k, nil)
+	}
+	boundaries := []interface{}{34, 67}
+	if got, err := tree.Histogram(boundaries); err != nil || !reflect.DeepEqual(got, []uint64{33, 33, 34}) {
+		t.Errorf("Histogram of 1..100 = %v, %v, want [33 33 34]", got, err)
+	}
+	var empty *Tree
+	if got, err := empty.Histogram(boundaries); err != nil || !reflect.DeepEqual(got, []uint64{0, 0, 0}) {
+		t.Errorf("Histogram of a nil tree = %v, %v, want [0 0 0]", got, err)
+	}
+}
+
+func TestHistogramAllocations(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 1000; k++ {
+		tree.Put(k, nil)
+	}
+	boundaries := []interface{}{100, 500, 900}
+	allocs := testing.AllocsPerRun(10, func() {
+		tree.Histogram(boundaries)
+	})
+	if allocs > 10 {
+		t.Errorf("Histogram made %v allocations over 1000 keys", allocs)
+	}
+}
+
+// leafRangeTree builds a leaf-based range tree over the ascending `keys`,
+// shaped like the one built by main: inner nodes repeat the largest key of
+// their left subtree.
+func leafRangeTree(keys []int) *Node {
+	if len(keys) == 1 {
+		return &Node{Key: keys[0], Leaf: true}
+	}
+	mid := (len(keys) + 1) / 2
+	return &Node{Key: keys[mid-1], Left: leafRangeTree(keys[:mid]), Right: leafRangeTree(keys[mid:])}
+}
+
+func TestRangeQueriesAgainstBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(620))
+	for i := 0; i < 1000; i++ {
+		present := map[int]bool{}
+		tree := NewTree()
+		for n := rng.Intn(60) + 1; n > 0; n-- {
+			k := rng.Intn(200)
+			present[k] = true
+			tree.Put(k, nil)
+		}
+		var sorted []int
+		for k := 0; k < 200; k++ {
+			if present[k] {
+				sorted = append(sorted, k)
+			}
+		}
+		x1 := rng.Intn(220) - 10
+		x2 := x1 + rng.Intn(100)
+
+		want := []int{}
+		for _, k := range sorted {
+			if k >= x1 && k <= x2 {
+				want = append(want, k)
+			}
+		}
+		var got []int
+		for _, k := range tree.RangeSearch(x1, x2) {
+			got = append(got, k.(int))
+		}
+		if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Fatalf("RangeSearch(%d, %d) = %v, want %v", x1, x2, got, want)
+		}
+
+		leaves := &Tree{Root: leafRangeTree(sorted), cmp: IntComparator}
+		if got, err := leaves.getValuesInRange(x1, x2, false); err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("getValuesInRange(%d, %d) over %v = %v, %v, want %v", x1, x2, sorted, got, err, want)
+		}
+	}
+}
+
+// exampleRangeTree rebuilds the leaf-based range tree of main, whose inner
+// nodes repeat the keys of leaves.
+func exampleRangeTree() *Tree {
+	leaf := func(k int) *Node { return &Node{Key: k, Leaf: true} }
+	inner := func(k int, left, right *Node) *Node { return &Node{Key: k, Left: left, Right: right} }
+	root := inner(49,
+		inner(23,
+			inner(10, inner(3, leaf(3), leaf(10)), inner(19, leaf(19), leaf(23))),
+			inner(37, inner(30, leaf(30), leaf(37)), leaf(49))),
+		inner(80,
+			inner(62, inner(59, leaf(59), leaf(62)), inner(70, leaf(70), leaf(80))),
+			inner(89, nil, inner(100, leaf(100), nil))))
+	return &Tree{Root: root, cmp: IntComparator}
+}
+
+func TestRangeSearchReportsKeysOnce(t *testing.T) {
+	tree := exampleRangeTree()
+	for _, tc := range []struct {
+		low, high int
+		want      []interface{}
+	}{
+		// the split node 49 is in range, and so is its leaf
+		{19, 77, []interface{}{19, 23, 30, 37, 49, 59, 62, 70}},
+		{15, 30, []interface{}{19, 23, 30}},
+		{0, 200, []interface{}{3, 10, 19, 23, 30, 37, 49, 59, 62, 70, 80, 89, 100}},
+	} {
+		if got := tree.RangeSearch(tc.low, tc.high); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("RangeSearch(%d, %d) = %v, want %v", tc.low, tc.high, got, tc.want)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tree := NewTree()
+	for k, name := range []string{"zero", "one", "two", "three", "four", "five"} {
+		tree.Put(k, name)
+	}
+	sum := tree.Fold(1, 4, 0, func(acc, key, payload interface{}) interface{} {
+		return acc.(int) + key.(int)
+	})
+	if sum != 10 {
+		t.Errorf("sum over [1, 4] = %v, want 10", sum)
+	}
+	joined := tree.Fold(1, 4, "", func(acc, key, payload interface{}) interface{} {
+		return acc.(string) + payload.(string) + ","
+	})
+	if joined != "one,two,three,four," {
+		t.Errorf("concatenation over [1, 4] = %q", joined)
+	}
+	if got := tree.Fold(7, 9, "init", func(acc, key, payload interface{}) interface{} {
+		return "touched"
+	}); got != "init" {
+		t.Errorf("empty range returned %v, want the initial value", got)
+	}
+
+	// against a sum over Items, with open and closed bounds
+	tree = NewTree()
+	for _, k := range rand.New(rand.NewSource(644)).Perm(500) {
+		tree.Put(k*2, k)
+	}
+	sumValues := func(acc, key, value interface{}) interface{} {
+		return acc.(int) + value.(int)
+	}
+	for _, r := range [][2]interface{}{{nil, nil}, {nil, 101}, {333, nil}, {100, 900}, {101, 101}, {102, 102}, {-5, 3}, {997, 2000}} {
+		want := 0
+		for _, e := range tree.Items() {
+			k := e.Key.(int)
+			if (r[0] == nil || k >= r[0].(int)) && (r[1] == nil || k <= r[1].(int)) {
+				want += e.Value.(int)
+			}
+		}
+		if got := tree.Fold(r[0], r[1], 0, sumValues); got != want {
+			t.Errorf("sum over [%v, %v] = %v, want %d", r[0], r[1], got, want)
+		}
+	}
+}
+
+func TestFirstNLastN(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 5; k++ {
+		tree.Put(k, k*10)
+	}
+	entries := func(keys ...int) []Entry {
+		out := []Entry{}
+		for _, k := range keys {
+			out = append(out, Entry{Key: k, Value: k * 10})
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		n           int
+		first, last []Entry
+	}{
+		{0, entries(), entries()},
+		{2, entries(1, 2), entries(4, 5)},
+		{5, entries(1, 2, 3, 4, 5), entries(1, 2, 3, 4, 5)},
+		{9, entries(1, 2, 3, 4, 5), entries(1, 2, 3, 4, 5)},
+	} {
+		if got := tree.FirstN(tc.n); !reflect.DeepEqual(got, tc.first) {
+			t.Errorf("FirstN(%d) = %v, want %v", tc.n, got, tc.first)
+		}
+		if got := tree.LastN(tc.n); !reflect.DeepEqual(got, tc.last) {
+			t.Errorf("LastN(%d) = %v, want %v", tc.n, got, tc.last)
+		}
+	}
+}
+
+func TestRangeResultsSortedAndUnique(t *testing.T) {
+	rng := rand.New(rand.NewSource(639))
+	for i := 0; i < 200; i++ {
+		tree := NewTree()
+		for n := rng.Intn(60) + 1; n > 0; n-- {
+			tree.Put(rng.Intn(200), nil)
+		}
+		var sorted []int
+		for _, k := range tree.Keys() {
+			sorted = append(sorted, k.(int))
+		}
+		// the leaf tree repeats every key of an inner node in a leaf
+		leaves := &Tree{Root: leafRangeTree(sorted), cmp: IntComparator}
+
+		for j := 0; j < 20; j++ {
+			low := rng.Intn(220) - 10
+			high := low + rng.Intn(100)
+			var inclusive, exclusive []int
+			for _, k := range sorted {
+				if k >= low && k <= high {
+					inclusive = append(inclusive, k)
+				}
+				if k > low && k < high {
+					exclusive = append(exclusive, k)
+				}
+			}
+			for _, tr := range []struct {
+				name string
+				tree *Tree
+			}{{"red-black", tree}, {"leaf", leaves}} {
+				check := func(api string, got []int, want []int) {
+					t.Helper()
+					for k := 1; k < len(got); k++ {
+						if got[k-1] >= got[k] {
+							t.Fatalf("%s tree: %s(%d, %d) = %v is not strictly ascending", tr.name, api, low, high, got)
+						}
+					}
+					if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+						t.Fatalf("%s tree: %s(%d, %d) = %v, want %v", tr.name, api, low, high, got, want)
+					}
+				}
+				var got []int
+				for _, k := range tr.tree.RangeSearch(low, high) {
+					got = append(got, k.(int))
+				}
+				check("RangeSearch", got, inclusive)
+
+				got = tr.tree.Fold(low, high, []int(nil), func(acc, key, payload interface{}) interface{} {
+					return append(acc.([]int), key.(int))
+				}).([]int)
+				check("Fold", got, inclusive)
+
+				got = nil
+				tr.tree.ForEachInRange(low, high, func(key, payload interface{}) bool {
+					got = append(got, key.(int))
+					return true
+				})
+				check("ForEachInRange", got, inclusive)
+
+				got = nil
+				for _, e := range tr.tree.Between(low, high) {
+					got = append(got, e.Key.(int))
+				}
+				check("Between", got, exclusive)
+
+				if count, err := tr.tree.CountInRange(low, high); err != nil || count != uint64(len(inclusive)) {
+					t.Fatalf("%s tree: CountInRange(%d, %d) = %d, %v, want %d", tr.name, low, high, count, err, len(inclusive))
+				}
+			}
+			if got, err := leaves.getValuesInRange(low, high, false); err != nil || len(got) != len(inclusive) || len(got) > 0 && !reflect.DeepEqual(got, inclusive) {
+				t.Fatalf("getValuesInRange(%d, %d) = %v, %v, want %v", low, high, got, err, inclusive)
+			}
+		}
+	}
+}
+
+func TestRangeSearchComparisons(t *testing.T) {
+	// O(log n + k): a range of k keys costs a descent plus about one
+	// few comparisons per key, however big the tree
+	for _, n := range []int{1000, 100000} {
+		var count int64
+		tree := NewTreeWith(CountingComparator(IntComparator, &count))
+		for k := 0; k < n; k++ {
+			tree.Put(k, nil)
+		}
+		depth := 2 * bits.Len(uint(n))
+		for _, k := range []int{0, 10, 500} {
+			low := n / 3
+			count = 0
+			if got := len(tree.RangeSearch(low, low+k-1)); got != k {
+				t.Fatalf("n=%d: RangeSearch found %d keys, want %d", n, got, k)
+			}
+			if limit := int64(4*depth + 4*k); count > limit {
+				t.Errorf("n=%d, k=%d: %d comparisons, want at most %d", n, k, count, limit)
+			}
+		}
+	}
+}
+
+func benchmarkRangeSearch(b *testing.B, n, width int) {
+	tree := NewTree()
+	for _, k := range rand.New(rand.NewSource(1)).Perm(n) {
+		tree.Put(k, nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		low := (i * 7919) % n
+		tree.RangeSearch(low, low+width-1)
+	}
+}
+
+func BenchmarkRangeSearch(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000, 1000000} {
+		for _, width := range []int{10, 1000} {
+			b.Run(fmt.Sprintf("n=%d/width=%d", n, width), func(b *testing.B) {
+				benchmarkRangeSearch(b, n, width)
+			})
+		}
+	}
+}
+
+func TestNodesInRange(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k)
+	}
+	nodes := tree.NodesInRange(10, 19)
+	if len(nodes) != 10 {
+		t.Fatalf("NodesInRange(10, 19) returned %d nodes, want 10", len(nodes))
+	}
+	for i, n := range nodes {
+		if n.Key != 10+i {
+			t.Fatalf("node %d has key %v, want %d", i, n.Key, 10+i)
+		}
+		n.SetValue(-n.Value().(int))
+	}
+	for k := 0; k < 100; k++ {
+		want := k
+		if k >= 10 && k <= 19 {
+			want = -k
+		}
+		if _, v := tree.Get(k); v != want {
+			t.Errorf("Get(%d) = %v, want %d", k, v, want)
+		}
+	}
+	if got := tree.NodesInRange("a", "b"); len(got) != 0 {
+		t.Errorf("NodesInRange with invalid bounds = %v", got)
+	}
+
+	// updates through a version made by PutPersistent stay private to it
+	version := tree.PutPersistent(100, 100)
+	for _, n := range version.NodesInRange(0, 100) {
+		n.SetValue("changed")
+	}
+	if _, v := tree.Get(50); v != 50 {
+		t.Errorf("original tree sees %v after updating the version", v)
+	}
+	if _, v := version.Get(50); v != "changed" {
+		t.Errorf("version has %v, want the update", v)
+	}
+}
+
+func TestFindFunc(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k*k)
+	}
+	var calls int
+	greaterThan := func(min int) func(key, value interface{}) bool {
+		return func(key, value interface{}) bool {
+			calls++
+			return value.(int) > min
+		}
+	}
+	lessThan := func(max int) func(key, value interface{}) bool {
+		return func(key, value interface{}) bool {
+			calls++
+			return value.(int) < max
+		}
+	}
+	for _, test := range []struct {
+		name      string
+		find      func(func(key, value interface{}) bool) (Entry, bool)
+		pred      func(key, value interface{}) bool
+		wantKey   interface{} // nil if there is no match
+		wantCalls int
+	}{
+		{"FindFunc minimum", tree.FindFunc, greaterThan(-1), 0, 1},
+		{"FindFunc middle", tree.FindFunc, greaterThan(2500), 51, 52},
+		{"FindFunc maximum", tree.FindFunc, greaterThan(98 * 98), 99, 100},
+		{"FindFunc absent", tree.FindFunc, greaterThan(99 * 99), nil, 100},
+		{"FindLastFunc maximum", tree.FindLastFunc, lessThan(100 * 100), 99, 1},
+		{"FindLastFunc middle", tree.FindLastFunc, lessThan(2500), 49, 51},
+		{"FindLastFunc minimum", tree.FindLastFunc, lessThan(1), 0, 100},
+		{"FindLastFunc absent", tree.FindLastFunc, lessThan(0), nil, 100},
+	} {
+		calls = 0
+		e, found := test.find(test.pred)
+		if test.wantKey == nil {
+			if found {
+				t.Errorf("%s: found %v, want no match", test.name, e)
+			}
+		} else if !found || e.Key != test.wantKey || e.Value != e.Key.(int)*e.Key.(int) {
+			t.Errorf("%s = %v, %v, want key %v", test.name, e, found, test.wantKey)
+		}
+		if calls != test.wantCalls {
+			t.Errorf("%s called pred %d times, want %d", test.name, calls, test.wantCalls)
+		}
+	}
+
+	var empty *Tree
+	if _, found := empty.FindFunc(greaterThan(0)); found {
+		t.Error("FindFunc found an entry in a nil tree")
+	}
+	if _, found := empty.FindLastFunc(lessThan(0)); found {
+		t.Error("FindLastFunc found an entry in a nil tree")
+	}
+}
+
+// nextPanic calls it.Next and returns what it panicked with, if anything.
+func nextPanic(it *Iterator) (recovered interface{}) {
+	defer func() { recovered = recover() }()
+	it.Next()
+	return nil
+}
+
+func TestIteratorDetectsModification(t *testing.T) {
+	for name, mutate := range map[string]func(tree *Tree){
+		"Put of a new key":   func(tree *Tree) { tree.Put(1000, nil) },
+		"Delete":             func(tree *Tree) { tree.Delete(50) },
+		"DeleteRange":        func(tree *Tree) { tree.DeleteRange(10, 20) },
+		"Compact":            func(tree *Tree) { tree.Compact() },
+		"BulkPut of new key": func(tree *Tree) { tree.BulkPut([]Entry{{Key: -1}}) },
+	} {
+		for _, start := range []interface{}{nil, 30} {
+			tree := NewTree()
+			for k := 0; k < 100; k++ {
+				tree.Put(k, k)
+			}
+			it := tree.Iterator()
+			if start != nil {
+				it = tree.IteratorFrom(start)
+			}
+			it.Next()
+			mutate(tree)
+			if got := nextPanic(it); got != ErrorTreeModified {
+				t.Errorf("%s during iteration from %v: Next panicked with %v, want ErrorTreeModified", name, start, got)
+			}
+		}
+	}
+
+	// overwriting payloads is not a structural change
+	tree := newIntTree(t, 1, 2, 3, 4, 5)
+	it := tree.Iterator()
+	var keys []interface{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+		tree.Put(it.Key(), "new")
+		tree.SetValue(it.Key(), "newer")
+	}
+	if !reflect.DeepEqual(keys, []interface{}{1, 2, 3, 4, 5}) {
+		t.Errorf("iteration while overwriting payloads saw %v", keys)
+	}
+}
+
+func TestMinMaxInRange(t *testing.T) {
+	tree := NewTree()
+	for k := 10; k <= 100; k += 10 {
+		tree.Put(k, k*2)
+	}
+	for _, tc := range []struct {
+		lo, hi   interface{}
+		min, max interface{} // nil if the range is empty
+	}{
+		{30, 30, 30, 30},   // exactly one key
+		{25, 35, 30, 30},   // one key, bounds between keys
+		{31, 39, nil, nil}, // between two adjacent keys
+		{nil, 45, 10, 40},
+		{45, nil, 50, 100},
+		{nil, nil, 10, 100},
+		{nil, 5, nil, nil},
+		{101, nil, nil, nil},
+		{70, 20, nil, nil},  // inverted
+		{"a", 50, nil, nil}, // wrong key type
+	} {
+		for _, side := range []struct {
+			name string
+			find func(lo, hi interface{}) (Entry, bool)
+			want interface{}
+		}{{"MinInRange", tree.MinInRange, tc.min}, {"MaxInRange", tree.MaxInRange, tc.max}} {
+			e, found := side.find(tc.lo, tc.hi)
+			if side.want == nil {
+				if found {
+					t.Errorf("%s(%v, %v) = %v, want none", side.name, tc.lo, tc.hi, e)
+				}
+			} else if !found || e.Key != side.want || e.Value != side.want.(int)*2 {
+				t.Errorf("%s(%v, %v) = %v, %v, want key %v", side.name, tc.lo, tc.hi, e, found, side.want)
+			}
+		}
+	}
+	if _, found := NewTree().MinInRange(nil, nil); found {
+		t.Error("MinInRange found an entry in an empty tree")
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	tree := NewTree()
+	for k := 10; k <= 50; k += 10 {
+		tree.Put(k, -k)
+	}
+	lookups := []struct {
+		name string
+		find func(key interface{}) (Entry, bool)
+	}{{"Ceiling", tree.Ceiling}, {"Floor", tree.Floor}, {"Higher", tree.Higher}, {"Lower", tree.Lower}}
+	for _, tc := range []struct {
+		key  int
+		want [4]interface{} // Ceiling, Floor, Higher, Lower; nil for none
+	}{
+		{30, [4]interface{}{30, 30, 40, 20}}, // an existing key
+		{35, [4]interface{}{40, 30, 40, 30}}, // between keys
+		{10, [4]interface{}{10, 10, 20, nil}},
+		{50, [4]interface{}{50, 50, nil, 40}},
+		{5, [4]interface{}{10, nil, 10, nil}}, // beyond the extremes
+		{55, [4]interface{}{nil, 50, nil, 50}},
+	} {
+		for i, lookup := range lookups {
+			e, found := lookup.find(tc.key)
+			if want := tc.want[i]; want == nil {
+				if found {
+					t.Errorf("%s(%d) = %v, want none", lookup.name, tc.key, e)
+				}
+			} else if !found || e.Key != want || e.Value != -want.(int) {
+				t.Errorf("%s(%d) = %v, %v, want key %v", lookup.name, tc.key, e, found, want)
+			}
+		}
+	}
+
+	empty := NewTree()
+	for _, find := range []func(key interface{}) (Entry, bool){empty.Ceiling, empty.Floor, empty.Higher, empty.Lower} {
+		if e, found := find(1); found {
+			t.Errorf("lookup in an empty tree found %v", e)
+		}
+	}
+}
+
+func TestNeighborsInOneDescent(t *testing.T) {
+	var count int64
+	tree := NewTreeWith(CountingComparator(IntComparator, &count))
+	for k := 0; k < 1000; k += 10 {
+		tree.Put(k, k)
+	}
+	height := tree.Height()
+	for key := -5; key <= 1005; key += 5 {
+		count = 0
+		prev, prevOK, next, nextOK := tree.Neighbors(key)
+		if count > int64(height) {
+			t.Fatalf("Neighbors(%d) made %d comparisons in a tree of height %d", key, count, height)
+		}
+		lower, lowerOK := tree.Lower(key)
+		higher, higherOK := tree.Higher(key)
+		if prev != lower || prevOK != lowerOK || next != higher || nextOK != higherOK {
+			t.Fatalf("Neighbors(%d) = %v, %v, %v, %v, want Lower %v, %v and Higher %v, %v",
+				key, prev, prevOK, next, nextOK, lower, lowerOK, higher, higherOK)
+		}
+	}
+
+	// the extremes, present and absent
+	if _, prevOK, next, _ := tree.Neighbors(0); prevOK || next.Key != 10 {
+		t.Errorf("Neighbors(0): prev found %v, next %v", prevOK, next)
+	}
+	if prev, _, _, nextOK := tree.Neighbors(990); nextOK || prev.Key != 980 {
+		t.Errorf("Neighbors(990): next found %v, prev %v", nextOK, prev)
+	}
+	if _, prevOK, _, nextOK := NewTree().Neighbors(1); prevOK || nextOK {
+		t.Error("Neighbors found entries in an empty tree")
+	}
+}
+
+func TestRangeClamped(t *testing.T) {
+	tree := NewTree()
+	for k := 20; k <= 60; k += 10 {
+		tree.Put(k, nil)
+	}
+	for _, tc := range []struct {
+		low, high         interface{}
+		wantLow, wantHigh interface{}
+		wantKeys          []interface{}
+	}{
+		{0, 100, 20, 60, []interface{}{20, 30, 40, 50, 60}}, // wider than the data
+		{25, 100, 30, 60, []interface{}{30, 40, 50, 60}},
+		{nil, 45, 20, 40, []interface{}{20, 30, 40}},
+		{40, 40, 40, 40, []interface{}{40}},
+		{41, 49, nil, nil, nil},
+		{70, 100, nil, nil, nil},
+	} {
+		low, high, keys := tree.RangeClamped(tc.low, tc.high)
+		if low != tc.wantLow || high != tc.wantHigh || !reflect.DeepEqual(keys, tc.wantKeys) {
+			t.Errorf("RangeClamped(%v, %v) = %v, %v, %v, want %v, %v, %v",
+				tc.low, tc.high, low, high, keys, tc.wantLow, tc.wantHigh, tc.wantKeys)
+		}
+	}
+}
+
+func TestRangeChan(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k*k)
+	}
+	var keys []interface{}
+	for e := range tree.RangeChan(10, 59) {
+		if e.Value != e.Key.(int)*e.Key.(int) {
+			t.Fatalf("entry %v has the wrong payload", e)
+		}
+		keys = append(keys, e.Key)
+	}
+	if want := tree.RangeSearch(10, 59); !reflect.DeepEqual(keys, want) {
+		t.Errorf("RangeChan streamed %v, want %v", keys, want)
+	}
+	for _, ch := range []<-chan Entry{NewTree().RangeChan(nil, nil), tree.RangeChan(20, 10), tree.RangeChan("a", 5)} {
+		if e, open := <-ch; open {
+			t.Errorf("RangeChan with nothing to stream sent %v", e)
+		}
+	}
+}
+
+func TestRangeChanContextAbort(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 10000; k++ {
+		tree.Put(k, nil)
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := tree.RangeChanContext(ctx, nil, nil, 4)
+	for i := 0; i < 10; i++ {
+		if e := <-ch; e.Key != i {
+			t.Fatalf("entry %d has key %v", i, e.Key)
+		}
+	}
+	cancel()
+	// the producer stops soon and closes the channel
+	received := 0
+	for range ch {
+		received++
+	}
+	if received > 100 {
+		t.Errorf("%d entries arrived after cancelling", received)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("producer still running: %d goroutines, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetValuesInRangeCycles(t *testing.T) {
+	// root 4 over inner 2 over inner 1; pointing 2 back at the root traps
+	// the split descent of any range at or below 2
+	split := &Tree{Root: leafRangeTree([]int{1, 2, 3, 4, 5, 6, 7, 8}), cmp: IntComparator}
+	split.Root.Left.Left = split.Root
+	if _, err := split.getValuesInRange(0, 1, false); !errors.Is(err, ErrorCyclicTree) {
+		t.Errorf("cycle on the split path: err = %v, want ErrorCyclicTree", err)
+	}
+
+	// [1, 8] splits at the root; the walk below it meets the cycle
+	walk := &Tree{Root: leafRangeTree([]int{1, 2, 3, 4, 5, 6, 7, 8}), cmp: IntComparator}
+	last := walk.Root
+	for last.Right != nil {
+		last = last.Right
+	}
+	last.Left = walk.Root.Left
+	if _, err := walk.getValuesInRange(1, 8, false); !errors.Is(err, ErrorCyclicTree) {
+		t.Errorf("cycle below the split node: err = %v, want ErrorCyclicTree", err)
+	}
+}
+
+func TestGetValuesInRangeSkewed(t *testing.T) {
+	// a chain of 200k inner nodes, each with a leaf on its left: deep
+	// enough to overflow a recursive descent
+	const n = 200000
+	root := &Node{Key: n, Leaf: true}
+	for k := n - 1; k > 0; k-- {
+		root = &Node{Key: k, Left: &Node{Key: k, Leaf: true}, Right: root}
+	}
+	tree := &Tree{Root: root, cmp: IntComparator}
+	if got, err := tree.getValuesInRange(n-1, n, false); err != nil || !reflect.DeepEqual(got, []int{n - 1, n}) {
+		t.Fatalf("getValuesInRange(%d, %d) = %v, %v", n-1, n, got, err)
+	}
+}
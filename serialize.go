@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MarshalEntriesJSON encodes the tree as a flat JSON array of
+// `{"key": ..., "value": ...}` objects in ascending key order.
+// Unlike the nested node JSON, the output does not depend on the shape of
+// the tree: two trees holding the same mappings marshal identically.
+func (t *Tree) MarshalEntriesJSON() ([]byte, error) {
+	return json.Marshal(t.Items())
+}
+
+// UnmarshalEntriesJSON rebuilds a balanced tree from the output of
+// MarshalEntriesJSON. Entries must be in ascending order according to `cmp`.
+// JSON has a single number type; integral numbers are decoded as `int` keys
+// and everything else as `float64`, so that `IntComparator` trees round-trip.
+func UnmarshalEntriesJSON(data []byte, cmp Comparator) (*Tree, error) {
+	var raw []struct {
+		Key   json.RawMessage `json:"key"`
+		Value interface{}     `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(raw))
+	for _, r := range raw {
+		key, err := decodeJSONKey(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Key: key, Value: r.Value})
+	}
+	return FromSorted(entries, cmp)
+}
+
+// LoadEntriesJSON builds a balanced tree ordered by `cmp` from a JSON array
+// of `{"key": ..., "value": ...}` objects read from `r`, such as the output
+// of MarshalEntriesJSON or of other tools. The array is decoded one element
+// at a time. Keys go through `keyDecode`; nil decodes them as
+// UnmarshalEntriesJSON does, integral numbers as `int` and other numbers as
+// `float64`. Values are decoded like encoding/json. A nil `cmp` means
+// IntComparator. The entries need not be sorted; of equal keys the last one
+// wins, as with Put. A malformed element fails with its index, keys the
+// comparator can't order with ErrorComparatorFailed.
+func LoadEntriesJSON(r io.Reader, cmp Comparator, keyDecode func(json.RawMessage) (interface{}, error)) (*Tree, error) {
+	if keyDecode == nil {
+		keyDecode = decodeJSONKey
+	}
+	order := NewTreeWith(cmp)
+	cmp = order.Comparator()
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array, found %v", token)
+	}
+
+	entries := []Entry{}
+	sorted := true
+	for index := 0; decoder.More(); index++ {
+		var raw struct {
+			Key   json.RawMessage `json:"key"`
+			Value interface{}     `json:"value"`
+		}
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", index, err)
+		}
+		key, err := keyDecode(raw.Key)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: decoding key: %w", index, err)
+		}
+		if err := mustBeValidKey(key); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", index, err)
+		}
+		if last := len(entries) - 1; sorted && last >= 0 {
+			c, err := order.tryCompare(entries[last].Key, key)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", index, err)
+			}
+			sorted = c < 0
+		}
+		entries = append(entries, Entry{Key: key, Value: raw.Value})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("entry %d: %w", len(entries), err)
+	}
+	if !sorted {
+		var failed error
+		entries = sortUnique(entries, func(a, b interface{}) int {
+			c, err := order.tryCompare(a, b)
+			if err != nil && failed == nil {
+				failed = err
+			}
+			return c
+		})
+		if failed != nil {
+			return nil, failed
+		}
+	}
+	return FromSorted(entries, cmp)
+}
+
+func decodeJSONKey(raw json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var key interface{}
+	if err := decoder.Decode(&key); err != nil {
+		return nil, err
+	}
+	if number, ok := key.(json.Number); ok {
+		if i, err := strconv.Atoi(number.String()); err == nil {
+			return i, nil
+		}
+		return number.Float64()
+	}
+	return key, nil
+}
+
+// PayloadCodec converts payloads to and from bytes for the serializers.
+// Payloads are opaque to the tree, so anything that cannot go through
+// encoding/json (protobuf messages, custom binary formats) needs a codec.
+type PayloadCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(b []byte) (interface{}, error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(b []byte) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// JSONCodec is the default PayloadCodec, backed by encoding/json.
+// Decoded payloads have the generic encoding/json types.
+var JSONCodec PayloadCodec = jsonCodec{}
+
+// SetCodec selects the PayloadCodec used to serialize payloads.
+// A nil codec restores the default `JSONCodec`.
+func (t *Tree) SetCodec(c PayloadCodec) {
+	t.codec = c
+}
+
+func (t *Tree) payloadCodec() PayloadCodec {
+	if t == nil || t.codec == nil {
+		return JSONCodec
+	}
+	return t.codec
+}
+
+// encodePayload runs the codec of the tree on the payload mapped to `key`.
+// Output of the default codec is embedded verbatim; anything else is
+// carried as a base64 JSON string.
+func (t *Tree) encodePayload(key, payload interface{}) (json.RawMessage, error) {
+	b, err := t.payloadCodec().Encode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload of key %#v: %w", key, err)
+	}
+	if t.codec == nil || t.codec == JSONCodec {
+		return b, nil
+	}
+	return json.Marshal(b)
+}
+
+func (t *Tree) decodePayload(key interface{}, raw json.RawMessage, encoding string) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	b := []byte(raw)
+	if encoding == binaryPayloads {
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("decoding payload of key %#v: %w", key, err)
+		}
+	}
+	payload, err := t.payloadCodec().Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("decoding payload of key %#v: %w", key, err)
+	}
+	return payload, nil
+}
+
+// Payload encodings recorded in the JSON envelope.
+const (
+	jsonPayloads   = "json"
+	binaryPayloads = "base64"
+)
+
+// Format versions written by Tree.MarshalJSON and Tree.MarshalJSONCompact.
+const (
+	jsonFormat        = 1
+	jsonCompactFormat = 2
+)
+
+var (
+	ErrorComparatorNotRegistered = errors.New("Comparator is not registered")
+	ErrorUnknownComparator       = errors.New("Unknown comparator name")
+	ErrorUnsupportedFormat       = errors.New("Unsupported serialization format")
+	ErrorComparatorAmbiguous     = errors.New("Comparator is registered under several names")
+)
+
+// `comparatorsLock` protects `comparators`
+var comparatorsLock sync.RWMutex
+var comparators = map[string]Comparator{}
+
+func init() {
+	RegisterComparator("int", IntComparator)
+	RegisterComparator("string", StringComparator)
+	RegisterComparator("time", TimeComparator)
+}
+
+// RegisterComparator makes `c` known to the serializers under `name`, so that
+// a marshaled tree records which ordering it was built with and can be
+// restored with the same one. A tree created with NewTreeByName, or restored
+// by a serializer, records the name of its comparator. For any other tree
+// the comparator is identified by its code pointer, which closures created
+// from the same literal share: if several registered comparators match,
+// serializing fails with ErrorComparatorAmbiguous.
+func RegisterComparator(name string, c Comparator) {
+	comparatorsLock.Lock()
+	defer comparatorsLock.Unlock()
+	comparators[name] = c
+}
+
+func comparatorByName(name string) (Comparator, error) {
+	comparatorsLock.RLock()
+	defer comparatorsLock.RUnlock()
+	c, ok := comparators[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrorUnknownComparator, name)
+	}
+	return c, nil
+}
+
+// comparatorName returns the name the comparator of the tree is
+// registered under; see RegisterComparator.
+func (t *Tree) comparatorName() (string, error) {
+	if t != nil && t.cmpName != "" {
+		return t.cmpName, nil
+	}
+	comparatorsLock.RLock()
+	defer comparatorsLock.RUnlock()
+	ptr := reflect.ValueOf(t.Comparator()).Pointer()
+	var names []string
+	for name, registered := range comparators {
+		if reflect.ValueOf(registered).Pointer() == ptr {
+			names = append(names, name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", ErrorComparatorNotRegistered
+	case 1:
+		return names[0], nil
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("%w: %q; create the tree with NewTreeByName", ErrorComparatorAmbiguous, names)
+}
+
+// keyTypes lists the key types the JSON decoder can restore faithfully.
+var keyTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", 0, int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), float32(0), float64(0),
+		time.Time{},
+	} {
+		keyTypes[reflect.TypeOf(v).String()] = reflect.TypeOf(v)
+	}
+}
+
+// MarshalText renders a Color as "Black" or "Red".
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText parses the output of MarshalText.
+func (c *Color) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case BLACK.String():
+		*c = BLACK
+	case RED.String():
+		*c = RED
+	default:
+		return fmt.Errorf("Unknown color %q", text)
+	}
+	return nil
+}
+
+type treeEnvelope struct {
+	Format     int       `json:"format"`
+	Comparator string    `json:"comparator"`
+	KeyType    string    `json:"keyType,omitempty"`
+	Payloads   string    `json:"payloads,omitempty"`
+	Root       *jsonNode `json:"root"`
+}
+
+type jsonNode struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Color Color           `json:"color"`
+	Left  *jsonNode       `json:"leftNode"`
+	Right *jsonNode       `json:"rightNode"`
+	Leaf  bool            `json:"isLeaf"`
+}
+
+type compactEnvelope struct {
+	Format     int          `json:"format"`
+	Comparator string       `json:"comparator"`
+	KeyType    string       `json:"keyType,omitempty"`
+	Payloads   string       `json:"payloads,omitempty"`
+	Root       *compactNode `json:"root,omitempty"`
+}
+
+type compactNode struct {
+	Key   json.RawMessage `json:"k"`
+	Value json.RawMessage `json:"v,omitempty"`
+	Red   bool            `json:"red,omitempty"`
+	Left  *compactNode    `json:"l,omitempty"`
+	Right *compactNode    `json:"r,omitempty"`
+	Leaf  bool            `json:"leaf,omitempty"`
+}
+
+func toCompactNode(n *jsonNode) *compactNode {
+	if n == nil {
+		return nil
+	}
+	return &compactNode{
+		Key: n.Key, Value: n.Value, Red: n.Color == RED, Leaf: n.Leaf,
+		Left: toCompactNode(n.Left), Right: toCompactNode(n.Right),
+	}
+}
+
+func fromCompactNode(n *compactNode) *jsonNode {
+	if n == nil {
+		return nil
+	}
+	return &jsonNode{
+		Key: n.Key, Value: n.Value, Color: Color(!n.Red), Leaf: n.Leaf,
+		Left: fromCompactNode(n.Left), Right: fromCompactNode(n.Right),
+	}
+}
+
+// MarshalJSON encodes the tree as a versioned envelope holding the name of
+// its registered comparator, the key type and the nested nodes with their
+// colors and payloads. Payloads go through the codec set with SetCodec.
+// It fails if the comparator was never passed to RegisterComparator or
+// cannot be told apart from another one (see RegisterComparator), or
+// with the offending key if the codec fails.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	envelope, err := t.jsonEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+// MarshalJSONCompact encodes the tree like MarshalJSON, but with terse
+// node fields ("k", "v", "l", "r") and without empty children, black
+// colors (only "red": true is written) or false leaf flags, which shrinks
+// the output of sparse trees considerably. UnmarshalJSON reads both forms.
+func (t *Tree) MarshalJSONCompact() ([]byte, error) {
+	envelope, err := t.jsonEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(compactEnvelope{
+		Format:     jsonCompactFormat,
+		Comparator: envelope.Comparator,
+		KeyType:    envelope.KeyType,
+		Payloads:   envelope.Payloads,
+		Root:       toCompactNode(envelope.Root),
+	})
+}
+
+func (t *Tree) jsonEnvelope() (treeEnvelope, error) {
+	name, err := t.comparatorName()
+	if err != nil {
+		return treeEnvelope{}, err
+	}
+	envelope := treeEnvelope{Format: jsonFormat, Comparator: name, Payloads: jsonPayloads}
+	if t.codec != nil && t.codec != JSONCodec {
+		envelope.Payloads = binaryPayloads
+	}
+	if t.Root != nil {
+		if keyType := reflect.TypeOf(t.Root.Key).String(); keyTypes[keyType] != nil {
+			envelope.KeyType = keyType
+		}
+	}
+	envelope.Root, err = t.toJSONNode(t.Root)
+	return envelope, err
+}
+
+func (t *Tree) toJSONNode(n *Node) (*jsonNode, error) {
+	if n == nil {
+		return nil, nil
+	}
+	key, err := json.Marshal(n.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := t.encodePayload(n.Key, n.payload)
+	if err != nil {
+		return nil, err
+	}
+	out := &jsonNode{Key: key, Value: value, Color: n.color, Leaf: n.Leaf}
+	if out.Left, err = t.toJSONNode(n.Left); err != nil {
+		return nil, err
+	}
+	if out.Right, err = t.toJSONNode(n.Right); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UnmarshalJSON restores a tree written by MarshalJSON, including its
+// shape, colors, payloads and comparator. Payloads are decoded with the
+// codec of `t`, so set the same codec used for marshaling beforehand.
+// Unknown formats and comparator names that were not registered in this
+// process are rejected, and so is, unless Options.SkipValidation is set,
+// a tree breaking an invariant (see CheckInvariants; a range tree with
+// leaf nodes, like tree.json, has invariants of its own). On failure `t`
+// is left unchanged.
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	if err := t.mutable("UnmarshalJSON"); err != nil {
+		return err
+	}
+	var header struct {
+		Format int `json:"format"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	var envelope treeEnvelope
+	switch header.Format {
+	case jsonFormat:
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return err
+		}
+	case jsonCompactFormat:
+		var compact compactEnvelope
+		if err := json.Unmarshal(data, &compact); err != nil {
+			return err
+		}
+		envelope = treeEnvelope{
+			Format:     compact.Format,
+			Comparator: compact.Comparator,
+			KeyType:    compact.KeyType,
+			Payloads:   compact.Payloads,
+			Root:       fromCompactNode(compact.Root),
+		}
+	default:
+		return fmt.Errorf("%w: %d", ErrorUnsupportedFormat, header.Format)
+	}
+	cmp, err := comparatorByName(envelope.Comparator)
+	if err != nil {
+		return err
+	}
+	root, err := t.fromJSONNode(envelope.Root, nil, keyTypes[envelope.KeyType], envelope.Payloads)
+	if err != nil {
+		return err
+	}
+	if err := t.validateLoaded(root, cmp); err != nil {
+		return err
+	}
+	t.Root, t.cmp, t.cmpName, t.sizeKnown, t.shared = root, cmp, envelope.Comparator, false, false
+	t.mods++
+	t.forgetChanges()
+	t.negative = nil
+	t.reweighAll()
+	return nil
+}
+
+func (t *Tree) fromJSONNode(in *jsonNode, parent *Node, keyType reflect.Type, payloads string) (*Node, error) {
+	if in == nil {
+		return nil, nil
+	}
+	var key interface{}
+	if keyType != nil {
+		v := reflect.New(keyType)
+		if err := json.Unmarshal(in.Key, v.Interface()); err != nil {
+			return nil, err
+		}
+		key = v.Elem().Interface()
+	} else {
+		var err error
+		if key, err = decodeJSONKey(in.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := t.decodePayload(key, in.Value, payloads)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{Key: key, payload: payload, color: in.Color, Leaf: in.Leaf, parent: parent}
+	if n.Left, err = t.fromJSONNode(in.Left, n, keyType, payloads); err != nil {
+		return nil, err
+	}
+	if n.Right, err = t.fromJSONNode(in.Right, n, keyType, payloads); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// validateLoaded runs CheckInvariants on a tree decoded with its shape,
+// unless Options.SkipValidation is set. A tree holding leaf nodes is a
+// range tree like the one of main, saved by printToJSON, and is checked by
+// the rules of those instead; see checkRangeTree. The decoders link every
+// node to its parent themselves, so this also confirms they did. A child
+// linked back to an ancestor is caught before the walk loops: its parent
+// pointer names another node.
+func (t *Tree) validateLoaded(root *Node, cmp Comparator) error {
+	if t.opts.SkipValidation {
+		return nil
+	}
+	loaded := &Tree{Root: root, cmp: cmp}
+	check := loaded.CheckInvariants
+	if hasLeaves(root) {
+		check = loaded.checkRangeTree
+	}
+	if err := check(); err != nil {
+		t.tracef(LevelError, "Load was rejected: %s\n", err.Error())
+		return err
+	}
+	return nil
+}
+
+// hasLeaves reports whether any node below `root` is flagged as a leaf.
+// It does not descend past a child whose parent pointer is wrong, so a
+// cycle in a loaded tree reaches the invariant check instead of looping.
+func hasLeaves(root *Node) bool {
+	var walk func(n *Node) bool
+	walk = func(n *Node) bool {
+		if n.Leaf {
+			return true
+		}
+		for _, child := range []*Node{n.Left, n.Right} {
+			if child != nil && child.parent == n && walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	return root != nil && walk(root)
+}
+
+type levelNode struct {
+	Key   interface{} `json:"key"`
+	Color Color       `json:"color"`
+}
+
+// MarshalLevels encodes the tree breadth first for visualization tools:
+// an array holding one array per level, from the root down, of the
+// {"key", "color"} objects of its nodes, left to right.
+func (t *Tree) MarshalLevels() ([]byte, error) {
+	levels := [][]levelNode{}
+	for level := []*Node{t.Root}; t.Root != nil && len(level) > 0; {
+		var nodes []levelNode
+		var next []*Node
+		for _, n := range level {
+			nodes = append(nodes, levelNode{Key: n.Key, Color: n.color})
+			for _, child := range []*Node{n.Left, n.Right} {
+				if child != nil {
+					next = append(next, child)
+				}
+			}
+		}
+		levels = append(levels, nodes)
+		level = next
+	}
+	return json.Marshal(levels)
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalEntriesJSONIgnoresShape(t *testing.T) {
+	ascending := newIntTree(t, 1, 2, 3, 4, 5, 6, 7, 8)
+	shuffled := newIntTree(t, 5, 1, 8, 3, 7, 2, 6, 4)
+	a, err := ascending.MarshalEntriesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := shuffled.MarshalEntriesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatalf("same contents marshal differently:\n%s\n%s", a, b)
+	}
+}
+
+func TestUnmarshalEntriesJSONRoundTrip(t *testing.T) {
+	data, err := newIntTree(t, 3, 1, 4, 15, 9, 2, 6).MarshalEntriesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := UnmarshalEntriesJSON(data, IntComparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, tree)
+	for _, k := range []int{1, 2, 3, 4, 6, 9, 15} {
+		if found, v := tree.Get(k); !found || v != float64(k) {
+			t.Errorf("Get(%d) = %v, %v", k, found, v)
+		}
+	}
+	again, err := tree.MarshalEntriesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("round trip changed the output:\n%s\n%s", data, again)
+	}
+}
+
+func TestUnmarshalEntriesJSONUnsorted(t *testing.T) {
+	_, err := UnmarshalEntriesJSON([]byte(`[{"key":2,"value":0},{"key":1,"value":0}]`), IntComparator)
+	if err == nil {
+		t.Fatal("unsorted entries were accepted")
+	}
+}
+
+func descendingInts(o1, o2 interface{}) int {
+	return IntComparator(o2, o1)
+}
+
+// keysOf returns the keys of `tree` in the order the tree sorts them.
+func keysOf(tree *Tree) []interface{} {
+	keys := []interface{}{}
+	tree.Walk(&OrderedVisitor{Order: InOrder, Fn: func(n *Node) {
+		keys = append(keys, n.Key)
+	}})
+	return keys
+}
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	RegisterComparator("test-descending", descendingInts)
+	tree := NewTreeWith(descendingInts)
+	for _, k := range []int{5, 2, 8, 1, 9, 3} {
+		tree.Put(k, nil)
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"comparator":"test-descending"`)) {
+		t.Fatalf("envelope doesn't name the comparator: %s", data)
+	}
+
+	var restored Tree
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, &restored)
+	if got, want := keysOf(&restored), []interface{}{9, 8, 5, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored keys %v, want %v", got, want)
+	}
+	// the restored comparator keeps ordering new keys
+	restored.Put(7, nil)
+	if got, want := keysOf(&restored), []interface{}{9, 8, 7, 5, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys after Put %v, want %v", got, want)
+	}
+}
+
+func TestUnmarshalJSONUnknownComparator(t *testing.T) {
+	var tree Tree
+	err := json.Unmarshal([]byte(`{"format":1,"comparator":"test-nowhere","root":null}`), &tree)
+	if !errors.Is(err, ErrorUnknownComparator) {
+		t.Fatalf("got %v, want ErrorUnknownComparator", err)
+	}
+	err = json.Unmarshal([]byte(`{"format":99,"comparator":"int","root":null}`), &tree)
+	if !errors.Is(err, ErrorUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrorUnsupportedFormat", err)
+	}
+}
+
+func TestMarshalJSONUnregisteredComparator(t *testing.T) {
+	tree := NewTreeWith(func(o1, o2 interface{}) int { return IntComparator(o1, o2) })
+	if _, err := json.Marshal(tree); !errors.Is(err, ErrorComparatorNotRegistered) {
+		t.Fatalf("got %v, want ErrorComparatorNotRegistered", err)
+	}
+}
+
+func offsetComparator(offset int) Comparator {
+	return func(o1, o2 interface{}) int {
+		return IntComparator(o1.(int)+offset, o2.(int)+offset)
+	}
+}
+
+func TestMarshalJSONAmbiguousComparator(t *testing.T) {
+	RegisterComparator("test-offset-1", offsetComparator(1))
+	RegisterComparator("test-offset-2", offsetComparator(2))
+
+	// both closures share their code, so the name can't be told
+	guessed := NewTreeWith(offsetComparator(2))
+	if _, err := json.Marshal(guessed); !errors.Is(err, ErrorComparatorAmbiguous) {
+		t.Fatalf("got %v, want ErrorComparatorAmbiguous", err)
+	}
+
+	named, err := NewTreeByName("test-offset-2", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	named.Put(1, nil)
+	data, err := json.Marshal(named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Tree
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := json.Marshal(&restored); err != nil || !bytes.Equal(again, data) {
+		t.Fatalf("re-marshaling the restored tree: %s, %v", again, err)
+	}
+}
+
+type point struct{ X, Y int }
+
+// pointCodec stores point payloads as base64 text.
+type pointCodec struct{}
+
+func (pointCodec) Encode(v interface{}) ([]byte, error) {
+	p, ok := v.(point)
+	if !ok {
+		return nil, fmt.Errorf("not a point: %#v", v)
+	}
+	text := fmt.Sprintf("%d,%d", p.X, p.Y)
+	return []byte(base64.StdEncoding.EncodeToString([]byte(text))), nil
+}
+
+func (pointCodec) Decode(b []byte) (interface{}, error) {
+	text, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		return nil, err
+	}
+	var p point
+	if _, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func TestPayloadCodecRoundTrip(t *testing.T) {
+	tree := NewTree()
+	tree.SetCodec(pointCodec{})
+	for k := 1; k <= 5; k++ {
+		tree.Put(k, point{k, -k})
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewTree()
+	restored.SetCodec(pointCodec{})
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	for k := 1; k <= 5; k++ {
+		if found, v := restored.Get(k); !found || v != (point{k, -k}) {
+			t.Errorf("Get(%d) = %v, %#v", k, found, v)
+		}
+	}
+}
+
+func TestPayloadCodecFailureNamesKey(t *testing.T) {
+	tree := NewTree()
+	tree.SetCodec(pointCodec{})
+	tree.Put(1, point{1, 1})
+	tree.Put(42, "not a point")
+	tree.Put(3, point{3, 3})
+	_, err := json.Marshal(tree)
+	if err == nil {
+		t.Fatal("marshaling succeeded despite the failing codec")
+	}
+	if !strings.Contains(err.Error(), "key 42") {
+		t.Fatalf("error doesn't name the key: %s", err)
+	}
+}
+
+func TestMarshalLevels(t *testing.T) {
+	data, err := exampleRangeTree().MarshalLevels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var levels [][]struct {
+		Key   int    `json:"key"`
+		Color string `json:"color"`
+	}
+	if err := json.Unmarshal(data, &levels); err != nil {
+		t.Fatalf("%s: %s", data, err)
+	}
+	want := [][]int{
+		{49},
+		{23, 80},
+		{10, 37, 62, 89},
+		{3, 19, 30, 49, 59, 70, 100},
+		{3, 10, 19, 23, 30, 37, 59, 62, 70, 80, 100},
+	}
+	var got [][]int
+	for _, level := range levels {
+		var keys []int
+		for _, n := range level {
+			keys = append(keys, n.Key)
+			if n.Color != "Red" {
+				t.Errorf("key %d reported black; main builds red nodes", n.Key)
+			}
+		}
+		got = append(got, keys)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("levels %v, want %v", got, want)
+	}
+
+	tree := newIntTree(t, 2, 1, 3)
+	data, _ = tree.MarshalLevels()
+	if string(data) != `[[{"key":2,"color":"Black"}],[{"key":1,"color":"Red"},{"key":3,"color":"Red"}]]` {
+		t.Errorf("MarshalLevels() = %s", data)
+	}
+	if data, _ := NewTree().MarshalLevels(); string(data) != "[]" {
+		t.Errorf("empty tree: MarshalLevels() = %s", data)
+	}
+}
+
+func TestMarshalJSONCompact(t *testing.T) {
+	tree := NewTree()
+	for k := 0; k < 200; k++ {
+		tree.Put(k, k%3 == 0)
+	}
+	full, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := tree.MarshalJSONCompact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(compact, []byte("null")) {
+		t.Errorf("compact output holds empty children: %.200s", compact)
+	}
+	if len(compact)*2 > len(full) {
+		t.Errorf("compact output is %d bytes, full output %d", len(compact), len(full))
+	}
+
+	var restored Tree
+	if err := json.Unmarshal(compact, &restored); err != nil {
+		t.Fatal(err)
+	}
+	checkRedBlack(t, &restored)
+	if got, want := restored.Items(), tree.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored %v, want %v", got, want)
+	}
+	if got, want := shapeOf(&restored), shapeOf(tree); got != want {
+		t.Errorf("restored shape %s, want %s", got, want)
+	}
+}
+
+func TestMarshalJSONTimeKeys(t *testing.T) {
+	tree := NewTreeWith(TimeComparator)
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, m := range []int{30, 10, 20} {
+		tree.Put(base.Add(time.Duration(m)*time.Minute), m)
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Tree
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []int{10, 20, 30} {
+		key := base.Add(time.Duration(m) * time.Minute)
+		if found, _ := restored.Get(key); !found {
+			t.Errorf("restored tree lacks %v", key)
+		}
+	}
+	restored.Put(base, 0)
+	if e, _ := restored.Min(); !e.Key.(time.Time).Equal(base) {
+		t.Errorf("restored tree orders %v first, want %v", e.Key, base)
+	}
+}
+
+// brokenTrees returns hand-built int trees that each break one invariant,
+// with a fragment of the error that loading them must report.
+func brokenTrees() map[string]struct {
+	tree *Tree
+	want string
+} {
+	return map[string]struct {
+		tree *Tree
+		want string
+	}{
+		// balanced black heights, but the red 1 has a red child
+		"red-red": {&Tree{cmp: IntComparator, Root: &Node{Key: 2, color: BLACK,
+			Left:  &Node{Key: 1, color: RED, Left: &Node{Key: 0, color: RED}},
+			Right: &Node{Key: 3, color: RED},
+		}}, "has red child"},
+		"out-of-order": {&Tree{cmp: IntComparator, Root: &Node{Key: 2, color: BLACK,
+			Left:  &Node{Key: 3, color: RED},
+			Right: &Node{Key: 1, color: RED},
+		}}, "is not before"},
+	}
+}
+
+func TestUnmarshalJSONRejectsBrokenTrees(t *testing.T) {
+	for name, broken := range brokenTrees() {
+		data, err := json.Marshal(broken.tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree := newIntTree(t, 10, 20)
+		err = json.Unmarshal(data, tree)
+		if !errors.Is(err, ErrorInvariantViolated) || !strings.Contains(err.Error(), broken.want) {
+			t.Errorf("%s: Unmarshal = %v, want ErrorInvariantViolated with %q", name, err, broken.want)
+		}
+		if got := keysOf(tree); !reflect.DeepEqual(got, []interface{}{10, 20}) {
+			t.Errorf("%s: rejected load changed the receiver to %v", name, got)
+		}
+
+		trusted := NewTreeWithOptions(IntComparator, Options{SkipValidation: true})
+		if err := json.Unmarshal(data, trusted); err != nil {
+			t.Errorf("%s: Unmarshal with SkipValidation = %v", name, err)
+		} else if shapeOf(trusted) != shapeOf(broken.tree) {
+			t.Errorf("%s: loaded %s, want %s", name, shapeOf(trusted), shapeOf(broken.tree))
+		}
+	}
+}
+
+func TestValidateLoadedRejectsCycle(t *testing.T) {
+	// no format can encode a cycle, so hand the validator one directly:
+	// the left child of 1 is the root again
+	one := &Node{Key: 1, color: BLACK}
+	three := &Node{Key: 3, color: BLACK}
+	root := &Node{Key: 2, color: BLACK, Left: one, Right: three}
+	one.parent, three.parent = root, root
+	one.Left = root
+	err := NewTree().validateLoaded(root, IntComparator)
+	if !errors.Is(err, ErrorInvariantViolated) {
+		t.Fatalf("validateLoaded on a cycle = %v, want ErrorInvariantViolated", err)
+	}
+}
+
+func TestUnmarshalJSONRangeTree(t *testing.T) {
+	data, err := os.ReadFile("tree.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := NewTree()
+	if err := json.Unmarshal(data, tree); err != nil {
+		t.Fatalf("loading tree.json: %v", err)
+	}
+	if tree.IsEmpty() {
+		t.Fatal("tree.json loaded empty")
+	}
+}
+
+func TestLoadEntriesJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name, input string
+		keys        []interface{}
+	}{
+		{"sorted", `[{"key":1,"value":"a"},{"key":2,"value":"b"},{"key":3,"value":"c"}]`, []interface{}{1, 2, 3}},
+		{"unsorted", `[{"key":3,"value":"c"},{"key":1,"value":"a"},{"key":2,"value":"b"}]`, []interface{}{1, 2, 3}},
+		{"duplicates", `[{"key":2,"value":"x"},{"key":1,"value":"a"},{"key":2,"value":"b"}]`, []interface{}{1, 2}},
+		{"empty", `[]`, []interface{}{}},
+	} {
+		tree, err := LoadEntriesJSON(strings.NewReader(tc.input), nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		checkRedBlack(t, tree)
+		if got := keysOf(tree); !reflect.DeepEqual(got, tc.keys) {
+			t.Errorf("%s: keys %v, want %v", tc.name, got, tc.keys)
+		}
+		// of equal keys the last wins
+		if found, v := tree.Get(2); found && v != "b" {
+			t.Errorf("%s: Get(2) = %v, want b", tc.name, v)
+		}
+	}
+
+	// keys in a format of their own
+	tree, err := LoadEntriesJSON(strings.NewReader(`[{"key":"b"},{"key":"a"}]`), StringComparator,
+		func(raw json.RawMessage) (interface{}, error) {
+			var s string
+			err := json.Unmarshal(raw, &s)
+			return strings.ToUpper(s), err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keysOf(tree), []interface{}{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded keys %v, want %v", got, want)
+	}
+}
+
+func TestLoadEntriesJSONMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name, input, want string
+	}{
+		{"not an array", `{"key":1}`, "expected a JSON array"},
+		{"malformed element", `[{"key":1},{"key":2,"value":},{"key":3}]`, "entry 1"},
+		{"bad key", `[{"key":1},{"key":"two"}]`, "entry 1"},
+		{"truncated", `[{"key":1},{"key":2}`, "entry 2"},
+	} {
+		if _, err := LoadEntriesJSON(strings.NewReader(tc.input), nil, nil); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want one mentioning %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+// setMember is the payload shared by every key of a Set.
+var setMember = struct{}{}
+
+// Set is an ordered set of keys, backed by a Tree.
+type Set struct {
+	tree *Tree
+}
+
+// NewSet returns an empty Set ordered by `c`.
+func NewSet(c Comparator) *Set {
+	return &Set{tree: NewTreeWith(c)}
+}
+
+// Add inserts `key` into the set.
+func (s *Set) Add(key interface{}) error {
+	return s.tree.Put(key, setMember)
+}
+
+// Remove deletes `key` from the set; it is a noop if `key` is absent.
+func (s *Set) Remove(key interface{}) {
+	s.tree.Delete(key)
+}
+
+// Contains reports whether `key` is in the set.
+func (s *Set) Contains(key interface{}) bool {
+	return s.tree.Has(key)
+}
+
+// Len returns the number of keys in the set.
+func (s *Set) Len() int {
+	return int(s.tree.Size())
+}
+
+// Min returns the smallest key, and false if the set is empty.
+func (s *Set) Min() (interface{}, bool) {
+	e, ok := s.tree.Min()
+	return e.Key, ok
+}
+
+// Max returns the largest key, and false if the set is empty.
+func (s *Set) Max() (interface{}, bool) {
+	e, ok := s.tree.Max()
+	return e.Key, ok
+}
+
+// Range returns the keys within [lo, hi] in ascending order.
+func (s *Set) Range(lo, hi interface{}) []interface{} {
+	return s.tree.RangeSearch(lo, hi)
+}
+
+// Keys returns all keys in ascending order.
+func (s *Set) Keys() []interface{} {
+	return s.tree.Keys()
+}
+
+// Iterator returns an Iterator over the keys in ascending order.
+func (s *Set) Iterator() *Iterator {
+	return s.tree.Iterator()
+}
+
+// CheckInvariants verifies the red-black properties of the backing tree.
+func (s *Set) CheckInvariants() error {
+	return s.tree.CheckInvariants()
+}
+
+// Union returns a new set holding the keys of either set.
+func (s *Set) Union(other *Set) *Set {
+	return s.merge(other, true, true, true)
+}
+
+// Intersect returns a new set holding the keys present in both sets.
+func (s *Set) Intersect(other *Set) *Set {
+	return s.merge(other, false, true, false)
+}
+
+// Difference returns a new set holding the keys of `s` absent from `other`.
+func (s *Set) Difference(other *Set) *Set {
+	return s.merge(other, true, false, false)
+}
+
+// merge walks both sets in order at the same time, keeping keys found only
+// in `s`, in both, or only in `other` as requested, and bulk-builds the
+// result: O(n + m) overall. Both sets must share the same ordering; the
+// result uses the comparator of `s`.
+func (s *Set) merge(other *Set, onlyLeft, both, onlyRight bool) *Set {
+	cmp := s.tree.cmp
+	entries := []Entry{}
+	keep := func(key interface{}, wanted bool) {
+		if wanted {
+			entries = append(entries, Entry{Key: key, Value: setMember})
+		}
+	}
+
+	left, right := s.Iterator(), other.Iterator()
+	hasLeft, hasRight := left.Next(), right.Next()
+	for hasLeft && hasRight {
+		switch c := cmp(left.Key(), right.Key()); {
+		case c < 0:
+			keep(left.Key(), onlyLeft)
+			hasLeft = left.Next()
+		case c > 0:
+			keep(right.Key(), onlyRight)
+			hasRight = right.Next()
+		default:
+			keep(left.Key(), both)
+			hasLeft, hasRight = left.Next(), right.Next()
+		}
+	}
+	for ; hasLeft; hasLeft = left.Next() {
+		keep(left.Key(), onlyLeft)
+	}
+	for ; hasRight; hasRight = right.Next() {
+		keep(right.Key(), onlyRight)
+	}
+
+	tree, err := FromSorted(entries, cmp)
+	if err != nil {
+		// the merge emits strictly ascending keys
+		panic(err)
+	}
+	return &Set{tree: tree}
+}
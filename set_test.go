@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newIntSet(t *testing.T, keys ...int) *Set {
+	t.Helper()
+	s := NewSet(IntComparator)
+	for _, k := range keys {
+		if err := s.Add(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func ints(keys ...int) []interface{} {
+	out := []interface{}{}
+	for _, k := range keys {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestSetAlgebra(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		a, b                  []int
+		union, inter, aMinusB []interface{}
+	}{
+		{
+			"overlapping", []int{1, 3, 5, 7, 9}, []int{3, 4, 5, 6},
+			ints(1, 3, 4, 5, 6, 7, 9), ints(3, 5), ints(1, 7, 9),
+		},
+		{
+			"disjoint", []int{1, 2, 3}, []int{10, 20},
+			ints(1, 2, 3, 10, 20), ints(), ints(1, 2, 3),
+		},
+		{
+			"subset", []int{2, 4}, []int{1, 2, 3, 4, 5},
+			ints(1, 2, 3, 4, 5), ints(2, 4), ints(),
+		},
+		{
+			"empty", nil, []int{1},
+			ints(1), ints(), ints(),
+		},
+	} {
+		a, b := newIntSet(t, tc.a...), newIntSet(t, tc.b...)
+		for op, got := range map[string]*Set{
+			"Union":      a.Union(b),
+			"Intersect":  a.Intersect(b),
+			"Difference": a.Difference(b),
+		} {
+			want := map[string][]interface{}{
+				"Union": tc.union, "Intersect": tc.inter, "Difference": tc.aMinusB,
+			}[op]
+			if !reflect.DeepEqual(got.Keys(), want) {
+				t.Errorf("%s: %s = %v, want %v", tc.name, op, got.Keys(), want)
+			}
+			if got.Len() != len(want) {
+				t.Errorf("%s: %s Len() = %d, want %d", tc.name, op, got.Len(), len(want))
+			}
+			if err := got.CheckInvariants(); err != nil {
+				t.Errorf("%s: %s: %s", tc.name, op, err)
+			}
+		}
+	}
+}
+
+func TestSetBasics(t *testing.T) {
+	s := newIntSet(t, 5, 1, 9, 3, 5)
+	if s.Len() != 4 || !s.Contains(3) || s.Contains(4) {
+		t.Fatalf("Len() = %d, Contains(3) = %v, Contains(4) = %v", s.Len(), s.Contains(3), s.Contains(4))
+	}
+	if min, ok := s.Min(); !ok || min != 1 {
+		t.Errorf("Min() = %v, %v", min, ok)
+	}
+	if max, ok := s.Max(); !ok || max != 9 {
+		t.Errorf("Max() = %v, %v", max, ok)
+	}
+	if got := s.Range(2, 6); !reflect.DeepEqual(got, ints(3, 5)) {
+		t.Errorf("Range(2, 6) = %v", got)
+	}
+	s.Remove(5)
+	s.Remove(42)
+	var got []interface{}
+	for it := s.Iterator(); it.Next(); {
+		got = append(got, it.Key())
+	}
+	if !reflect.DeepEqual(got, ints(1, 3, 9)) {
+		t.Errorf("iterated %v after Remove(5)", got)
+	}
+	if _, ok := NewSet(IntComparator).Min(); ok {
+		t.Error("Min() of an empty set reported a key")
+	}
+}
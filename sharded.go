@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+var ErrorNoShards = errors.New("A sharded tree needs at least one shard")
+
+// ShardedTree spreads keys over several independently locked
+// ConcurrentTrees so that writers to different shards don't contend.
+//
+// In hash mode (NewShardedTree) a key's shard is picked by a shard
+// function; range queries then have to ask every shard and merge the
+// answers. In range mode (NewRangeShardedTree) shards own consecutive key
+// ranges delimited by split points, so range queries only touch the shards
+// overlapping the range and Keys/Walk see the shards in key order.
+type ShardedTree struct {
+	shards  []*ConcurrentTree
+	cmp     Comparator
+	shardOf func(key interface{}) uint32 // hash mode
+	splits  []interface{}                // range mode
+}
+
+// NewShardedTree returns an empty ShardedTree of `n` hash-partitioned
+// shards ordered by `c`, or by IntComparator if `c` is nil. A nil
+// `shardOf` defaults to an FNV-1a hash of the key formatted with %v.
+func NewShardedTree(n int, c Comparator, shardOf func(key interface{}) uint32) (*ShardedTree, error) {
+	if n < 1 {
+		return nil, ErrorNoShards
+	}
+	if c == nil {
+		c = IntComparator
+	}
+	if shardOf == nil {
+		shardOf = hashKey
+	}
+	st := &ShardedTree{cmp: c, shardOf: shardOf}
+	for i := 0; i < n; i++ {
+		st.shards = append(st.shards, NewConcurrentTree(c))
+	}
+	return st, nil
+}
+
+// NewRangeShardedTree returns an empty range-partitioned ShardedTree with
+// len(splits)+1 shards: shard i holds the keys in [splits[i-1], splits[i]).
+// The split points must be strictly ascending according to `c`; a nil `c`
+// means IntComparator.
+func NewRangeShardedTree(c Comparator, splits ...interface{}) (*ShardedTree, error) {
+	if c == nil {
+		c = IntComparator
+	}
+	for i := range splits {
+		if err := mustBeValidKey(splits[i]); err != nil {
+			return nil, err
+		}
+		if i > 0 && c(splits[i-1], splits[i]) >= 0 {
+			return nil, fmt.Errorf("split %d (%#v): %w", i, splits[i], ErrorEntriesUnsorted)
+		}
+	}
+	st := &ShardedTree{cmp: c, splits: splits}
+	for i := 0; i <= len(splits); i++ {
+		st.shards = append(st.shards, NewConcurrentTree(c))
+	}
+	return st, nil
+}
+
+func hashKey(key interface{}) uint32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%v", key)
+	return h.Sum32()
+}
+
+func (st *ShardedTree) rangeMode() bool {
+	return st.shardOf == nil
+}
+
+// shardIndex returns the index of the shard owning `key`.
+func (st *ShardedTree) shardIndex(key interface{}) int {
+	if !st.rangeMode() {
+		return int(st.shardOf(key) % uint32(len(st.shards)))
+	}
+	return sort.Search(len(st.splits), func(i int) bool {
+		return st.cmp(key, st.splits[i]) < 0
+	})
+}
+
+// Get looks for the node with supplied key and returns its mapped payload.
+func (st *ShardedTree) Get(key interface{}) (bool, interface{}) {
+	if err := mustBeValidKey(key); err != nil {
+		return false, nil
+	}
+	return st.shards[st.shardIndex(key)].Get(key)
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (st *ShardedTree) Has(key interface{}) bool {
+	if err := mustBeValidKey(key); err != nil {
+		return false
+	}
+	return st.shards[st.shardIndex(key)].Has(key)
+}
+
+// Put saves the mapping (key, data) into the shard owning `key`.
+func (st *ShardedTree) Put(key interface{}, data interface{}) error {
+	if err := mustBeValidKey(key); err != nil {
+		return err
+	}
+	return st.shards[st.shardIndex(key)].Put(key, data)
+}
+
+// Delete removes the item identified by the supplied key.
+func (st *ShardedTree) Delete(key interface{}) {
+	if err := mustBeValidKey(key); err != nil {
+		return
+	}
+	st.shards[st.shardIndex(key)].Delete(key)
+}
+
+// Size returns the number of items over all shards. Shards are counted one
+// after the other, so concurrent writes may or may not be included.
+func (st *ShardedTree) Size() uint64 {
+	var size uint64
+	for _, shard := range st.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// Keys returns all keys in ascending order.
+func (st *ShardedTree) Keys() []interface{} {
+	return st.RangeSearch(nil, nil)
+}
+
+// RangeSearch returns the keys within [low, high] in ascending order,
+// merging the sorted answers of the shards involved. A nil bound leaves that
+// side of the range open.
+func (st *ShardedTree) RangeSearch(low, high interface{}) []interface{} {
+	first, last := 0, len(st.shards)-1
+	if st.rangeMode() {
+		if low != nil {
+			first = st.shardIndex(low)
+		}
+		if high != nil {
+			last = st.shardIndex(high)
+		}
+	}
+	var parts [][]interface{}
+	for i := first; i <= last; i++ {
+		parts = append(parts, st.shards[i].RangeSearch(low, high))
+	}
+	if st.rangeMode() {
+		// shards are disjoint and ordered; concatenation is the merge
+		keys := []interface{}{}
+		for _, part := range parts {
+			keys = append(keys, part...)
+		}
+		return keys
+	}
+	return mergeSorted(parts, st.cmp)
+}
+
+// Walk hands the root of every shard to the visitor, one shard at a time
+// under its read lock. In range mode shards come in key order, so an inorder
+// visitor sees all keys in ascending order; in hash mode it doesn't.
+func (st *ShardedTree) Walk(visitor Visitor) {
+	for _, shard := range st.shards {
+		shard.Walk(visitor)
+	}
+}
+
+// mergeSorted merges ascending key slices into one ascending slice.
+func mergeSorted(parts [][]interface{}, cmp Comparator) []interface{} {
+	total := 0
+	for _, part := range parts {
+		total += len(part)
+	}
+	merged := make([]interface{}, 0, total)
+	heads := make([]int, len(parts))
+	for len(merged) < total {
+		best := -1
+		for i, part := range parts {
+			if heads[i] == len(part) {
+				continue
+			}
+			if best < 0 || cmp(part[heads[i]], parts[best][heads[best]]) < 0 {
+				best = i
+			}
+		}
+		merged = append(merged, parts[best][heads[best]])
+		heads[best]++
+	}
+	return merged
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func newShardedTrees(t *testing.T) map[string]*ShardedTree {
+	t.Helper()
+	hashed, err := NewShardedTree(4, IntComparator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranged, err := NewRangeShardedTree(IntComparator, 250, 500, 750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*ShardedTree{"hash": hashed, "range": ranged}
+}
+
+func TestShardedTreeMergeOrder(t *testing.T) {
+	for mode, st := range newShardedTrees(t) {
+		// insert in an order unrelated to both hashing and splitting
+		for k := 0; k < 1000; k++ {
+			key := (k * 617) % 1000
+			if err := st.Put(key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys := st.Keys()
+		if len(keys) != 1000 || st.Size() != 1000 {
+			t.Fatalf("%s: %d keys, Size() = %d, want 1000", mode, len(keys), st.Size())
+		}
+		for i, k := range keys {
+			if k != i {
+				t.Fatalf("%s: Keys()[%d] = %v", mode, i, k)
+			}
+		}
+		want := []interface{}{}
+		for k := 240; k <= 510; k++ {
+			want = append(want, k)
+		}
+		if got := st.RangeSearch(240, 510); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: RangeSearch(240, 510) returned %d keys from %v, want 240..510", mode, len(got), got[0])
+		}
+	}
+}
+
+func TestShardedTreeConcurrentWriters(t *testing.T) {
+	for mode, st := range newShardedTrees(t) {
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for k := w; k < 1024; k += 8 {
+					st.Put(k, w)
+					st.Get(k)
+					st.RangeSearch(k-10, k)
+				}
+				for k := w; k < 1024; k += 16 {
+					st.Delete(k)
+				}
+			}(w)
+		}
+		wg.Wait()
+		if got := st.Size(); got != 512 {
+			t.Errorf("%s: Size() = %d, want 512", mode, got)
+		}
+		for k := 0; k < 1024; k++ {
+			if st.Has(k) != (k%16 >= 8) {
+				t.Fatalf("%s: Has(%d) = %v", mode, k, st.Has(k))
+			}
+		}
+	}
+}
+
+func TestNewShardedTreeErrors(t *testing.T) {
+	if _, err := NewShardedTree(0, nil, nil); err != ErrorNoShards {
+		t.Errorf("NewShardedTree(0) = %v, want ErrorNoShards", err)
+	}
+	if _, err := NewRangeShardedTree(nil, 10, 10); !errors.Is(err, ErrorEntriesUnsorted) {
+		t.Errorf("duplicate split: %v, want ErrorEntriesUnsorted", err)
+	}
+	st, err := NewRangeShardedTree(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Put(20, nil)
+	if got := st.RangeSearch(0, 30); !reflect.DeepEqual(got, []interface{}{20}) {
+		t.Errorf("nil comparator: RangeSearch = %v", got)
+	}
+}
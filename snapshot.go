@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Version byte leading every snapshot stream.
+const snapshotVersion byte = 1
+
+type snapshotHeader struct {
+	Comparator string
+	Nodes      uint64
+}
+
+// snapshotNode is one node of the tree in preorder. The child flags
+// are enough to restore the exact shape.
+type snapshotNode struct {
+	Key      interface{}
+	Value    []byte
+	Color    Color
+	Leaf     bool
+	HasLeft  bool
+	HasRight bool
+}
+
+// WriteSnapshot writes a binary image of the tree to `w`: a version byte
+// followed by a gob stream holding the registered comparator name and every
+// node in preorder, with its color and codec-encoded payload. Keys travel as
+// gob interface values, so key types other than the predeclared ones must be
+// passed to gob.Register first.
+func (t *Tree) WriteSnapshot(w io.Writer) error {
+	name, err := t.comparatorName()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{snapshotVersion}); err != nil {
+		return err
+	}
+	encoder := gob.NewEncoder(w)
+	if err := encoder.Encode(snapshotHeader{Comparator: name, Nodes: t.Size()}); err != nil {
+		return err
+	}
+
+	var encode func(n *Node) error
+	encode = func(n *Node) error {
+		if n == nil {
+			return nil
+		}
+		value, err := t.payloadCodec().Encode(n.payload)
+		if err != nil {
+			return fmt.Errorf("encoding payload of key %#v: %w", n.Key, err)
+		}
+		record := snapshotNode{
+			Key: n.Key, Value: value, Color: n.color, Leaf: n.Leaf,
+			HasLeft: n.Left != nil, HasRight: n.Right != nil,
+		}
+		if err := encoder.Encode(&record); err != nil {
+			return fmt.Errorf("encoding key %#v: %w", n.Key, err)
+		}
+		if err := encode(n.Left); err != nil {
+			return err
+		}
+		return encode(n.Right)
+	}
+	return encode(t.Root)
+}
+
+// ReadSnapshot restores a tree written by WriteSnapshot, with the same
+// shape, colors and comparator. Payloads are decoded with `codec`;
+// nil means JSONCodec. A tree breaking an invariant is rejected; see
+// ReadSnapshotWithOptions.
+func ReadSnapshot(r io.Reader, codec PayloadCodec) (*Tree, error) {
+	return ReadSnapshotWithOptions(r, codec, Options{})
+}
+
+// ReadSnapshotWithOptions is ReadSnapshot returning a tree tuned by
+// `opts`. Unless Options.SkipValidation is set, the tree is checked with
+// CheckInvariants, or by the rules of range trees if it has leaf nodes,
+// and an error naming the first offending key is returned instead of a
+// broken tree.
+func ReadSnapshotWithOptions(r io.Reader, codec PayloadCodec, opts Options) (*Tree, error) {
+	br := bufio.NewReader(r)
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("%w: snapshot version %d", ErrorUnsupportedFormat, version)
+	}
+
+	decoder := gob.NewDecoder(br)
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, err
+	}
+	cmp, err := comparatorByName(header.Comparator)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTreeWithOptions(cmp, opts)
+	t.cmpName = header.Comparator
+	t.SetCodec(codec)
+
+	var count uint64
+	var decode func(parent *Node) (*Node, error)
+	decode = func(parent *Node) (*Node, error) {
+		var record snapshotNode
+		if err := decoder.Decode(&record); err != nil {
+			return nil, err
+		}
+		payload, err := t.payloadCodec().Decode(record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding payload of key %#v: %w", record.Key, err)
+		}
+		n := &Node{Key: record.Key, payload: payload, color: record.Color, Leaf: record.Leaf, parent: parent}
+		count++
+		if record.HasLeft {
+			if n.Left, err = decode(n); err != nil {
+				return nil, err
+			}
+		}
+		if record.HasRight {
+			if n.Right, err = decode(n); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+	if header.Nodes > 0 {
+		if t.Root, err = decode(nil); err != nil {
+			return nil, err
+		}
+		t.size, t.sizeKnown = count, true
+	}
+	if err := t.validateLoaded(t.Root, cmp); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SaveSnapshot writes a snapshot of the tree to the file at `path`.
+// The snapshot is written to a temporary file in the same directory and
+// renamed over `path` only once complete, so a failed save leaves any
+// previous snapshot untouched.
+func (t *Tree) SaveSnapshot(path string) error {
+	return writeFileAtomic(path, t.WriteSnapshot)
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot, decoding payloads
+// with JSONCodec. Use ReadSnapshot for other codecs.
+func LoadSnapshot(path string) (*Tree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSnapshot(file, nil)
+}
+
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	buffered := bufio.NewWriter(tmp)
+	if err = write(buffered); err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// shapeOf renders the keys and colors of `tree` in preorder, with "." for
+// missing children, so that equal strings mean equal shapes.
+func shapeOf(tree *Tree) string {
+	var b bytes.Buffer
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil {
+			b.WriteString(".")
+			return
+		}
+		b.WriteString("(" + n.String())
+		walk(n.Left)
+		walk(n.Right)
+		b.WriteString(")")
+	}
+	walk(tree.Root)
+	return b.String()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tree := newIntTree(t, 10, 20, 30, 40, 50, 25, 5, 1)
+	path := filepath.Join(t.TempDir(), "tree.snap")
+	if err := tree.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shapeOf(loaded), shapeOf(tree); got != want {
+		t.Fatalf("restored shape\n%s\nwant\n%s", got, want)
+	}
+	checkRedBlack(t, loaded)
+	if found, v := loaded.Get(25); !found || v != float64(25) {
+		t.Errorf("Get(25) = %v, %v", found, v)
+	}
+}
+
+func TestSnapshotVersionByte(t *testing.T) {
+	_, err := ReadSnapshot(bytes.NewReader([]byte{snapshotVersion + 1}), nil)
+	if !errors.Is(err, ErrorUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrorUnsupportedFormat", err)
+	}
+}
+
+func TestSaveSnapshotFailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.snap")
+	if err := newIntTree(t, 1, 2, 3).SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the write dies after part of the snapshot went out
+	halfway := errors.New("killed halfway")
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(original[:len(original)/2]); err != nil {
+			return err
+		}
+		return halfway
+	})
+	if !errors.Is(err, halfway) {
+		t.Fatalf("got %v, want the writer's error", err)
+	}
+	// so does a save whose codec fails on a later payload
+	tree := newIntTree(t, 1, 2, 3)
+	tree.SetCodec(pointCodec{})
+	if err := tree.SaveSnapshot(path); err == nil {
+		t.Fatal("save succeeded despite the failing codec")
+	}
+
+	if now, err := os.ReadFile(path); err != nil || !bytes.Equal(now, original) {
+		t.Fatalf("original snapshot changed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestReadSnapshotRejectsBrokenTrees(t *testing.T) {
+	for name, broken := range brokenTrees() {
+		var buf bytes.Buffer
+		if err := broken.tree.WriteSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadSnapshot(bytes.NewReader(buf.Bytes()), nil)
+		if !errors.Is(err, ErrorInvariantViolated) {
+			t.Errorf("%s: ReadSnapshot = %v, want ErrorInvariantViolated", name, err)
+		}
+		tree, err := ReadSnapshotWithOptions(bytes.NewReader(buf.Bytes()), nil, Options{SkipValidation: true})
+		if err != nil {
+			t.Errorf("%s: ReadSnapshotWithOptions with SkipValidation = %v", name, err)
+		} else if shapeOf(tree) != shapeOf(broken.tree) {
+			t.Errorf("%s: loaded %s, want %s", name, shapeOf(tree), shapeOf(broken.tree))
+		}
+	}
+}
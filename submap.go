@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrorKeyOutOfRange = errors.New("Key is outside the bounds of the view")
+
+// SubMap is a live view of the entries of a Tree whose keys lie within
+// [lo, hi]; a nil bound leaves that side open. Every call goes to the
+// parent tree, so changes made through the view show in the tree and vice
+// versa. Keys outside the bounds are invisible through the view and
+// cannot be written through it.
+type SubMap struct {
+	tree   *Tree
+	lo, hi interface{}
+}
+
+// SubMap returns a view of the entries with keys in [lo, hi].
+func (t *Tree) SubMap(lo, hi interface{}) *SubMap {
+	return &SubMap{tree: t, lo: t.normalize(lo), hi: t.normalize(hi)}
+}
+
+// HeadMap returns a view of the entries with keys <= hi.
+func (t *Tree) HeadMap(hi interface{}) *SubMap {
+	return t.SubMap(nil, hi)
+}
+
+// TailMap returns a view of the entries with keys >= lo.
+func (t *Tree) TailMap(lo interface{}) *SubMap {
+	return t.SubMap(lo, nil)
+}
+
+// inBounds reports whether the (valid) key lies within the view.
+func (m *SubMap) inBounds(key interface{}) bool {
+	if m.lo != nil && m.tree.cmp(key, m.lo) < 0 {
+		return false
+	}
+	if m.hi != nil && m.tree.cmp(key, m.hi) > 0 {
+		return false
+	}
+	return true
+}
+
+// checkKey normalizes and validates a key handed to the view.
+func (m *SubMap) checkKey(key interface{}) (interface{}, error) {
+	key = m.tree.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		return nil, err
+	}
+	if !m.inBounds(key) {
+		return nil, fmt.Errorf("%w: %#v", ErrorKeyOutOfRange, key)
+	}
+	return key, nil
+}
+
+// Get returns the payload of `key` if it is present and within bounds.
+func (m *SubMap) Get(key interface{}) (bool, interface{}) {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return false, nil
+	}
+	return m.tree.Get(key)
+}
+
+// Has reports whether `key` is present and within bounds.
+func (m *SubMap) Has(key interface{}) bool {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return false
+	}
+	return m.tree.Has(key)
+}
+
+// Put saves the mapping into the parent tree. Keys outside the bounds
+// are rejected with ErrorKeyOutOfRange.
+func (m *SubMap) Put(key interface{}, data interface{}) error {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return err
+	}
+	return m.tree.Put(key, data)
+}
+
+// Delete removes `key` from the parent tree, or fails with
+// ErrorKeyOutOfRange if it lies outside the bounds.
+func (m *SubMap) Delete(key interface{}) error {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return err
+	}
+	return m.tree.Delete(key)
+}
+
+// Keys returns the keys within bounds in ascending order.
+func (m *SubMap) Keys() []interface{} {
+	return m.tree.RangeSearch(m.lo, m.hi)
+}
+
+// Size returns the number of entries within bounds.
+func (m *SubMap) Size() uint64 {
+	var size uint64
+	m.tree.rangeWalk(m.lo, m.hi, func(*Node) bool {
+		size++
+		return true
+	})
+	return size
+}
+
+// Iterator returns an Iterator over the entries within bounds. Like any
+// Iterator it must not outlive a mutation of the tree.
+func (m *SubMap) Iterator() *Iterator {
+	var it *Iterator
+	if m.lo == nil {
+		it = m.tree.Iterator()
+	} else {
+		it = m.tree.seek(m.lo, true)
+	}
+	it.hi, it.cmp = m.hi, m.tree.Comparator()
+	return it
+}
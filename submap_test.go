@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSubMapBounds(t *testing.T) {
+	tree := newIntTree(t, 10, 20, 30, 40, 50)
+	view := tree.SubMap(20, 40)
+	if err := view.Put(45, nil); !errors.Is(err, ErrorKeyOutOfRange) {
+		t.Errorf("Put(45) = %v, want ErrorKeyOutOfRange", err)
+	}
+	if found, _ := view.Get(10); found || view.Has(50) {
+		t.Error("keys outside the view are visible through it")
+	}
+	view.Delete(50)
+	if !tree.Has(50) {
+		t.Error("Delete through the view removed a key outside it")
+	}
+	if got := view.Keys(); !reflect.DeepEqual(got, []interface{}{20, 30, 40}) {
+		t.Errorf("Keys() = %v", got)
+	}
+	if got := tree.HeadMap(20).Keys(); !reflect.DeepEqual(got, []interface{}{10, 20}) {
+		t.Errorf("HeadMap(20).Keys() = %v", got)
+	}
+	if got := tree.TailMap(41).Keys(); !reflect.DeepEqual(got, []interface{}{50}) {
+		t.Errorf("TailMap(41).Keys() = %v", got)
+	}
+}
+
+// TestSubMapInterleaved mutates the parent and the view in turns and checks
+// after each step that both agree with a model of the parent.
+func TestSubMapInterleaved(t *testing.T) {
+	const lo, hi = 250, 750
+	rng := rand.New(rand.NewSource(616))
+	tree := NewTree()
+	view := tree.SubMap(lo, hi)
+	model := map[int]int{}
+	for i := 0; i < 3000; i++ {
+		k := rng.Intn(1000)
+		inView := k >= lo && k <= hi
+		switch rng.Intn(4) {
+		case 0:
+			tree.Put(k, i)
+			model[k] = i
+		case 1:
+			tree.Delete(k)
+			delete(model, k)
+		case 2:
+			err := view.Put(k, i)
+			if inView != (err == nil) {
+				t.Fatalf("view.Put(%d) = %v", k, err)
+			}
+			if inView {
+				model[k] = i
+			}
+		default:
+			view.Delete(k)
+			if inView {
+				delete(model, k)
+			}
+		}
+
+		want := 0
+		for key := range model {
+			if key >= lo && key <= hi {
+				want++
+			}
+		}
+		if view.Size() != uint64(want) || tree.Size() != uint64(len(model)) {
+			t.Fatalf("step %d: view.Size() = %d, want %d; tree.Size() = %d, want %d",
+				i, view.Size(), want, tree.Size(), len(model))
+		}
+		v, present := model[k]
+		if found, got := view.Get(k); found != (present && inView) || found && got != v {
+			t.Fatalf("step %d: view.Get(%d) = %v, %v", i, k, found, got)
+		}
+	}
+	count, last := 0, lo-1
+	for it := view.Iterator(); it.Next(); count++ {
+		k := it.Key().(int)
+		if k <= last || k > hi || it.Value() != model[k] {
+			t.Fatalf("iterator yielded %v: %v after %d", k, it.Value(), last)
+		}
+		last = k
+	}
+	if count != int(view.Size()) {
+		t.Errorf("iterator yielded %d entries, Size() = %d", count, view.Size())
+	}
+	checkRedBlack(t, tree)
+}
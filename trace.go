@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// TraceStep is one comparison made while looking up a key.
+type TraceStep struct {
+	Node *Node     // node compared against
+	Cmp  int       // comparator result of (key, Node.Key)
+	Dir  Direction // child descended into; NODIR when the key matched
+	// Found is set on the last step of a successful lookup. A trace whose
+	// last step has Dir LEFT or RIGHT ended at an empty subtree: a miss.
+	Found bool
+}
+
+// Trace looks up `key` the way Get does and returns every comparison made
+// on the way down, which helps explaining what a suspicious comparator
+// does. Entries expired by PutTTL are still traced as found. An invalid key
+// yields an empty trace.
+func (t *Tree) Trace(key interface{}) []TraceStep {
+	steps := []TraceStep{}
+	key = t.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "Trace was prematurely aborted: %s\n", err.Error())
+		return steps
+	}
+	t.descend(nil, t.Root, key, NODIR, func(n *Node, c int, dir Direction) {
+		steps = append(steps, TraceStep{Node: n, Cmp: c, Dir: dir, Found: dir == NODIR})
+	})
+	return steps
+}
+
+// GetWithComparisons is Get reporting how many times the comparator was
+// called to find `key`: one per level descended, so at most Height(). It
+// is meant for profiling the lookups of hot keys and checking the balance.
+// A key ruled out by Options.NegativeFilter costs no comparison, and the
+// Loader is not called.
+func (t *Tree) GetWithComparisons(key interface{}) (bool, interface{}, int) {
+	if t == nil {
+		return false, nil, 0
+	}
+	key = t.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "GetWithComparisons was prematurely aborted: %s\n", err.Error())
+		return false, nil, 0
+	}
+	if t.definitelyAbsent(key) {
+		return false, nil, 0
+	}
+	var node *Node
+	comparisons := 0
+	t.descend(nil, t.Root, key, NODIR, func(n *Node, c int, dir Direction) {
+		comparisons++
+		if dir == NODIR {
+			node = n
+		}
+	})
+	if node == nil || t.expired(node) {
+		return false, nil, comparisons
+	}
+	return true, node.payload, comparisons
+}
+
+// TraceLevel selects how much a tree writes to the trace output enabled
+// with TraceOn or SetOutput.
+type TraceLevel byte
+
+const (
+	LevelDetail TraceLevel = iota // every step of the rebalancing; the default
+	LevelOp                       // one line per added, replaced or deleted node and per rotation
+	LevelError                    // only aborted operations and failures
+)
+
+// SetTraceLevel sets the verbosity of the trace lines of the tree.
+func (t *Tree) SetTraceLevel(level TraceLevel) {
+	t.traceLevel = level
+}
+
+// Every public mutation gets the next number of this sequence as its
+// operation ID, which prefixes the trace lines it emits.
+var opSeq uint64
+
+type traceOp struct {
+	name string
+	id   uint64
+}
+
+// beginOp marks the start of a public mutation for tracing and returns the
+// function ending it. Operations started by another one, such as the
+// Deletes of a Sweep, are traced as nested: [sweep#7/delete#8].
+func (t *Tree) beginOp(name string) func() {
+	t.ops = append(t.ops, traceOp{name, atomic.AddUint64(&opSeq, 1)})
+	return func() {
+		t.ops = t.ops[:len(t.ops)-1]
+	}
+}
+
+// tracef writes a trace line of the given level, unless the tree is set to
+// a less verbose one. The line is prefixed with the running operation, if any,
+// and the iteration of the rebalancing loop in progress:
+// "[put#1042 fixup:2] case 1".
+func (t *Tree) tracef(level TraceLevel, format string, args ...interface{}) {
+	if logger.Writer() == io.Discard || (t != nil && level < t.traceLevel) {
+		return
+	}
+	if t == nil || len(t.ops) == 0 {
+		logger.Printf(format, args...)
+		return
+	}
+	var prefix strings.Builder
+	prefix.WriteString("[")
+	for i, op := range t.ops {
+		if i > 0 {
+			prefix.WriteString("/")
+		}
+		fmt.Fprintf(&prefix, "%s#%d", op.name, op.id)
+	}
+	if t.fixupStep > 0 {
+		fmt.Fprintf(&prefix, " fixup:%d", t.fixupStep)
+	}
+	prefix.WriteString("] ")
+	logger.Printf(prefix.String()+format, args...)
+}
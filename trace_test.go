@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrace(t *testing.T) {
+	// 40 is the root, with 20 (10, 30) and 60 (50, 70) below it
+	tree := newIntTree(t, 40, 20, 60, 10, 30, 50, 70)
+	type step struct {
+		key   interface{}
+		cmp   int
+		dir   Direction
+		found bool
+	}
+	for _, tc := range []struct {
+		name string
+		key  int
+		want []step
+	}{
+		{"hit", 30, []step{{40, -1, LEFT, false}, {20, 1, RIGHT, false}, {30, 0, NODIR, true}}},
+		{"miss left of min", 5, []step{{40, -1, LEFT, false}, {20, -1, LEFT, false}, {10, -1, LEFT, false}}},
+		{"miss between keys", 55, []step{{40, 1, RIGHT, false}, {60, -1, LEFT, false}, {50, 1, RIGHT, false}}},
+	} {
+		var got []step
+		for _, s := range tree.Trace(tc.key) {
+			got = append(got, step{s.Node.Key, s.Cmp, s.Dir, s.Found})
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Trace(%d) = %v, want %v", tc.name, tc.key, got, tc.want)
+		}
+		// the trace ends where Get does
+		if found, _ := tree.Get(tc.key); found != got[len(got)-1].found {
+			t.Errorf("%s: Get found %v, the trace says %v", tc.name, found, !found)
+		}
+	}
+	if steps := NewTree().Trace(1); len(steps) != 0 {
+		t.Errorf("Trace on an empty tree = %v", steps)
+	}
+}
+
+// traceLine is a trace line taken apart by its operation prefix.
+type traceLine struct {
+	ops   string // "put#12", or "sweep#7/delete#8" for nested operations
+	fixup int
+	text  string
+}
+
+var tracePrefix = regexp.MustCompile(`^\S+ \S+ \[([a-z]+#\d+(?:/[a-z]+#\d+)*)(?: fixup:(\d+))?\] (.*)$`)
+
+func parseTrace(tb testing.TB, out string) []traceLine {
+	var lines []traceLine
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		m := tracePrefix.FindStringSubmatch(l)
+		if m == nil {
+			tb.Fatalf("trace line without an operation prefix: %q", l)
+		}
+		fixup, _ := strconv.Atoi(m[2])
+		lines = append(lines, traceLine{m[1], fixup, m[3]})
+	}
+	return lines
+}
+
+func TestTraceOperationIDs(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer TraceOff()
+	tree := NewTree()
+	for k := 1; k <= 6; k++ {
+		tree.Put(k, nil)
+	}
+	tree.Delete(1)
+	lines := parseTrace(t, buf.String())
+
+	// every operation tells its story in one run of lines, in order
+	var order []string
+	seen := map[string]bool{}
+	lastFixup := 0
+	for i, l := range lines {
+		if i == 0 || l.ops != lines[i-1].ops {
+			if seen[l.ops] {
+				t.Fatalf("lines of %s are interleaved with others", l.ops)
+			}
+			seen[l.ops] = true
+			order = append(order, l.ops)
+			lastFixup = 0
+		}
+		if l.fixup < lastFixup {
+			t.Errorf("%s: fixup iteration went back from %d to %d", l.ops, lastFixup, l.fixup)
+		}
+		lastFixup = l.fixup
+	}
+	if len(order) != 7 {
+		t.Fatalf("traced operations %v, want 6 puts and a delete", order)
+	}
+	var first uint64
+	for i, op := range order {
+		name, id := splitOp(t, op)
+		if want := map[bool]string{true: "delete", false: "put"}[i == 6]; name != want {
+			t.Errorf("operation %d is %s, want %s", i, op, want)
+		}
+		if i == 0 {
+			first = id
+		} else if id != first+uint64(i) {
+			t.Errorf("operation %d has ID %d, want %d", i, id, first+uint64(i))
+		}
+	}
+
+	// the third Put rotates, which takes a second fixup iteration
+	third := order[2]
+	var iterations []int
+	for _, l := range lines {
+		if l.ops == third && strings.HasPrefix(l.text, "current z") {
+			iterations = append(iterations, l.fixup)
+		}
+	}
+	if !reflect.DeepEqual(iterations, []int{1, 2}) {
+		t.Errorf("%s fixup iterations %v, want [1 2]", third, iterations)
+	}
+}
+
+func TestTraceNestedOperations(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	tree := NewTreeWithOptions(IntComparator, Options{Now: clock.Now})
+	tree.PutTTL(1, nil, time.Second)
+	tree.PutTTL(2, nil, time.Second)
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer TraceOff()
+	tree.Sweep(clock.now.Add(time.Minute))
+	var deletes []string
+	for _, l := range parseTrace(t, buf.String()) {
+		parts := strings.Split(l.ops, "/")
+		if name, _ := splitOp(t, parts[0]); name != "sweep" {
+			t.Fatalf("line %q traced outside the sweep", l.text)
+		}
+		if len(parts) == 2 && (len(deletes) == 0 || deletes[len(deletes)-1] != parts[1]) {
+			deletes = append(deletes, parts[1])
+		}
+	}
+	if len(deletes) != 2 {
+		t.Errorf("sweep traced nested operations %v, want two deletes", deletes)
+	}
+}
+
+func splitOp(tb testing.TB, op string) (string, uint64) {
+	i := strings.IndexByte(op, '#')
+	id, err := strconv.ParseUint(op[i+1:], 10, 64)
+	if err != nil {
+		tb.Fatalf("operation %q: %s", op, err)
+	}
+	return op[:i], id
+}
+
+func TestTraceLevels(t *testing.T) {
+	workload := func(level TraceLevel) []traceLine {
+		var buf bytes.Buffer
+		SetOutput(&buf)
+		defer TraceOff()
+		tree := NewTree()
+		tree.SetTraceLevel(level)
+		for k := 0; k < 100; k++ {
+			tree.Put(k, nil)
+		}
+		for k := 0; k < 100; k += 3 {
+			tree.Delete(k)
+		}
+		tree.Put(nil, nil)
+		tree.Put(nil, nil)
+		if buf.Len() == 0 {
+			return nil
+		}
+		return parseTrace(t, buf.String())
+	}
+	detail, op, errs := workload(LevelDetail), workload(LevelOp), workload(LevelError)
+	if !(len(detail) > len(op) && len(op) > len(errs)) {
+		t.Errorf("lines per level: detail %d, op %d, error %d, want decreasing", len(detail), len(op), len(errs))
+	}
+	// 100 added nodes, 34 deleted ones and the rotations in between
+	if len(op) < 134 {
+		t.Errorf("LevelOp wrote %d lines, want one per Put and Delete at least", len(op))
+	}
+	if len(errs) != 2 {
+		t.Errorf("LevelError wrote %d lines, want one per rejected nil key", len(errs))
+	}
+	for _, l := range errs {
+		if !strings.Contains(l.text, "prematurely aborted") {
+			t.Errorf("LevelError wrote %q", l.text)
+		}
+	}
+}
+
+func TestGetWithComparisons(t *testing.T) {
+	var count int64
+	tree := NewTreeWith(CountingComparator(IntComparator, &count))
+	for _, k := range rand.New(rand.NewSource(651)).Perm(2000) {
+		tree.Put(k*2, k)
+	}
+	height := tree.Height()
+	for key := -1; key <= 4000; key++ {
+		count = 0
+		found, v, comparisons := tree.GetWithComparisons(key)
+		if found != (key >= 0 && key < 4000 && key%2 == 0) || (found && v != key/2) {
+			t.Fatalf("GetWithComparisons(%d) = %v, %v", key, found, v)
+		}
+		if comparisons > height+1 || int64(comparisons) != count {
+			t.Fatalf("GetWithComparisons(%d) reported %d comparisons, made %d, height %d", key, comparisons, count, height)
+		}
+	}
+	if _, _, n := NewTree().GetWithComparisons(1); n != 0 {
+		t.Errorf("lookup in an empty tree took %d comparisons", n)
+	}
+}
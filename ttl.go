@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+)
+
+// PutTTL saves the mapping (key, data) like Put, but the entry expires
+// once `ttl` has elapsed on the tree's clock (Options.Now). Expired entries
+// are invisible to Get, Has and range queries, yet keep their node, and
+// count towards Size, until Sweep removes them. A later Put of the same key
+// makes it permanent again.
+// The expiry is not journaled: replaying a journal restores the entry
+// without it.
+func (t *Tree) PutTTL(key, data interface{}, ttl time.Duration) error {
+	key = t.normalize(key)
+	if err := t.Put(key, data); err != nil {
+		return err
+	}
+	_, node := t.getNode(key)
+	node.expires = t.now().Add(ttl)
+	return nil
+}
+
+// Sweep deletes every entry that has expired at `now` and returns how many
+// were removed. The tree never sweeps on its own; call it from a ticker of
+// your choice. Expiry only concerns the tree: Options.Writer is not told.
+// An entry whose removal fails (see Tree.Journal) stays and is not counted.
+func (t *Tree) Sweep(now time.Time) uint64 {
+	if t.mutable("Sweep") != nil {
+		return 0
+	}
+	defer t.beginOp("sweep")()
+	var stale []interface{}
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step == stepIn && !n.expires.IsZero() && !now.Before(n.expires) {
+			stale = append(stale, n.Key)
+		}
+	})
+	var removed uint64
+	for _, key := range stale {
+		if err := t.erase(key); err != nil {
+			t.tracef(LevelError, "Sweep: %s\n", err.Error())
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		t.tracef(LevelOp, "Sweep removed %d expired entries\n", removed)
+	}
+	return removed
+}
+
+func (t *Tree) now() time.Time {
+	if t.opts.Now != nil {
+		return t.opts.Now()
+	}
+	return time.Now()
+}
+
+// expired reports whether n was saved with PutTTL and its time is up.
+func (t *Tree) expired(n *Node) bool {
+	return n.expiredAt(t.now())
+}
+
+// expiredAt is expired with the clock read once by the caller, for walks
+// that must agree on which entries are gone.
+func (n *Node) expiredAt(now time.Time) bool {
+	return !n.expires.IsZero() && !now.Before(n.expires)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock is an Options.Now that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestPutTTLExpiryAndSweep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTreeWithOptions(IntComparator, Options{Now: clock.Now})
+	for k := 0; k < 10; k++ {
+		tree.Put(k, k)
+	}
+	for k := 10; k < 20; k++ {
+		tree.PutTTL(k, k, time.Duration(k)*time.Second)
+	}
+	tree.Put(19, 19) // a later Put makes it permanent again
+
+	clock.now = clock.now.Add(15 * time.Second)
+	for k := 0; k < 20; k++ {
+		live := k < 10 || k > 15
+		if found, _ := tree.Get(k); found != live || tree.Has(k) != live {
+			t.Errorf("key %d: Get found %v, Has = %v, want %v", k, found, tree.Has(k), live)
+		}
+	}
+	if got := tree.RangeSearch(8, 17); !reflect.DeepEqual(got, []interface{}{8, 9, 16, 17}) {
+		t.Errorf("RangeSearch(8, 17) = %v", got)
+	}
+	if tree.Size() != 20 {
+		t.Errorf("Size() = %d before Sweep, want 20", tree.Size())
+	}
+
+	if removed := tree.Sweep(clock.now); removed != 6 {
+		t.Errorf("Sweep removed %d entries, want 6", removed)
+	}
+	if tree.Size() != 14 {
+		t.Errorf("Size() = %d after Sweep, want 14", tree.Size())
+	}
+	checkRedBlack(t, tree)
+	if removed := tree.Sweep(clock.now.Add(time.Hour)); removed != 3 || !tree.Has(19) {
+		t.Errorf("second Sweep removed %d entries, Has(19) = %v", removed, tree.Has(19))
+	}
+}
+
+func TestSweepCountsOnlyErased(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTreeWithOptions(IntComparator, Options{Now: clock.Now})
+	for k := 0; k < 4; k++ {
+		tree.PutTTL(k, k, time.Second)
+	}
+	tree.Journal = &failingWriter{budget: 1}
+	if removed := tree.Sweep(clock.now.Add(time.Minute)); removed != 1 {
+		t.Errorf("Sweep removed %d entries with one journal write allowed, want 1", removed)
+	}
+	if tree.Size() != 3 {
+		t.Errorf("Size() = %d, want 3", tree.Size())
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+)
+
+var ErrorTxnClosed = errors.New("Transaction is already closed")
+
+// Txn stages mutations for Tree.Apply. Writes go straight to the tree, so
+// reads through the Txn (or the tree) observe them, while the inverse of
+// each write is kept to roll the batch back.
+type Txn struct {
+	tree      *Tree
+	inverses  []undoRecord
+	evictions []undoRecord  // reported to Options.OnEvict on commit
+	writes    []stagedWrite // handed to Options.Writer on commit
+	closed    bool
+}
+
+// stagedWrite is a write of a Txn for Options.Writer, with the inverse
+// that undoes it in the store.
+type stagedWrite struct {
+	op         Op
+	key, value interface{}
+	inverse    *undoRecord
+}
+
+// Apply runs `fn` as a transaction: if `fn` returns nil every staged write
+// is kept, otherwise (or if `fn` panics) they are all reverted, newest first,
+// and the error is returned. Reverting restores every payload, but the shape
+// of the tree may differ from the one before the transaction.
+// Before committing, the staged writes are handed to Options.Writer in
+// order. If it rejects one, the writes it already took are undone in the
+// store, newest first, the transaction is reverted and the error returned.
+// A committed transaction enters the undo history as its individual writes.
+func (t *Tree) Apply(fn func(tx *Txn) error) (err error) {
+	tx := &Txn{tree: t}
+	defer func() {
+		tx.closed = true
+		if r := recover(); r != nil {
+			tx.rollback()
+			panic(r)
+		}
+		if err == nil {
+			err = tx.writeThrough()
+		}
+		if err != nil {
+			tx.rollback()
+			return
+		}
+		for i := range tx.inverses {
+			t.pushHistory(&tx.inverses[i])
+		}
+		for i := range tx.evictions {
+			t.reportEviction(&tx.evictions[i])
+		}
+	}()
+	return fn(tx)
+}
+
+// writeThrough hands the staged writes to Options.Writer.
+func (tx *Txn) writeThrough() error {
+	writer := tx.tree.opts.Writer
+	for i, w := range tx.writes {
+		if err := writer(w.op, w.key, w.value); err != nil {
+			tx.tree.tracef(LevelError, "Txn: %s of %v was rejected by the writer: %s\n", w.op, w.key, err.Error())
+			for j := i - 1; j >= 0; j-- {
+				err = writer.undo(tx.writes[j].inverse, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stage records a write for Options.Writer, if any. `inverse` is the
+// mapping of `key` before the write.
+func (tx *Txn) stage(op Op, key, value interface{}, inverse *undoRecord) {
+	if tx.tree.opts.Writer != nil {
+		tx.writes = append(tx.writes, stagedWrite{op: op, key: key, value: value, inverse: inverse})
+	}
+}
+
+func (tx *Txn) rollback() {
+	for i := len(tx.inverses) - 1; i >= 0; i-- {
+		if err := tx.tree.revert(tx.inverses[i]); err != nil {
+			tx.tree.tracef(LevelError, "Txn: rollback of key %v failed: %s\n", tx.inverses[i].key, err.Error())
+		}
+	}
+	tx.inverses = nil
+}
+
+// Put stages the mapping (key, data), with the semantics of Tree.Put.
+func (tx *Txn) Put(key interface{}, data interface{}) error {
+	if tx.closed {
+		return ErrorTxnClosed
+	}
+	t := tx.tree
+	if err := t.mutable("Txn.Put"); err != nil {
+		return err
+	}
+	key = t.normalize(key)
+	inverse := t.captureInverse(key)
+	evicted := t.evictFor(key)
+	if err := t.put(key, data); err != nil {
+		t.rollback(evicted)
+		return err
+	}
+	tx.stage(OpPut, key, data, inverse)
+	if evicted != nil {
+		tx.inverses = append(tx.inverses, *evicted)
+		tx.evictions = append(tx.evictions, *evicted)
+		if err := t.journalRecord(journalDelete, evicted.key, nil); err != nil {
+			tx.inverses = append(tx.inverses, *inverse)
+			return err
+		}
+	}
+	tx.inverses = append(tx.inverses, *inverse)
+	return t.journalRecord(journalPut, key, data)
+}
+
+// Delete stages the removal of `key`, with the semantics of Tree.Delete.
+func (tx *Txn) Delete(key interface{}) error {
+	if tx.closed {
+		return ErrorTxnClosed
+	}
+	t := tx.tree
+	if err := t.mutable("Txn.Delete"); err != nil {
+		return err
+	}
+	key = t.normalize(key)
+	inverse := t.captureInverse(key)
+	tx.stage(OpDelete, key, nil, inverse)
+	if !t.remove(key) {
+		return nil
+	}
+	tx.inverses = append(tx.inverses, *inverse)
+	return t.journalRecord(journalDelete, key, nil)
+}
+
+// Get reads through to the tree, including writes staged so far.
+func (tx *Txn) Get(key interface{}) (bool, interface{}) {
+	return tx.tree.Get(key)
+}
+
+// Has reads through to the tree, including writes staged so far.
+func (tx *Txn) Has(key interface{}) bool {
+	return tx.tree.Has(key)
+}
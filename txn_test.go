@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyFailingBatchLeavesTreeUntouched(t *testing.T) {
+	tree := newIntTree(t, 5, 3, 8, 1, 4)
+	before := tree.Items()
+	errInvalid := errors.New("invalid entry")
+	err := tree.Apply(func(tx *Txn) error {
+		tx.Put(2, 2)
+		tx.Put(5, "five")
+		tx.Delete(8)
+		tx.Delete(42)
+		if found, v := tx.Get(5); !found || v != "five" {
+			t.Errorf("staged write invisible: Get(5) = %v, %v", found, v)
+		}
+		if tx.Has(8) {
+			t.Error("staged delete invisible")
+		}
+		return errInvalid
+	})
+	if err != errInvalid {
+		t.Fatalf("got %v, want the batch's error", err)
+	}
+	checkRedBlack(t, tree)
+	if got := tree.Items(); !reflect.DeepEqual(got, before) {
+		t.Fatalf("failed batch left %v, want %v", got, before)
+	}
+}
+
+func TestApplyPanicRollsBack(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	before := tree.Items()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic was swallowed")
+			}
+		}()
+		tree.Apply(func(tx *Txn) error {
+			tx.Delete(2)
+			panic("boom")
+		})
+	}()
+	if got := tree.Items(); !reflect.DeepEqual(got, before) {
+		t.Fatalf("panicking batch left %v, want %v", got, before)
+	}
+}
+
+func TestApplyCommits(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3)
+	tree.EnableHistory(10)
+	journal := &failingWriter{budget: 100}
+	tree.Journal = journal
+	var staged *Txn
+	err := tree.Apply(func(tx *Txn) error {
+		staged = tx
+		tx.Put(4, 4)
+		tx.Put(1, "one")
+		return tx.Delete(2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{1, "one"}, {3, 3}, {4, 4}}
+	if got := tree.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if journal.budget != 97 {
+		t.Errorf("journaled %d records, want 3", 100-journal.budget)
+	}
+	if tree.HistoryLen() != 3 {
+		t.Errorf("HistoryLen() = %d, want 3", tree.HistoryLen())
+	}
+	if err := staged.Put(9, 9); err != ErrorTxnClosed {
+		t.Errorf("Put on a closed Txn: got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// GetTyped looks up `key` like Tree.Get and asserts its payload to V.
+// It returns the zero V and false if the key is absent or its payload is
+// not a V.
+func GetTyped[V any](t *Tree, key interface{}) (V, bool) {
+	var zero V
+	found, payload := t.Get(key)
+	if !found {
+		return zero, false
+	}
+	v, ok := payload.(V)
+	if !ok {
+		return zero, false
+	}
+	return v, true
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetTyped(t *testing.T) {
+	tree := NewTree()
+	tree.Put(1, "one")
+	tree.Put(2, 2.5)
+	tree.Put(3, nil)
+
+	if v, ok := GetTyped[string](tree, 1); !ok || v != "one" {
+		t.Errorf("hit: GetTyped[string](1) = %q, %v", v, ok)
+	}
+	if v, ok := GetTyped[string](tree, 9); ok || v != "" {
+		t.Errorf("miss: GetTyped[string](9) = %q, %v", v, ok)
+	}
+	if v, ok := GetTyped[string](tree, 2); ok || v != "" {
+		t.Errorf("wrong type: GetTyped[string](2) = %q, %v", v, ok)
+	}
+	if v, ok := GetTyped[*int](tree, 3); ok || v != nil {
+		t.Errorf("nil payload: GetTyped[*int](3) = %v, %v", v, ok)
+	}
+	if v, ok := GetTyped[interface{}](tree, 2); !ok || v != 2.5 {
+		t.Errorf("GetTyped[interface{}](2) = %v, %v", v, ok)
+	}
+}
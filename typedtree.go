@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+)
+
+// IntTree is a red-black tree specialized for int keys. Keys are stored
+// and compared as plain ints, without the interface boxing and comparator
+// calls of Tree, which makes it the faster choice for int-keyed maps that
+// don't need the extras of Tree (normalizers, journal, history, ...).
+type IntTree struct {
+	typedTree[int]
+}
+
+// NewIntTree returns an empty IntTree.
+func NewIntTree() *IntTree {
+	return &IntTree{}
+}
+
+// StringTree is the string-keyed counterpart of IntTree; keys are ordered
+// bytewise, like StringComparator.
+type StringTree struct {
+	typedTree[string]
+}
+
+// NewStringTree returns an empty StringTree.
+func NewStringTree() *StringTree {
+	return &StringTree{}
+}
+
+// orderedKey lists the key types compared with the built-in operators.
+type orderedKey interface {
+	~int | ~string
+}
+
+type typedNode[K orderedKey] struct {
+	key                 K
+	payload             interface{}
+	color               Color
+	left, right, parent *typedNode[K]
+}
+
+// typedTree carries the algorithms of Tree over to keys of a concrete
+// type; IntTree and StringTree are instances of it.
+type typedTree[K orderedKey] struct {
+	root *typedNode[K]
+	size uint64
+}
+
+// Get looks for the node with supplied key and returns its mapped payload.
+// Return value in 1st position indicates whether any payload was found.
+func (t *typedTree[K]) Get(key K) (bool, interface{}) {
+	if n := t.find(key); n != nil {
+		return true, n.payload
+	}
+	return false, nil
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (t *typedTree[K]) Has(key K) bool {
+	return t.find(key) != nil
+}
+
+// Size returns the number of items in the tree.
+func (t *typedTree[K]) Size() uint64 {
+	return t.size
+}
+
+func (t *typedTree[K]) find(key K) *typedNode[K] {
+	n := t.root
+	for n != nil {
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// Put saves the mapping (key, data) into the tree.
+// If a mapping identified by `key` already exists, it is overwritten.
+func (t *typedTree[K]) Put(key K, data interface{}) {
+	var parent *typedNode[K]
+	n := t.root
+	for n != nil {
+		parent = n
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			n.payload = data
+			return
+		}
+	}
+	z := &typedNode[K]{key: key, payload: data, color: RED, parent: parent}
+	switch {
+	case parent == nil:
+		t.root = z
+	case key < parent.key:
+		parent.left = z
+	default:
+		parent.right = z
+	}
+	t.size++
+	t.fixupPut(z)
+}
+
+func (t *typedTree[K]) fixupPut(z *typedNode[K]) {
+	for z.parent != nil && z.parent.color == RED {
+		grandparent := z.parent.parent
+		if grandparent == nil {
+			break
+		}
+		if z.parent == grandparent.left {
+			if y := grandparent.right; y != nil && y.color == RED {
+				z.parent.color, y.color, grandparent.color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == z.parent.right {
+				z = z.parent
+				t.rotateLeft(z)
+			}
+			z.parent.color, grandparent.color = BLACK, RED
+			t.rotateRight(grandparent)
+		} else {
+			if y := grandparent.left; y != nil && y.color == RED {
+				z.parent.color, y.color, grandparent.color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == z.parent.left {
+				z = z.parent
+				t.rotateRight(z)
+			}
+			z.parent.color, grandparent.color = BLACK, RED
+			t.rotateLeft(grandparent)
+		}
+	}
+	t.root.color = BLACK
+}
+
+func (t *typedTree[K]) rotateLeft(x *typedNode[K]) {
+	y := x.right
+	x.right = y.left
+	if y.left != nil {
+		y.left.parent = x
+	}
+	t.replace(x, y)
+	y.left = x
+	x.parent = y
+}
+
+func (t *typedTree[K]) rotateRight(y *typedNode[K]) {
+	x := y.left
+	y.left = x.right
+	if x.right != nil {
+		x.right.parent = y
+	}
+	t.replace(y, x)
+	x.right = y
+	y.parent = x
+}
+
+// replace puts v where u hangs from its parent.
+func (t *typedTree[K]) replace(u, v *typedNode[K]) {
+	switch {
+	case u.parent == nil:
+		t.root = v
+	case u == u.parent.left:
+		u.parent.left = v
+	default:
+		u.parent.right = v
+	}
+	if v != nil {
+		v.parent = u.parent
+	}
+}
+
+// Delete removes the item identified by the supplied key.
+// Delete is a noop if the supplied key doesn't exist.
+func (t *typedTree[K]) Delete(key K) {
+	z := t.find(key)
+	if z == nil {
+		return
+	}
+	y, yColor := z, z.color
+	var x, xParent *typedNode[K]
+	switch {
+	case z.left == nil:
+		x, xParent = z.right, z.parent
+		t.replace(z, z.right)
+	case z.right == nil:
+		x, xParent = z.left, z.parent
+		t.replace(z, z.left)
+	default:
+		y = z.right
+		for y.left != nil {
+			y = y.left
+		}
+		yColor, x = y.color, y.right
+		if y.parent == z {
+			xParent = y
+		} else {
+			xParent = y.parent
+			t.replace(y, y.right)
+			y.right = z.right
+			y.right.parent = y
+		}
+		t.replace(z, y)
+		y.left = z.left
+		y.left.parent = y
+		y.color = z.color
+	}
+	if yColor == BLACK {
+		t.fixupDelete(x, xParent)
+	}
+	t.size--
+}
+
+func isRedTyped[K orderedKey](n *typedNode[K]) bool {
+	return n != nil && n.color == RED
+}
+
+func (t *typedTree[K]) fixupDelete(x, parent *typedNode[K]) {
+	for x != t.root && !isRedTyped(x) {
+		if x == parent.left {
+			w := parent.right
+			if isRedTyped(w) {
+				w.color, parent.color = BLACK, RED
+				t.rotateLeft(parent)
+				w = parent.right
+			}
+			if !isRedTyped(w.left) && !isRedTyped(w.right) {
+				w.color = RED
+				x, parent = parent, parent.parent
+				continue
+			}
+			if !isRedTyped(w.right) {
+				w.left.color, w.color = BLACK, RED
+				t.rotateRight(w)
+				w = parent.right
+			}
+			w.color, parent.color, w.right.color = parent.color, BLACK, BLACK
+			t.rotateLeft(parent)
+		} else {
+			w := parent.left
+			if isRedTyped(w) {
+				w.color, parent.color = BLACK, RED
+				t.rotateRight(parent)
+				w = parent.left
+			}
+			if !isRedTyped(w.left) && !isRedTyped(w.right) {
+				w.color = RED
+				x, parent = parent, parent.parent
+				continue
+			}
+			if !isRedTyped(w.left) {
+				w.right.color, w.color = BLACK, RED
+				t.rotateLeft(w)
+				w = parent.left
+			}
+			w.color, parent.color, w.left.color = parent.color, BLACK, BLACK
+			t.rotateRight(parent)
+		}
+		x, parent = t.root, nil
+	}
+	if x != nil {
+		x.color = BLACK
+	}
+}
+
+// Range returns the keys within [low, high] in ascending order.
+func (t *typedTree[K]) Range(low, high K) []K {
+	keys := []K{}
+	var stack []*typedNode[K]
+	n := t.root
+	for {
+		for n != nil {
+			if n.key < low {
+				n = n.right
+				continue
+			}
+			stack = append(stack, n)
+			n = n.left
+		}
+		if len(stack) == 0 || stack[len(stack)-1].key > high {
+			return keys
+		}
+		n = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		keys = append(keys, n.key)
+		n = n.right
+	}
+}
+
+// CheckInvariants verifies the same properties as Tree.CheckInvariants.
+func (t *typedTree[K]) CheckInvariants() error {
+	if t.root == nil {
+		return nil
+	}
+	if t.root.parent != nil || t.root.color != BLACK {
+		return fmt.Errorf("%w: root %v has a parent or is red", ErrorInvariantViolated, t.root.key)
+	}
+	var check func(n, lo, hi *typedNode[K]) (int, error)
+	check = func(n, lo, hi *typedNode[K]) (int, error) {
+		if n == nil {
+			return 1, nil
+		}
+		if (lo != nil && lo.key >= n.key) || (hi != nil && n.key >= hi.key) {
+			return 0, fmt.Errorf("%w: %v is out of order", ErrorInvariantViolated, n.key)
+		}
+		for _, child := range []*typedNode[K]{n.left, n.right} {
+			if child != nil && child.parent != n {
+				return 0, fmt.Errorf("%w: %v does not point back to parent %v", ErrorInvariantViolated, child.key, n.key)
+			}
+			if child != nil && n.color == RED && child.color == RED {
+				return 0, fmt.Errorf("%w: red %v has red child %v", ErrorInvariantViolated, n.key, child.key)
+			}
+		}
+		left, err := check(n.left, lo, n)
+		if err != nil {
+			return 0, err
+		}
+		right, err := check(n.right, n, hi)
+		if err != nil {
+			return 0, err
+		}
+		if left != right {
+			return 0, fmt.Errorf("%w: black heights of %v differ (%d left, %d right)", ErrorInvariantViolated, n.key, left, right)
+		}
+		if n.color == BLACK {
+			left++
+		}
+		return left, nil
+	}
+	_, err := check(t.root, nil, nil)
+	return err
+}
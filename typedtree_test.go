@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkTypedTree runs random Puts and Deletes of the keys made by `key`
+// against `tree` and a map, checking contents and invariants throughout.
+func checkTypedTree[K orderedKey](t *testing.T, tree *typedTree[K], key func(i int) K) {
+	rnd := rand.New(rand.NewSource(1))
+	want := map[K]int{}
+	for i := 0; i < 5000; i++ {
+		k := key(rnd.Intn(500))
+		if rnd.Intn(3) == 0 {
+			tree.Delete(k)
+			delete(want, k)
+		} else {
+			tree.Put(k, i)
+			want[k] = i
+		}
+		if i%100 == 0 {
+			if err := tree.CheckInvariants(); err != nil {
+				t.Fatalf("after %d operations: %s", i+1, err)
+			}
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size() != uint64(len(want)) {
+		t.Fatalf("Size() = %d, want %d", tree.Size(), len(want))
+	}
+	var keys []K
+	for k, v := range want {
+		keys = append(keys, k)
+		if found, got := tree.Get(k); !found || got != v {
+			t.Fatalf("Get(%v) = %v, %v, want %d", k, found, got, v)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if _, ok := want[key(i)]; !ok && tree.Has(key(i)) {
+			t.Fatalf("Has(%v) after its deletion", key(i))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	if len(keys) > 0 {
+		if got := tree.Range(keys[0], keys[len(keys)-1]); !reflect.DeepEqual(got, keys) {
+			t.Fatalf("Range over all keys = %v, want %v", got, keys)
+		}
+	}
+}
+
+func TestIntTree(t *testing.T) {
+	tree := NewIntTree()
+	checkTypedTree(t, &tree.typedTree, func(i int) int { return i })
+	if got := tree.Range(12, 10); len(got) != 0 {
+		t.Errorf("inverted Range(12, 10) = %v", got)
+	}
+}
+
+func TestStringTree(t *testing.T) {
+	tree := NewStringTree()
+	checkTypedTree(t, &tree.typedTree, strconv.Itoa)
+
+	// bytewise, like StringComparator: "10" sorts before "9"
+	tree = NewStringTree()
+	for _, k := range []string{"9", "10", "b", "B", "a"} {
+		tree.Put(k, nil)
+	}
+	if got, want := tree.Range("", "z"), []string{"10", "9", "B", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Range = %v, want %v", got, want)
+	}
+}
+
+// benchKeys returns the 1M keys of the benchmarks in a fixed random order.
+func benchKeys() []int {
+	return rand.New(rand.NewSource(1)).Perm(1000000)
+}
+
+func BenchmarkIntTreePut(b *testing.B) {
+	keys := benchKeys()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := NewIntTree()
+		for _, k := range keys {
+			tree.Put(k, nil)
+		}
+	}
+}
+
+func BenchmarkTreePut(b *testing.B) {
+	keys := benchKeys()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := NewTree()
+		for _, k := range keys {
+			tree.Put(k, nil)
+		}
+	}
+}
+
+func BenchmarkIntTreeGet(b *testing.B) {
+	keys := benchKeys()
+	tree := NewIntTree()
+	for _, k := range keys {
+		tree.Put(k, nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			tree.Get(k)
+		}
+	}
+}
+
+func BenchmarkTreeGet(b *testing.B) {
+	keys := benchKeys()
+	tree := NewTree()
+	for _, k := range keys {
+		tree.Put(k, nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			tree.Get(k)
+		}
+	}
+}
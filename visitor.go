@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// TraversalOrder selects when a node is handed to an OrderedVisitor
+// relative to its subtrees.
+type TraversalOrder byte
+
+const (
+	PreOrder TraversalOrder = iota
+	InOrder
+	PostOrder
+)
+
+func (o TraversalOrder) String() string {
+	switch o {
+	case PreOrder:
+		return "preorder"
+	case InOrder:
+		return "inorder"
+	case PostOrder:
+		return "postorder"
+	default:
+		return "not recognized"
+	}
+}
+
+// OrderedVisitor calls Fn for every node of the tree in the
+// configured Order. Nil subtrees are skipped; Fn never sees nil.
+type OrderedVisitor struct {
+	Order TraversalOrder
+	Fn    func(*Node)
+}
+
+func (v *OrderedVisitor) Visit(node *Node) {
+	traverse(node, v.step)
+}
+
+func (v *OrderedVisitor) step(step walkStep, n *Node) {
+	if v.Fn == nil {
+		return
+	}
+	want := stepIn
+	switch v.Order {
+	case PreOrder:
+		want = stepPre
+	case PostOrder:
+		want = stepPost
+	}
+	if step == want {
+		v.Fn(n)
+	}
+}
+
+// PreorderVisitor renders the tree like InorderVisitor, but with every
+// node before its subtrees: "(key left right)". Together with the
+// rendering of InorderVisitor it pins down the shape of the tree.
+// This visitor maintains internal state; thus do not
+// reuse after the completion of a walk.
+type PreorderVisitor struct {
+	IncludeColor   bool
+	IncludePayload bool
+	KeyFormat      func(key interface{}) string // nil means %d
+
+	buffer bytes.Buffer
+}
+
+func (v *PreorderVisitor) Eq(other *PreorderVisitor) bool {
+	if other == nil {
+		return false
+	}
+	return v.String() == other.String()
+}
+
+func (v *PreorderVisitor) String() string {
+	return v.buffer.String()
+}
+
+func (v *PreorderVisitor) Visit(node *Node) {
+	traverse(node, v.step)
+}
+
+func (v *PreorderVisitor) step(step walkStep, n *Node) {
+	writeParenthesized(&v.buffer, step, n, stepPre, func(n *Node) string {
+		return nodeLabel(n, v.IncludeColor, v.IncludePayload, v.KeyFormat)
+	})
+}
+
+// PostorderVisitor renders the tree like InorderVisitor, but with every
+// node after its subtrees: "(left right key)".
+// This visitor maintains internal state; thus do not
+// reuse after the completion of a walk.
+type PostorderVisitor struct {
+	IncludeColor   bool
+	IncludePayload bool
+	KeyFormat      func(key interface{}) string // nil means %d
+
+	buffer bytes.Buffer
+}
+
+func (v *PostorderVisitor) Eq(other *PostorderVisitor) bool {
+	if other == nil {
+		return false
+	}
+	return v.String() == other.String()
+}
+
+func (v *PostorderVisitor) String() string {
+	return v.buffer.String()
+}
+
+func (v *PostorderVisitor) Visit(node *Node) {
+	traverse(node, v.step)
+}
+
+func (v *PostorderVisitor) step(step walkStep, n *Node) {
+	writeParenthesized(&v.buffer, step, n, stepPost, func(n *Node) string {
+		return nodeLabel(n, v.IncludeColor, v.IncludePayload, v.KeyFormat)
+	})
+}
+
+// writeParenthesized renders one traversal step: "(" on entering a node,
+// ")" on leaving it, "." for an empty subtree, and the label of the node
+// at step `at`.
+func writeParenthesized(buf *bytes.Buffer, step walkStep, n *Node, at walkStep, label func(*Node) string) {
+	switch step {
+	case stepNil:
+		buf.WriteString(".")
+		return
+	case stepPre:
+		buf.WriteString("(")
+	}
+	if step == at {
+		buf.WriteString(label(n))
+	}
+	if step == stepPost {
+		buf.WriteString(")")
+	}
+}
+
+// nodeLabel formats the key of n, and optionally its color and payload,
+// as the string visitors render it.
+func nodeLabel(n *Node, color, payload bool, keyFormat func(interface{}) string) string {
+	label := fmt.Sprintf("%d", n.Key)
+	if keyFormat != nil {
+		label = keyFormat(n.Key)
+	}
+	if color {
+		label += "{" + n.color.Short() + "}"
+	}
+	if payload {
+		label += fmt.Sprintf(":%v", n.payload)
+	}
+	return label
+}
+
+// CollectVisitor gathers the entries of the visited nodes into Entries,
+// in ascending key order. It is the easy way to get the whole tree, with
+// payloads, as a slice:
+//
+//	c := &CollectVisitor{SizeHint: int(t.Size())}
+//	t.Walk(c)
+type CollectVisitor struct {
+	Entries []Entry
+	// SizeHint, if set, preallocates room for that many entries.
+	SizeHint int
+	// Filter, if set, keeps only the entries it returns true for.
+	Filter func(Entry) bool
+}
+
+func (v *CollectVisitor) Visit(node *Node) {
+	traverse(node, v.step)
+}
+
+func (v *CollectVisitor) step(step walkStep, n *Node) {
+	if step != stepIn {
+		return
+	}
+	if v.Entries == nil && v.SizeHint > 0 {
+		v.Entries = make([]Entry, 0, v.SizeHint)
+	}
+	e := Entry{Key: n.Key, Value: n.payload}
+	if v.Filter == nil || v.Filter(e) {
+		v.Entries = append(v.Entries, e)
+	}
+}
+
+// VisitorFunc adapts a plain function to a Visitor that calls it for
+// every node of the tree in ascending key order.
+type VisitorFunc func(*Node)
+
+func (f VisitorFunc) Visit(node *Node) {
+	traverse(node, f.step)
+}
+
+func (f VisitorFunc) step(step walkStep, n *Node) {
+	if step == stepIn {
+		f(n)
+	}
+}
+
+// MultiVisitor returns a Visitor that runs all of `vs` over a single
+// traversal, handing every step to each of them in the given order.
+// Visitors of this package (including VisitorFunc and nested
+// MultiVisitors) share that traversal; any other visitor drives its own and
+// is handed the subtree root as Walk would.
+func MultiVisitor(vs ...Visitor) Visitor {
+	return &multiVisitor{visitors: vs}
+}
+
+type multiVisitor struct {
+	visitors []Visitor
+}
+
+func (m *multiVisitor) Visit(node *Node) {
+	for _, v := range m.visitors {
+		if _, ok := v.(stepVisitor); !ok {
+			v.Visit(node)
+		}
+	}
+	traverse(node, m.step)
+}
+
+func (m *multiVisitor) step(step walkStep, n *Node) {
+	for _, v := range m.visitors {
+		if sv, ok := v.(stepVisitor); ok {
+			sv.step(step, n)
+		}
+	}
+}
+
+// stepVisitor is implemented by the visitors of this package. Rather than
+// recursing on their own, they react to the steps of a traversal, which
+// lets the tree drive them over just a part of its nodes.
+type stepVisitor interface {
+	step(walkStep, *Node)
+}
+
+// visitNode hands a single node to a visitor during a walk driven by the
+// tree. Visitors of this package see it entered and left without its
+// subtrees. Any other visitor recurses from the node it is handed, as
+// Walk has it do, so it is handed a detached copy of the node instead,
+// without children, whose walk can't leave the part being walked.
+func visitNode(visitor Visitor, n *Node) {
+	switch v := visitor.(type) {
+	case *multiVisitor:
+		for _, each := range v.visitors {
+			visitNode(each, n)
+		}
+	case stepVisitor:
+		v.step(stepPre, n)
+		v.step(stepIn, n)
+		v.step(stepPost, n)
+	default:
+		v.Visit(detached(n))
+	}
+}
+
+// detached copies n without its links to other nodes.
+func detached(n *Node) *Node {
+	return &Node{Key: n.Key, payload: n.payload, color: n.color, Leaf: n.Leaf, expires: n.expires, weight: n.weight, version: n.version}
+}
+
+// WalkFrom visits, in ascending order, the nodes whose key is >= start,
+// so that a walk can resume where a previous one stopped.
+// Visitors of this package handle such a partial walk; any other visitor
+// gets one Visit call per node, with a copy of the node that has no
+// children, so that its own recursion stays within the walk. Changes to
+// the payload of the copy don't reach the tree.
+func (t *Tree) WalkFrom(start interface{}, visitor Visitor) {
+	for it := t.IteratorFrom(start); it.Next(); {
+		visitNode(visitor, it.node)
+	}
+}
+
+// WalkDetailed calls fn for every node in ascending key order with its
+// key, payload, color and depth (0 for the root), for renderers and
+// checks that need the shape of the tree without handling *Node. Unlike
+// Items it reports expired entries and the inner nodes of range trees too.
+// fn must not modify the tree.
+func (t *Tree) WalkDetailed(fn func(key, payload interface{}, color Color, depth int)) {
+	if t.IsEmpty() {
+		return
+	}
+	depth := -1
+	traverse(t.Root, func(step walkStep, n *Node) {
+		switch step {
+		case stepPre:
+			depth++
+		case stepIn:
+			fn(n.Key, n.payload, n.color, depth)
+		case stepPost:
+			depth--
+		}
+	})
+}
+
+// WalkRange visits, in ascending order, the nodes whose key lies within
+// [lo, hi]; a nil bound leaves that side open. Subtrees outside the range
+// are never entered. Visitors are handed the nodes as by WalkFrom.
+func (t *Tree) WalkRange(lo, hi interface{}, visitor Visitor) {
+	t.rangeWalk(t.normalize(lo), t.normalize(hi), func(n *Node) bool {
+		visitNode(visitor, n)
+		return true
+	})
+}
+
+// BoundVisitor restricts `v` to the keys within [lo, hi] ordered by `cmp`;
+// a nil bound leaves that side open. When walked, it prunes the subtrees
+// outside the range and hands the nodes in range to `v` in ascending order,
+// as WalkFrom would, which suits counting or statistics visitors of any
+// kind.
+func BoundVisitor(v Visitor, lo, hi interface{}, cmp Comparator) Visitor {
+	return &boundVisitor{visitor: v, lo: lo, hi: hi, cmp: cmp}
+}
+
+type boundVisitor struct {
+	visitor Visitor
+	lo, hi  interface{}
+	cmp     Comparator
+}
+
+func (b *boundVisitor) Visit(node *Node) {
+	rangeWalkFrom(node, b.lo, b.hi, b.cmp, func(n *Node) bool {
+		visitNode(b.visitor, n)
+		return true
+	})
+}
+
+// walkStep tells a traverse callback where the walk stands.
+type walkStep byte
+
+const (
+	stepNil  walkStep = iota // at an empty subtree; the node is nil
+	stepPre                  // entering a node, before its left subtree
+	stepIn                   // between the left and the right subtree
+	stepPost                 // leaving a node, after its right subtree
+)
+
+// traverse walks the subtree rooted at `root` depth-first and reports every
+// step to fn. It keeps an explicit stack instead of recursing, so the depth
+// of the tree is bounded by memory rather than by the goroutine stack.
+func traverse(root *Node, fn func(walkStep, *Node)) {
+	type frame struct {
+		node *Node
+		next walkStep
+	}
+	stack := []frame{{root, stepPre}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		n := top.node
+		switch {
+		case n == nil:
+			stack = stack[:len(stack)-1]
+			fn(stepNil, nil)
+		case top.next == stepPre:
+			top.next = stepIn
+			fn(stepPre, n)
+			stack = append(stack, frame{n.Left, stepPre})
+		case top.next == stepIn:
+			top.next = stepPost
+			fn(stepIn, n)
+			stack = append(stack, frame{n.Right, stepPre})
+		default:
+			stack = stack[:len(stack)-1]
+			fn(stepPost, n)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderedVisitor(t *testing.T) {
+	// 4 is the root, with 2 (1, 3) and 6 (5, 7) below it
+	tree := newIntTree(t, 4, 2, 6, 1, 3, 5, 7)
+	for _, tc := range []struct {
+		order TraversalOrder
+		want  []interface{}
+	}{
+		{PreOrder, []interface{}{4, 2, 1, 3, 6, 5, 7}},
+		{InOrder, []interface{}{1, 2, 3, 4, 5, 6, 7}},
+		{PostOrder, []interface{}{1, 3, 2, 5, 7, 6, 4}},
+	} {
+		var got []interface{}
+		tree.Walk(&OrderedVisitor{Order: tc.order, Fn: func(n *Node) {
+			got = append(got, n.Key)
+		}})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestOrderedVisitorEmptyTree(t *testing.T) {
+	calls := 0
+	NewTree().Walk(&OrderedVisitor{Order: PreOrder, Fn: func(*Node) { calls++ }})
+	if calls != 0 {
+		t.Errorf("Fn was called %d times on an empty tree", calls)
+	}
+}
+
+func TestTraversalOfDeepChain(t *testing.T) {
+	// a right-leaning chain far deeper than recursion would tolerate
+	const depth = 1000000
+	var root, last *Node
+	for k := 0; k < depth; k++ {
+		n := &Node{Key: k, color: BLACK, parent: last}
+		if last == nil {
+			root = n
+		} else {
+			last.Right = n
+		}
+		last = n
+	}
+	tree := &Tree{Root: root, cmp: IntComparator}
+	if got := tree.Size(); got != depth {
+		t.Fatalf("Size() = %d, want %d", got, depth)
+	}
+	keys := tree.Keys()
+	if len(keys) != depth || keys[0] != 0 || keys[depth-1] != depth-1 {
+		t.Fatalf("Keys() returned %d keys from %v to %v", len(keys), keys[0], keys[len(keys)-1])
+	}
+	var post []interface{}
+	tree.Walk(&OrderedVisitor{Order: PostOrder, Fn: func(n *Node) {
+		post = append(post, n.Key)
+	}})
+	if len(post) != depth || post[0] != depth-1 {
+		t.Fatalf("post-order walk visited %d nodes starting at %v", len(post), post[0])
+	}
+}
+
+// keyCollector is a Visitor from outside the package: it recurses from the
+// node it is handed, as Walk expects.
+type keyCollector struct {
+	keys []interface{}
+}
+
+func (c *keyCollector) Visit(n *Node) {
+	if n == nil {
+		return
+	}
+	c.Visit(n.Left)
+	c.keys = append(c.keys, n.Key)
+	c.Visit(n.Right)
+}
+
+func TestWalkFrom(t *testing.T) {
+	tree := newIntTree(t, 10, 20, 30, 40, 50, 60, 70)
+	for _, tc := range []struct {
+		start int
+		want  []interface{}
+	}{
+		{35, []interface{}{40, 50, 60, 70}},
+		{40, []interface{}{40, 50, 60, 70}},
+		{0, []interface{}{10, 20, 30, 40, 50, 60, 70}},
+		{71, nil},
+	} {
+		var got []interface{}
+		tree.WalkFrom(tc.start, &OrderedVisitor{Order: InOrder, Fn: func(n *Node) {
+			got = append(got, n.Key)
+		}})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("WalkFrom(%d) with OrderedVisitor = %v, want %v", tc.start, got, tc.want)
+		}
+
+		collector := &keyCollector{}
+		tree.WalkFrom(tc.start, collector)
+		if !reflect.DeepEqual(collector.keys, tc.want) {
+			t.Errorf("WalkFrom(%d) with a recursive visitor = %v, want %v", tc.start, collector.keys, tc.want)
+		}
+	}
+}
+
+// stepCounter wraps a visitor of this package and counts the walks it
+// drives itself and the traversal steps it is handed.
+type stepCounter struct {
+	inner  stepVisitor
+	visits int
+	steps  int
+}
+
+func (c *stepCounter) Visit(n *Node) {
+	c.visits++
+	traverse(n, c.step)
+}
+
+func (c *stepCounter) step(step walkStep, n *Node) {
+	c.steps++
+	c.inner.step(step, n)
+}
+
+func TestMultiVisitorSharesOneTraversal(t *testing.T) {
+	tree := newIntTree(t, 50, 20, 80, 10, 30, 70, 90, 60)
+	separateCount, separateInorder := &countingVisitor{}, &InorderVisitor{}
+	tree.Walk(separateCount)
+	tree.Walk(separateInorder)
+
+	count := &stepCounter{inner: &countingVisitor{}}
+	inorder := &stepCounter{inner: &InorderVisitor{}}
+	var keys []interface{}
+	tree.Walk(MultiVisitor(count, inorder, VisitorFunc(func(n *Node) {
+		keys = append(keys, n.Key)
+	})))
+
+	if got := count.inner.(*countingVisitor).Count; got != separateCount.Count {
+		t.Errorf("counted %d nodes, want %d", got, separateCount.Count)
+	}
+	if got := inorder.inner.(*InorderVisitor).String(); got != separateInorder.String() {
+		t.Errorf("inorder rendering %q, want %q", got, separateInorder.String())
+	}
+	if !reflect.DeepEqual(keys, tree.Keys()) {
+		t.Errorf("VisitorFunc saw %v", keys)
+	}
+	// 8 nodes and their 9 empty subtrees: 3 steps per node, 1 per nil
+	if count.visits != 0 || inorder.visits != 0 || count.steps != 33 || inorder.steps != 33 {
+		t.Errorf("visitors drove %d and %d walks and saw %d and %d steps, want 0, 0, 33, 33",
+			count.visits, inorder.visits, count.steps, inorder.steps)
+	}
+}
+
+func TestWalkFromMultiVisitor(t *testing.T) {
+	tree := newIntTree(t, 1, 2, 3, 4, 5)
+	collector := &keyCollector{}
+	var keys []interface{}
+	tree.WalkFrom(3, MultiVisitor(collector, VisitorFunc(func(n *Node) {
+		keys = append(keys, n.Key)
+	})))
+	want := []interface{}{3, 4, 5}
+	if !reflect.DeepEqual(collector.keys, want) || !reflect.DeepEqual(keys, want) {
+		t.Errorf("WalkFrom(3) handed %v and %v, want %v", collector.keys, keys, want)
+	}
+}
+
+func TestBoundVisitorMatchesRangeSearch(t *testing.T) {
+	for _, cmp := range []Comparator{IntComparator, descendingInts} {
+		tree := NewTreeWith(cmp)
+		for k := 0; k < 300; k += 7 {
+			tree.Put(k, nil)
+		}
+		for _, r := range [][2]interface{}{{10, 100}, {nil, 50}, {200, nil}, {nil, nil}, {50, 10}} {
+			lo, hi := r[0], r[1]
+			want := tree.RangeSearch(lo, hi)
+
+			counter := &countingVisitor{}
+			tree.Walk(BoundVisitor(counter, lo, hi, cmp))
+			if counter.Count != uint64(len(want)) {
+				t.Errorf("bounded countingVisitor over [%v, %v] counted %d, want %d", lo, hi, counter.Count, len(want))
+			}
+
+			collector := &keyCollector{}
+			tree.WalkRange(lo, hi, collector)
+			if len(collector.keys) != len(want) || len(want) > 0 && !reflect.DeepEqual(collector.keys, want) {
+				t.Errorf("WalkRange(%v, %v) handed %v, want %v", lo, hi, collector.keys, want)
+			}
+		}
+	}
+}
+
+func TestInorderVisitorOptions(t *testing.T) {
+	// 2 is the black root of red 1 and red 3
+	tree := NewTree()
+	for _, k := range []int{2, 1, 3} {
+		tree.Put(k, k*10)
+	}
+	quoted := func(key interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(key)) }
+	for _, tc := range []struct {
+		visitor *InorderVisitor
+		want    string
+	}{
+		{&InorderVisitor{}, "((.1.)2(.3.))"},
+		{&InorderVisitor{IncludeColor: true}, "((.1{R}.)2{B}(.3{R}.))"},
+		{&InorderVisitor{IncludePayload: true}, "((.1:10.)2:20(.3:30.))"},
+		{&InorderVisitor{IncludeColor: true, IncludePayload: true}, "((.1{R}:10.)2{B}:20(.3{R}:30.))"},
+		{&InorderVisitor{KeyFormat: quoted}, `((."1".)"2"(."3".))`},
+		{&InorderVisitor{KeyFormat: quoted, IncludeColor: true, IncludePayload: true}, `((."1"{R}:10.)"2"{B}:20(."3"{R}:30.))`},
+	} {
+		tree.Walk(tc.visitor)
+		if got := tc.visitor.String(); got != tc.want {
+			t.Errorf("%+v rendered %s, want %s", *tc.visitor, got, tc.want)
+		}
+	}
+	if BLACK.Short() != "B" || RED.Short() != "R" {
+		t.Errorf("Short() = %s, %s", BLACK.Short(), RED.Short())
+	}
+}
+
+// labels extracts the node labels of a parenthesized rendering in order.
+func labels(rendering string) []string {
+	return strings.FieldsFunc(rendering, func(r rune) bool {
+		return r == '(' || r == ')' || r == '.'
+	})
+}
+
+// rebuild reconstructs a tree from its preorder and inorder labels, with
+// keys parsed back from labels such as "12{R}".
+func rebuild(tb testing.TB, pre, in []string) *Node {
+	if len(pre) == 0 {
+		return nil
+	}
+	at := 0
+	for in[at] != pre[0] {
+		at++
+	}
+	var key int
+	var color string
+	if _, err := fmt.Sscanf(pre[0], "%d{%1s}", &key, &color); err != nil {
+		tb.Fatalf("label %q: %s", pre[0], err)
+	}
+	return &Node{
+		Key:   key,
+		color: color == "B",
+		Left:  rebuild(tb, pre[1:at+1], in[:at]),
+		Right: rebuild(tb, pre[at+1:], in[at+1:]),
+	}
+}
+
+func TestShapeFromPreorderAndInorder(t *testing.T) {
+	tree := NewTree()
+	for _, k := range []int{50, 20, 80, 10, 30, 70, 90, 60, 65, 5} {
+		tree.Put(k, nil)
+	}
+	pre := &PreorderVisitor{IncludeColor: true}
+	in := &InorderVisitor{IncludeColor: true}
+	post := &PostorderVisitor{IncludeColor: true}
+	tree.Walk(MultiVisitor(pre, in, post))
+
+	rebuilt := &Tree{Root: rebuild(t, labels(pre.String()), labels(in.String())), cmp: IntComparator}
+	if got, want := shapeOf(rebuilt), shapeOf(tree); got != want {
+		t.Fatalf("rebuilt shape %s, want %s", got, want)
+	}
+
+	again := &PostorderVisitor{IncludeColor: true}
+	rebuilt.Walk(again)
+	if !post.Eq(again) || post.Eq(nil) {
+		t.Errorf("postorder of the rebuilt tree %s, want %s", again, post)
+	}
+
+	small := newIntTree(t, 2, 1, 3)
+	pre, post = &PreorderVisitor{}, &PostorderVisitor{}
+	small.Walk(MultiVisitor(pre, post))
+	if pre.String() != "(2(1..)(3..))" || post.String() != "((..1)(..3)2)" {
+		t.Errorf("rendered %s and %s", pre, post)
+	}
+}
+
+func TestCollectVisitor(t *testing.T) {
+	tree := NewTree()
+	for _, k := range []int{4, 2, 6, 1, 3, 5} {
+		tree.Put(k, fmt.Sprint("v", k))
+	}
+	all := &CollectVisitor{SizeHint: int(tree.Size())}
+	tree.Walk(all)
+	want := []Entry{{1, "v1"}, {2, "v2"}, {3, "v3"}, {4, "v4"}, {5, "v5"}, {6, "v6"}}
+	if !reflect.DeepEqual(all.Entries, want) {
+		t.Errorf("collected %v, want %v", all.Entries, want)
+	}
+	if cap(all.Entries) != 6 {
+		t.Errorf("SizeHint 6 gave a capacity of %d", cap(all.Entries))
+	}
+
+	odd := &CollectVisitor{Filter: func(e Entry) bool { return e.Key.(int)%2 == 1 }}
+	tree.Walk(odd)
+	if want := []Entry{{1, "v1"}, {3, "v3"}, {5, "v5"}}; !reflect.DeepEqual(odd.Entries, want) {
+		t.Errorf("filtered %v, want %v", odd.Entries, want)
+	}
+
+	none := &CollectVisitor{}
+	NewTree().Walk(none)
+	if none.Entries != nil {
+		t.Errorf("collected %v from an empty tree", none.Entries)
+	}
+}
+
+func TestWalkDetailed(t *testing.T) {
+	walk := func(tree *Tree) string {
+		var steps []string
+		tree.WalkDetailed(func(key, payload interface{}, color Color, depth int) {
+			steps = append(steps, fmt.Sprintf("%v%s%d", key, color.Short(), depth))
+		})
+		return strings.Join(steps, " ")
+	}
+	// inner nodes of the range tree of main repeat the keys of its leaves,
+	// which sit one level deeper
+	want := "3R4 3R3 10R4 10R2 19R4 19R3 23R4 23R1 30R4 30R3 37R4 37R2 49R3 49R0 " +
+		"59R4 59R3 62R4 62R2 70R4 70R3 80R4 80R1 89R2 100R4 100R3"
+	if got := walk(exampleRangeTree()); got != want {
+		t.Errorf("range tree of main:\n got %s\nwant %s", got, want)
+	}
+	if got, want := walk(newIntTree(t, 4, 2, 6, 1, 3, 5, 7)), "1R2 2B1 3R2 4B0 5R2 6B1 7R2"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := walk(NewTree()); got != "" {
+		t.Errorf("empty tree: got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// EnableWeights augments every node with the total weight of its subtree,
+// where the weight of an entry is `weight(payload)`; weights must not be
+// negative. The sums are computed once here and then kept up to date by
+// Put, Insert, SetValue, Delete and the rotations, at O(log n) per change,
+// which is what lets WeightedRandom sample in O(log n).
+// A nil `weight` turns the augmentation off. Payloads changed through
+// Node.SetValue bypass the tree; use Tree.SetValue on a weighted tree.
+func (t *Tree) EnableWeights(weight func(payload interface{}) float64) {
+	t.weightOf = weight
+	t.reweighAll()
+}
+
+// WeightedRandom picks an entry at random with a probability proportional
+// to its weight, descending the tree once along the subtree sums. It returns
+// false if weights are not enabled or they add up to zero.
+func (t *Tree) WeightedRandom(rng *rand.Rand) (key, value interface{}, ok bool) {
+	if t.weightOf == nil || t.Root == nil || t.Root.weight <= 0 {
+		return nil, nil, false
+	}
+	r := rng.Float64() * t.Root.weight
+	n := t.Root
+	for {
+		if left := subtreeWeight(n.Left); r < left {
+			n = n.Left
+			continue
+		} else {
+			r -= left
+		}
+		own := t.weightOf(n.payload)
+		if r < own || n.Right == nil || n.Right.weight <= 0 {
+			// the last case absorbs floating point drift in the sums
+			return n.Key, n.payload, true
+		}
+		r -= own
+		n = n.Right
+	}
+}
+
+func subtreeWeight(n *Node) float64 {
+	if n == nil {
+		return 0
+	}
+	return n.weight
+}
+
+// reweigh recomputes the subtree sum of n from its children.
+func (t *Tree) reweigh(n *Node) {
+	if t.weightOf == nil {
+		return
+	}
+	n.weight = t.weightOf(n.payload) + subtreeWeight(n.Left) + subtreeWeight(n.Right)
+}
+
+// reweighPath recomputes the subtree sums from n up to the root, after
+// n's payload or children changed.
+func (t *Tree) reweighPath(n *Node) {
+	if t.weightOf == nil {
+		return
+	}
+	for ; n != nil; n = n.parent {
+		t.reweigh(n)
+	}
+}
+
+func (t *Tree) reweighAll() {
+	traverse(t.Root, func(step walkStep, n *Node) {
+		if step == stepPost {
+			if t.weightOf == nil {
+				n.weight = 0
+				return
+			}
+			t.reweigh(n)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func floatWeight(payload interface{}) float64 {
+	return payload.(float64)
+}
+
+// checkWeights verifies every subtree sum against its children.
+func checkWeights(tb testing.TB, tree *Tree) {
+	tb.Helper()
+	traverse(tree.Root, func(step walkStep, n *Node) {
+		if step != stepIn {
+			return
+		}
+		want := n.payload.(float64) + subtreeWeight(n.Left) + subtreeWeight(n.Right)
+		if math.Abs(n.weight-want) > 1e-9 {
+			tb.Fatalf("subtree weight of %v is %v, want %v", n.Key, n.weight, want)
+		}
+	})
+}
+
+func TestWeightsFollowMutations(t *testing.T) {
+	rng := rand.New(rand.NewSource(620))
+	tree := NewTree()
+	tree.EnableWeights(floatWeight)
+	for i := 0; i < 2000; i++ {
+		k := rng.Intn(300)
+		switch rng.Intn(3) {
+		case 0:
+			tree.Delete(k)
+		case 1:
+			tree.SetValue(k, rng.Float64())
+		default:
+			tree.Put(k, rng.Float64())
+		}
+	}
+	checkWeights(t, tree)
+	checkRedBlack(t, tree)
+}
+
+func TestWeightedRandomProportions(t *testing.T) {
+	tree := NewTree()
+	tree.EnableWeights(floatWeight)
+	weights := map[int]float64{1: 1, 2: 2, 3: 3, 4: 0, 5: 4, 6: 10}
+	for k, w := range weights {
+		tree.Put(k, w)
+	}
+	tree.SetValue(6, 10.0) // unchanged, but goes through the update path
+	tree.Put(7, 5.0)
+	tree.Delete(7)
+
+	const draws = 200000
+	counts := map[interface{}]int{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < draws; i++ {
+		key, value, ok := tree.WeightedRandom(rng)
+		if !ok || value != weights[key.(int)] {
+			t.Fatalf("WeightedRandom() = %v, %v, %v", key, value, ok)
+		}
+		counts[key]++
+	}
+	for k, w := range weights {
+		got, want := float64(counts[k])/draws, w/20
+		if math.Abs(got-want) > 0.01 {
+			t.Errorf("key %d drawn with frequency %.4f, want %.4f", k, got, want)
+		}
+	}
+
+	tree.EnableWeights(nil)
+	if _, _, ok := tree.WeightedRandom(rng); ok {
+		t.Error("WeightedRandom succeeded without weights")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Op names the mutation handed to a Writer.
+type Op byte
+
+const (
+	OpPut    Op = iota // the key is saved with the value
+	OpDelete           // the key is removed; the value is nil
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "Put"
+	case OpDelete:
+		return "Delete"
+	default:
+		return "Op(?)"
+	}
+}
+
+// Writer mirrors the mutations of a tree into an external store; see
+// Options.Writer. Put, Delete, SetValue, MapValues and Undo call it
+// synchronously with the normalized key before the tree changes, and
+// Tree.Apply calls it with the staged writes, in order, before the
+// transaction commits. If it fails, the tree is left unchanged and the
+// mutation returns its error as is (SetValue returns false, MapValues
+// keeps the old value). If it succeeds but the tree then fails to apply
+// the mutation (see Options.MaxHeight and Tree.Journal), the Writer is
+// called once more with the inverse: the value the tree held for the key
+// is put back, or the key is deleted if the tree held none. Deletes are
+// passed on even for keys missing from the tree, which may be a cache of
+// the store (see Loader).
+// Other mutations (evictions, Sweep, BulkPut, ...) and values saved by the
+// Loader don't go through the Writer.
+type Writer func(op Op, key, value interface{}) error
+
+// writeThrough hands the mutation to Options.Writer, if any. It returns
+// the inverse of the mutation, to be handed to undo if the tree fails to
+// apply it.
+func (t *Tree) writeThrough(name string, op Op, key, value interface{}) (*undoRecord, error) {
+	if t == nil || t.opts.Writer == nil {
+		return nil, nil
+	}
+	if err := t.mutable(name); err != nil {
+		return nil, err
+	}
+	key = t.normalize(key)
+	if err := mustBeValidKey(key); err != nil {
+		t.tracef(LevelError, "%s was prematurely aborted: %s\n", name, err.Error())
+		return nil, err
+	}
+	inverse := t.captureInverse(key)
+	if err := t.opts.Writer(op, key, value); err != nil {
+		t.tracef(LevelError, "%s of %v was rejected by the writer: %s\n", name, key, err.Error())
+		return nil, err
+	}
+	return inverse, nil
+}
+
+// undo hands the store the inverse of a write it accepted but the tree
+// failed to apply with `err`, and returns `err`.
+func (w Writer) undo(inverse *undoRecord, err error) error {
+	if w == nil || inverse == nil {
+		return err
+	}
+	if undoErr := w(writerOp(inverse.op), inverse.key, inverse.payload); undoErr != nil {
+		return fmt.Errorf("%w (undoing the write of %#v failed too: %s)", err, inverse.key, undoErr)
+	}
+	return err
+}
+
+// writerOp maps a journal op to the Op handed to a Writer.
+func writerOp(op byte) Op {
+	if op == journalDelete {
+		return OpDelete
+	}
+	return OpPut
+}
+
+// writeSlot serializes the writes of one key; refs counts the writers
+// holding or waiting for it.
+type writeSlot struct {
+	lock sync.Mutex
+	refs int
+}
+
+// incomparableKeys stands for every key of a type Go can't compare, so
+// that writes of those keys are serialized among each other.
+type incomparableKeys struct{}
+
+// writeThrough is Tree.writeThrough for the concurrent wrapper. The writer
+// is called without holding the tree lock, so slow stores don't stall
+// readers, but with a lock on the key that the caller releases with the
+// returned func once the tree has changed: there is at most one write per
+// key in flight, and the store and the tree see them in the same order.
+// The returned Writer and inverse undo the write if the tree fails to
+// apply it.
+func (ct *ConcurrentTree) writeThrough(name string, op Op, key, value interface{}) (func(), Writer, *undoRecord, error) {
+	ct.lock.RLock()
+	writer := ct.tree.opts.Writer
+	err := ct.tree.mutable(name)
+	key = ct.tree.normalize(key)
+	ct.lock.RUnlock()
+	if writer == nil {
+		return func() {}, nil, nil, nil
+	}
+	if err == nil {
+		err = mustBeValidKey(key)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	unlock := ct.lockKey(key)
+	ct.lock.RLock()
+	inverse := ct.tree.captureInverse(key)
+	ct.lock.RUnlock()
+	if err := writer(op, key, value); err != nil {
+		unlock()
+		ct.lock.RLock()
+		ct.tree.tracef(LevelError, "%s of %v was rejected by the writer: %s\n", name, key, err.Error())
+		ct.lock.RUnlock()
+		return nil, nil, nil, err
+	}
+	return unlock, writer, inverse, nil
+}
+
+// lockKey locks the write slot of the valid key `key` and returns the
+// func unlocking it.
+func (ct *ConcurrentTree) lockKey(key interface{}) func() {
+	if !reflect.TypeOf(key).Comparable() {
+		key = incomparableKeys{}
+	}
+	ct.writeLock.Lock()
+	if ct.writing == nil {
+		ct.writing = map[interface{}]*writeSlot{}
+	}
+	slot, ok := ct.writing[key]
+	if !ok {
+		slot = &writeSlot{}
+		ct.writing[key] = slot
+	}
+	slot.refs++
+	ct.writeLock.Unlock()
+	slot.lock.Lock()
+	return func() {
+		slot.lock.Unlock()
+		ct.writeLock.Lock()
+		if slot.refs--; slot.refs == 0 {
+			delete(ct.writing, key)
+		}
+		ct.writeLock.Unlock()
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errRejected = errors.New("rejected by the store")
+
+// mapStore is a Writer target mirroring the tree into a map. It rejects
+// the writes of the keys in `reject`.
+type mapStore struct {
+	lock   sync.Mutex
+	data   map[interface{}]interface{}
+	reject map[interface{}]bool
+}
+
+func newMapStore(reject ...interface{}) *mapStore {
+	s := &mapStore{data: map[interface{}]interface{}{}, reject: map[interface{}]bool{}}
+	for _, k := range reject {
+		s.reject[k] = true
+	}
+	return s
+}
+
+func (s *mapStore) write(op Op, key, value interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.reject[key] {
+		return errRejected
+	}
+	if op == OpPut {
+		s.data[key] = value
+	} else {
+		delete(s.data, key)
+	}
+	return nil
+}
+
+// checkMirrored fails unless `tree` holds exactly the entries of the store.
+func checkMirrored(tb testing.TB, tree *Tree, s *mapStore) {
+	tb.Helper()
+	got := map[interface{}]interface{}{}
+	for _, e := range tree.Items() {
+		got[e.Key] = e.Value
+	}
+	if !reflect.DeepEqual(got, s.data) {
+		tb.Fatalf("tree holds %v, store %v", got, s.data)
+	}
+}
+
+func TestWriterRejectionsLeaveTreeUnchanged(t *testing.T) {
+	store := newMapStore(7, 21, 42)
+	tree := NewTreeWithOptions(IntComparator, Options{Writer: store.write})
+	tree.EnableHistory(1000)
+	rnd := rand.New(rand.NewSource(650))
+	for i := 0; i < 5000; i++ {
+		k := rnd.Intn(50)
+		var err error
+		switch rnd.Intn(5) {
+		case 0:
+			err = tree.Delete(k)
+		case 1:
+			tree.SetValue(k, -i)
+		case 2:
+			err = tree.Undo()
+			if err == ErrorNothingToUndo {
+				err = nil
+			}
+		default:
+			err = tree.Put(k, i)
+		}
+		if err != nil && !errors.Is(err, errRejected) {
+			t.Fatal(err)
+		}
+		if store.reject[k] && tree.Has(k) {
+			t.Fatalf("rejected key %d made it into the tree", k)
+		}
+		if i%100 == 0 {
+			checkMirrored(t, tree, store)
+		}
+	}
+	checkMirrored(t, tree, store)
+
+	tree.MapValues(func(key, payload interface{}) interface{} { return key })
+	checkMirrored(t, tree, store)
+
+	err := tree.Apply(func(tx *Txn) error {
+		tx.Put(100, "a")
+		tx.Put(7, "b") // rejected on commit: the whole transaction goes
+		return nil
+	})
+	if !errors.Is(err, errRejected) || tree.Has(100) {
+		t.Errorf("Apply with a rejected write = %v, Has(100) = %v", err, tree.Has(100))
+	}
+	checkMirrored(t, tree, store)
+}
+
+func TestWriterUndoneWhenTreeFails(t *testing.T) {
+	store := newMapStore()
+	tree := NewTreeWithOptions(IntComparator, Options{Writer: store.write})
+	for k := 0; k < 5; k++ {
+		tree.Put(k, k)
+	}
+	tree.Journal = &nthFailingWriter{fail: 1}
+	if err := tree.Put(2, "new"); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Put with a failing journal = %v, want errWriteFailed", err)
+	}
+	checkMirrored(t, tree, store)
+	tree.Journal = &nthFailingWriter{fail: 1}
+	if err := tree.Put(10, 10); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Put of a new key with a failing journal = %v", err)
+	}
+	checkMirrored(t, tree, store)
+	tree.Journal = &nthFailingWriter{fail: 1}
+	if err := tree.Delete(3); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Delete with a failing journal = %v", err)
+	}
+	checkMirrored(t, tree, store)
+}
+
+func TestWriterSeesMissingDeletes(t *testing.T) {
+	var ops []Op
+	tree := NewTreeWithOptions(IntComparator, Options{Writer: func(op Op, key, value interface{}) error {
+		ops = append(ops, op)
+		return nil
+	}})
+	tree.Delete(1)
+	if !reflect.DeepEqual(ops, []Op{OpDelete}) {
+		t.Errorf("Delete of a missing key reached the writer as %v", ops)
+	}
+}
+
+func TestConcurrentWriterOneWritePerKey(t *testing.T) {
+	var lock sync.Mutex
+	inFlight := map[interface{}]bool{}
+	store := newMapStore()
+	writer := func(op Op, key, value interface{}) error {
+		lock.Lock()
+		if inFlight[key] {
+			lock.Unlock()
+			t.Errorf("two writes of key %v in flight", key)
+			return nil
+		}
+		inFlight[key] = true
+		lock.Unlock()
+		time.Sleep(10 * time.Microsecond)
+		err := store.write(op, key, value)
+		lock.Lock()
+		delete(inFlight, key)
+		lock.Unlock()
+		return err
+	}
+	tree := NewConcurrentTreeWithOptions(IntComparator, Options{Writer: writer})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 300; i++ {
+				k := rnd.Intn(4)
+				if rnd.Intn(3) == 0 {
+					tree.Delete(k)
+				} else {
+					tree.Put(k, g*1000+i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	checkMirrored(t, tree.tree, store)
+}